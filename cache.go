@@ -1,18 +1,164 @@
 package tilenol
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb/maptile"
+)
+
+const (
+	// TileDataExtension is the file extension used by caches that store tile data as files
+	TileDataExtension = ".mvt.gz"
+)
+
+var (
+	// cacheEntryMagic is the header that identifies encoded CacheEntry values
+	cacheEntryMagic = []byte("TNL1")
 )
 
 var (
 	// ErrNoValue occurs when trying to access a value that doesn't exist in the cache
 	ErrNoValue = errors.New("No value exists in cache")
+	// MultipleCachesErr occurs when more than one cache backend is configured
+	MultipleCachesErr = errors.New("Only a single cache backend can be configured")
+	// InvalidCacheTierErr occurs when a cache tier configures options that only apply to the
+	// top-level cache configuration
+	InvalidCacheTierErr = errors.New("Cache tiers cannot configure \"asyncWrites\" or staleness options, which only apply to the top-level cache")
 )
 
 // CacheConfig is a generic YAML cache configuration object
 type CacheConfig struct {
 	// Redis is an optional YAML key for configuring a RedisCache
 	Redis *RedisConfig `yaml:"redis"`
+	// InMemory is an optional YAML key for configuring an InMemoryCache
+	InMemory *InMemoryConfig `yaml:"inMemory"`
+	// Disk is an optional YAML key for configuring a DiskCache
+	Disk *DiskConfig `yaml:"disk"`
+	// S3 is an optional YAML key for configuring an S3Cache
+	S3 *S3Config `yaml:"s3"`
+	// Tiers is an optional YAML key for configuring a TieredCache, as an ordered list of cache
+	// configurations from fastest to slowest
+	Tiers []CacheConfig `yaml:"tiers"`
+	// AsyncWrites configures whether or not a TieredCache writes to its slower tiers in the
+	// background (top-level only)
+	AsyncWrites bool `yaml:"asyncWrites"`
+	// Staleness configures how the server treats aging cache entries (top-level only)
+	Staleness StalenessConfig `yaml:",inline"`
+}
+
+// StalenessConfig configures the soft and hard TTLs of cache entries, as seen by the server.
+// Note that the TTL of the cache backend itself should be at least HardTTL + StaleIfError,
+// otherwise entries are evicted before they can be served stale.
+type StalenessConfig struct {
+	// SoftTTL is the age after which cache entries are still served, but refreshed in the
+	// background (optional, defaults to never)
+	SoftTTL time.Duration `yaml:"softTTL"`
+	// HardTTL is the age after which cache entries must be recomputed before being served
+	// (optional, defaults to never)
+	HardTTL time.Duration `yaml:"hardTTL"`
+	// StaleIfError is how long after the HardTTL cache entries can still be served when the
+	// layer source fails (optional)
+	StaleIfError time.Duration `yaml:"staleIfError"`
+}
+
+// Freshness describes how a cache entry of a given age should be served
+type Freshness int
+
+const (
+	// Fresh entries are served as-is
+	Fresh Freshness = iota
+	// Stale entries are served, but refreshed in the background
+	Stale
+	// Expired entries must be recomputed, but can be served if recomputing fails
+	Expired
+	// Unusable entries must be recomputed
+	Unusable
+)
+
+// Freshness determines how a cache entry of the given age should be served
+func (c StalenessConfig) Freshness(age time.Duration) Freshness {
+	switch {
+	case c.HardTTL > 0 && age >= c.HardTTL+c.StaleIfError:
+		return Unusable
+	case c.HardTTL > 0 && age >= c.HardTTL:
+		return Expired
+	case c.SoftTTL > 0 && age >= c.SoftTTL:
+		return Stale
+	default:
+		return Fresh
+	}
+}
+
+// CacheEntry is a cached value along with the time at which it was created
+type CacheEntry struct {
+	// Data is the raw cached value
+	Data []byte
+	// CreatedAt is the time at which the value was computed (zero if unknown)
+	CreatedAt time.Time
+}
+
+// NewCacheEntry creates a new CacheEntry for a freshly computed value
+func NewCacheEntry(data []byte) *CacheEntry {
+	return &CacheEntry{Data: data, CreatedAt: time.Now()}
+}
+
+// Age returns how long ago the entry was created. Note that entries with an unknown creation
+// time are considered brand new.
+func (e *CacheEntry) Age() time.Duration {
+	if e.CreatedAt.IsZero() {
+		return 0
+	}
+	return time.Since(e.CreatedAt)
+}
+
+// Marshal encodes the entry into a raw value that can be stored in any Cache
+func (e *CacheEntry) Marshal() []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(cacheEntryMagic) + 8 + len(e.Data))
+	buf.Write(cacheEntryMagic)
+	binary.Write(&buf, binary.BigEndian, e.CreatedAt.UnixNano())
+	buf.Write(e.Data)
+	return buf.Bytes()
+}
+
+// UnmarshalCacheEntry decodes a raw cached value into a CacheEntry. Raw values that were not
+// encoded as a CacheEntry are returned as-is with an unknown creation time.
+func UnmarshalCacheEntry(raw []byte) *CacheEntry {
+	headerLen := len(cacheEntryMagic) + 8
+	if len(raw) < headerLen || !bytes.HasPrefix(raw, cacheEntryMagic) {
+		return &CacheEntry{Data: raw}
+	}
+	nanos := int64(binary.BigEndian.Uint64(raw[len(cacheEntryMagic):headerLen]))
+	return &CacheEntry{Data: raw[headerLen:], CreatedAt: time.Unix(0, nanos)}
+}
+
+// count returns the number of cache backends configured
+func (c *CacheConfig) count() int {
+	var n int
+	if c.Redis != nil {
+		n++
+	}
+	if c.InMemory != nil {
+		n++
+	}
+	if c.Disk != nil {
+		n++
+	}
+	if c.S3 != nil {
+		n++
+	}
+	if len(c.Tiers) > 0 {
+		n++
+	}
+	return n
 }
 
 // Cache is a generic interface for a tile server cache
@@ -23,11 +169,16 @@ type Cache interface {
 	Get(key string) ([]byte, error)
 	// Put stores a new value in the cache at a given key
 	Put(key string, val []byte) error
+	// Delete removes the value stored at a given key, if any
+	Delete(key string) error
 }
 
 // CreateCache creates a new generic Cache from a CacheConfig
 func CreateCache(config *CacheConfig) (Cache, error) {
 	if config != nil {
+		if config.count() > 1 {
+			return nil, MultipleCachesErr
+		}
 		if config.Redis != nil {
 			Logger.Debug("Using RedisCache configuration")
 			cache, err := NewRedisCache(config.Redis)
@@ -36,7 +187,93 @@ func CreateCache(config *CacheConfig) (Cache, error) {
 			}
 			return cache, nil
 		}
+		if config.InMemory != nil {
+			Logger.Debug("Using InMemoryCache configuration")
+			cache, err := NewInMemoryCacheFromConfig(config.InMemory)
+			if err != nil {
+				return nil, err
+			}
+			return cache, nil
+		}
+		if config.Disk != nil {
+			Logger.Debug("Using DiskCache configuration")
+			cache, err := NewDiskCache(config.Disk)
+			if err != nil {
+				return nil, err
+			}
+			return cache, nil
+		}
+		if config.S3 != nil {
+			Logger.Debug("Using S3Cache configuration")
+			cache, err := NewS3Cache(config.S3)
+			if err != nil {
+				return nil, err
+			}
+			return cache, nil
+		}
+		if len(config.Tiers) > 0 {
+			Logger.Debug("Using TieredCache configuration")
+			cache, err := NewTieredCache(config.Tiers, config.AsyncWrites)
+			if err != nil {
+				return nil, err
+			}
+			return cache, nil
+		}
 	}
 	Logger.Debug("No cache configured, falling back to NilCache implementation")
 	return &NilCache{}, nil
 }
+
+// TilePurger is an optional interface for caches that can scan the cached tiles of a layer,
+// which allows purging every cache entry of a tile (including the entries of requests with
+// extra query arguments) without enumerating tile coordinates
+type TilePurger interface {
+	// PurgeTiles deletes the cache entries of the given layer key (e.g. name@hash) whose tile
+	// matches, returning the number of deleted entries
+	PurgeTiles(layerKey string, match func(maptile.Tile) bool) (uint64, error)
+}
+
+// parseTilePath parses the tile coordinates of a cache key path relative to its layer, either
+// of the form z/x/y?query for cache keys, or z/x/y-hash.mvt.gz for file-like cache backends
+func parseTilePath(p string) (maptile.Tile, bool) {
+	p, _, _ = strings.Cut(p, "?")
+	p = strings.TrimSuffix(p, TileDataExtension)
+	parts := strings.Split(p, "/")
+	if len(parts) != 3 {
+		return maptile.Tile{}, false
+	}
+	parts[2], _, _ = strings.Cut(parts[2], "-")
+	z, errZ := strconv.ParseUint(parts[0], 10, 8)
+	x, errX := strconv.ParseUint(parts[1], 10, 32)
+	y, errY := strconv.ParseUint(parts[2], 10, 32)
+	if errZ != nil || errX != nil || errY != nil || z > MaxZoom {
+		return maptile.Tile{}, false
+	}
+	return maptile.New(uint32(x), uint32(y), maptile.Zoom(z)), true
+}
+
+// escapeKeySegment escapes a single cache key path segment so that it is a safe file name
+func escapeKeySegment(segment string) string {
+	segment = url.PathEscape(segment)
+	if segment == "" || segment == "." || segment == ".." {
+		segment = strings.ReplaceAll(segment, ".", "%2E") + "_"
+	}
+	return segment
+}
+
+// cacheKeySegments splits a cache key (e.g. layer/z/x/y?query) into safe path segments for
+// file-like cache backends. Any query string is hashed into the final segment, which also
+// carries the TileDataExtension.
+func cacheKeySegments(key string) []string {
+	keyPath, query, hasQuery := strings.Cut(key, "?")
+	segments := strings.Split(keyPath, "/")
+	for i, segment := range segments {
+		segments[i] = escapeKeySegment(segment)
+	}
+	last := len(segments) - 1
+	if hasQuery {
+		segments[last] = fmt.Sprintf("%s-%x", segments[last], sha256.Sum256([]byte(query)))
+	}
+	segments[last] += TileDataExtension
+	return segments
+}
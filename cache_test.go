@@ -2,6 +2,10 @@ package tilenol
 
 import (
 	"testing"
+	"time"
+
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCreateNilCache(t *testing.T) {
@@ -25,3 +29,80 @@ func TestCreateRedisCache(t *testing.T) {
 		t.Error("Did not create a RedisCache")
 	}
 }
+
+func TestCreateInMemoryCache(t *testing.T) {
+	cacheConfig := &CacheConfig{InMemory: &InMemoryConfig{MaxBytes: 1024}}
+	cache, err := CreateCache(cacheConfig)
+	if err != nil {
+		t.Error("Could not create Cache")
+	}
+	if c, canCast := cache.(*InMemoryCache); !canCast {
+		t.Error("Did not create an InMemoryCache")
+	} else if c.MaxBytes != 1024 {
+		t.Errorf("Expected MaxBytes to be 1024, got: %d", c.MaxBytes)
+	}
+}
+
+func TestCreateMultipleCaches(t *testing.T) {
+	cacheConfig := &CacheConfig{Redis: &RedisConfig{}, InMemory: &InMemoryConfig{}}
+	_, err := CreateCache(cacheConfig)
+	if err != MultipleCachesErr {
+		t.Error("Expected to fail due to multiple caches")
+	}
+}
+
+func TestCacheEntryRoundTrip(t *testing.T) {
+	entry := NewCacheEntry([]byte("data"))
+	decoded := UnmarshalCacheEntry(entry.Marshal())
+	if string(decoded.Data) != "data" {
+		t.Errorf("Unexpected decoded data: %s", decoded.Data)
+	}
+	if !decoded.CreatedAt.Equal(entry.CreatedAt) {
+		t.Errorf("Unexpected decoded creation time: %v", decoded.CreatedAt)
+	}
+
+	// Values that were not encoded as a CacheEntry are passed through with an unknown age
+	legacy := UnmarshalCacheEntry([]byte{0x1f, 0x8b, 0x08})
+	if len(legacy.Data) != 3 || !legacy.CreatedAt.IsZero() || legacy.Age() != 0 {
+		t.Error("Legacy values should be passed through as-is")
+	}
+}
+
+func TestParseTilePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected maptile.Tile
+		ok       bool
+	}{
+		{"14/2620/6332", maptile.New(2620, 6332, 14), true},
+		{"14/2620/6332?s=name", maptile.New(2620, 6332, 14), true},
+		{"14/2620/6332" + TileDataExtension, maptile.New(2620, 6332, 14), true},
+		{"14/2620/6332-abc123" + TileDataExtension, maptile.New(2620, 6332, 14), true},
+		{"14/2620", maptile.Tile{}, false},
+		{"a/b/c", maptile.Tile{}, false},
+		{"23/0/0", maptile.Tile{}, false},
+	}
+	for _, test := range tests {
+		tile, ok := parseTilePath(test.path)
+		assert.Equal(t, test.ok, ok, test.path)
+		assert.Equal(t, test.expected, tile, test.path)
+	}
+}
+
+func TestStalenessFreshness(t *testing.T) {
+	config := StalenessConfig{SoftTTL: time.Minute, HardTTL: time.Hour, StaleIfError: time.Hour}
+	cases := map[time.Duration]Freshness{
+		0:                Fresh,
+		2 * time.Minute:  Stale,
+		90 * time.Minute: Expired,
+		3 * time.Hour:    Unusable,
+	}
+	for age, expected := range cases {
+		if actual := config.Freshness(age); actual != expected {
+			t.Errorf("Expected freshness %d for age %v, got: %d", expected, age, actual)
+		}
+	}
+	if (StalenessConfig{}).Freshness(24*time.Hour) != Fresh {
+		t.Error("Entries should always be fresh without any TTLs configured")
+	}
+}
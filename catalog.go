@@ -0,0 +1,58 @@
+package tilenol
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// LayerInfo is the public metadata describing a configured tile server layer
+type LayerInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Minzoom     int      `json:"minzoom"`
+	Maxzoom     int      `json:"maxzoom"`
+	Cacheable   bool     `json:"cacheable"`
+	SourceType  string   `json:"sourceType"`
+	Fields      []string `json:"fields"`
+	Version     string   `json:"version"`
+}
+
+// NewLayerInfo creates the LayerInfo metadata for a given Layer
+func NewLayerInfo(layer Layer) LayerInfo {
+	fields := layer.Fields()
+	if fields == nil {
+		fields = []string{}
+	}
+	return LayerInfo{
+		Name:        layer.Name,
+		Description: layer.Description,
+		Minzoom:     layer.Minzoom,
+		Maxzoom:     effectiveMaxzoom(layer),
+		Cacheable:   layer.Cacheable,
+		SourceType:  layer.SourceType(),
+		Fields:      fields,
+		Version:     layer.Hash(),
+	}
+}
+
+// listLayers lists the metadata for every configured layer
+func (s *Server) listLayers(w http.ResponseWriter, r *http.Request) {
+	infos := make([]LayerInfo, len(s.Layers))
+	for i, layer := range s.Layers {
+		infos[i] = NewLayerInfo(layer)
+	}
+	s.writeJSON(w, r, infos)
+}
+
+// getLayer retrieves the metadata for a single configured layer
+func (s *Server) getLayer(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	layers := filterLayersByNames(s.Layers, []string{name})
+	if len(layers) == 0 {
+		s.handleError(NotFoundError{fmt.Sprintf("No layer found for [%s].", name)}, w, r)
+		return
+	}
+	s.writeJSON(w, r, NewLayerInfo(layers[0]))
+}
@@ -0,0 +1,52 @@
+package tilenol
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayerCatalog(t *testing.T) {
+	layers := []Layer{
+		Layer{Name: "a", Description: "Layer A", Minzoom: 2, Maxzoom: 12, Cacheable: true,
+			source: &fieldsSource{fields: []string{"b", "a"}}},
+		Layer{Name: "b", source: &NilSource{}},
+	}
+	server := &Server{Layers: layers, Cache: &NilCache{}}
+	api, _ := server.setupRoutes()
+
+	r := httptest.NewRequest("GET", "/layers", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	res := w.Result()
+	assert.Equal(t, 200, res.StatusCode)
+
+	var infos []LayerInfo
+	err := json.NewDecoder(res.Body).Decode(&infos)
+	assert.Nil(t, err)
+	assert.Len(t, infos, 2)
+	assert.Equal(t, "Layer A", infos[0].Description)
+	assert.Equal(t, []string{"a", "b"}, infos[0].Fields)
+	assert.Equal(t, layers[0].Hash(), infos[0].Version)
+	assert.Equal(t, "nil", infos[1].SourceType)
+	assert.Equal(t, MaxZoom, infos[1].Maxzoom)
+
+	r = httptest.NewRequest("GET", "/layers/a", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	res = w.Result()
+	assert.Equal(t, 200, res.StatusCode)
+
+	var info LayerInfo
+	err = json.NewDecoder(res.Body).Decode(&info)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", info.Name)
+	assert.True(t, info.Cacheable)
+
+	r = httptest.NewRequest("GET", "/layers/doesntexist", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	assert.Equal(t, 404, w.Result().StatusCode)
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stationa/tilenol"
@@ -44,12 +50,133 @@ var (
 			Envar("TILENOL_SIMPLIFY_SHAPES").
 			Short('s').
 			Bool()
+	shutdownTimeout = runCmd.
+			Flag("shutdown-timeout", "Grace period for draining in-flight requests on shutdown").
+			Envar("TILENOL_SHUTDOWN_TIMEOUT").
+			Default("30s").
+			Duration()
+	adminToken = runCmd.
+			Flag("admin-token", "Bearer token for the admin endpoints on the internal port").
+			Envar("TILENOL_ADMIN_TOKEN").
+			String()
 	numProcs = runCmd.
 			Flag("num-processes", "Sets the number of processes to be used").
 			Envar("TILENOL_NUM_PROCESSES").
 			Short('n').
 			Default("0").
 			Int()
+	seedCmd = kingpin.
+		Command("seed", "Pre-warms the cache with the tiles covering an area")
+	seedDebug = seedCmd.
+			Flag("debug", "Enable debug mode").
+			Short('d').
+			Bool()
+	seedConfigFile = seedCmd.
+			Flag("config-file", "Server configuration file").
+			Envar("TILENOL_CONFIG_FILE").
+			Short('f').
+			Default("tilenol.yml").
+			File()
+	seedLayers = seedCmd.
+			Flag("layers", "Comma-separated list of layers to seed").
+			Short('l').
+			Default(tilenol.AllLayers).
+			String()
+	seedBbox = seedCmd.
+			Flag("bbox", "Bounding box to seed (minLon,minLat,maxLon,maxLat)").
+			Short('b').
+			String()
+	seedGeoJSON = seedCmd.
+			Flag("geojson", "GeoJSON file containing the area to seed instead of a bbox (e.g. service area polygons)").
+			Short('g').
+			ExistingFile()
+	seedMinZoom = seedCmd.
+			Flag("min-zoom", "Minimum zoom level to seed").
+			Default("0").
+			Int()
+	seedMaxZoom = seedCmd.
+			Flag("max-zoom", "Maximum zoom level to seed").
+			Required().
+			Int()
+	seedConcurrency = seedCmd.
+			Flag("concurrency", "Number of tiles to seed concurrently").
+			Short('c').
+			Default("8").
+			Int()
+	seedStateFile = seedCmd.
+			Flag("state-file", "File to checkpoint progress to, for resuming interrupted runs").
+			String()
+	seedProgressInterval = seedCmd.
+				Flag("progress-interval", "Interval between progress reports").
+				Default("10s").
+				Duration()
+	seedDryRun = seedCmd.
+			Flag("dry-run", "Only print the number of tiles that would be seeded").
+			Bool()
+	exportCmd = kingpin.
+			Command("export", "Exports layers to a tile archive")
+	exportDebug = exportCmd.
+			Flag("debug", "Enable debug mode").
+			Short('d').
+			Bool()
+	exportConfigFile = exportCmd.
+				Flag("config-file", "Server configuration file").
+				Envar("TILENOL_CONFIG_FILE").
+				Short('f').
+				Default("tilenol.yml").
+				File()
+	exportLayers = exportCmd.
+			Flag("layers", "Comma-separated list of layers to export").
+			Short('l').
+			Default(tilenol.AllLayers).
+			String()
+	exportName = exportCmd.
+			Flag("name", "Name of the exported tileset (defaults to the list of layers)").
+			String()
+	exportDescription = exportCmd.
+				Flag("description", "Description of the exported tileset").
+				String()
+	exportBbox = exportCmd.
+			Flag("bbox", "Bounding box to export (minLon,minLat,maxLon,maxLat)").
+			Short('b').
+			String()
+	exportGeoJSON = exportCmd.
+			Flag("geojson", "GeoJSON file containing the area to export instead of a bbox").
+			Short('g').
+			ExistingFile()
+	exportMinZoom = exportCmd.
+			Flag("min-zoom", "Minimum zoom level to export").
+			Default("0").
+			Int()
+	exportMaxZoom = exportCmd.
+			Flag("max-zoom", "Maximum zoom level to export").
+			Required().
+			Int()
+	exportConcurrency = exportCmd.
+				Flag("concurrency", "Number of tiles to render concurrently").
+				Short('c').
+				Default("8").
+				Int()
+	exportProgressInterval = exportCmd.
+				Flag("progress-interval", "Interval between progress reports").
+				Default("10s").
+				Duration()
+	exportSimplify = exportCmd.
+			Flag("simplify-shapes", "Simplifies geometries based on zoom level").
+			Short('s').
+			Bool()
+	exportMBTilesCmd = exportCmd.
+				Command("mbtiles", "Exports layers to an MBTiles archive")
+	exportMBTilesFile = exportMBTilesCmd.
+				Arg("file", "Output MBTiles file").
+				Required().
+				String()
+	exportPMTilesCmd = exportCmd.
+				Command("pmtiles", "Exports layers to a PMTiles archive")
+	exportPMTilesFile = exportPMTilesCmd.
+				Arg("file", "Output PMTiles file").
+				Required().
+				String()
 	versionCmd = kingpin.
 			Command("version", "Prints out the version")
 )
@@ -66,6 +193,141 @@ func printVersionInfo() {
 	fmt.Printf("tilenol version=%s (%s)\n", Version, Commitish)
 }
 
+// parseTileCover builds the tile cover from the area and zoom range flags
+func parseTileCover(bbox string, geojsonFile string, minZoom int, maxZoom int) (tilenol.TileCover, error) {
+	cover := tilenol.TileCover{Bound: tilenol.WorldBound, MinZoom: minZoom, MaxZoom: maxZoom}
+	if minZoom < tilenol.MinZoom || maxZoom > tilenol.MaxZoom || minZoom > maxZoom {
+		return cover, fmt.Errorf("Invalid zoom range: [%d, %d]", minZoom, maxZoom)
+	}
+	if bbox != "" && geojsonFile != "" {
+		return cover, errors.New("Only one of --bbox and --geojson can be given")
+	}
+	if bbox != "" {
+		bound, err := tilenol.ParseBound(bbox)
+		if err != nil {
+			return cover, err
+		}
+		cover.Bound = bound
+	}
+	if geojsonFile != "" {
+		data, err := os.ReadFile(geojsonFile)
+		if err != nil {
+			return cover, err
+		}
+		geom, err := tilenol.ParseCoverGeometry(data)
+		if err != nil {
+			return cover, fmt.Errorf("Failed to read GeoJSON file [%s]: %w", geojsonFile, err)
+		}
+		cover.Geometry = geom
+	}
+	return cover, nil
+}
+
+// seed runs the seed command, returning an error if any tile failed to be seeded
+func seed() (err error) {
+	if *seedDebug {
+		tilenol.Logger.SetLevel(logrus.DebugLevel)
+	}
+
+	cover, err := parseTileCover(*seedBbox, *seedGeoJSON, *seedMinZoom, *seedMaxZoom)
+	if err != nil {
+		return err
+	}
+
+	s, err := tilenol.NewServer(tilenol.ConfigFile(*seedConfigFile))
+	if err != nil {
+		return err
+	}
+	// Note: shutting down the server flushes any asynchronous cache writes
+	defer func() {
+		err = errors.Join(err, s.Shutdown(context.Background()))
+	}()
+
+	opts := tilenol.SeedOptions{
+		Layers:           strings.Split(*seedLayers, ","),
+		Cover:            cover,
+		Concurrency:      *seedConcurrency,
+		StateFile:        *seedStateFile,
+		ProgressInterval: *seedProgressInterval,
+	}
+	if *seedDryRun {
+		count, err := s.CountSeedTiles(opts)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d tiles would be seeded\n", count)
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	result, err := s.Seed(ctx, opts)
+	if err != nil {
+		return err
+	}
+	tilenol.Logger.Infof("Seeded %d tiles (%d skipped, %d errors)", result.Seeded, result.Skipped, result.Errors)
+	if result.Errors > 0 {
+		return fmt.Errorf("Failed to seed %d tiles", result.Errors)
+	}
+	return nil
+}
+
+// export runs an export command, writing the rendered tiles to a new tile archive file
+func export(file string, createWriter func(string) (tilenol.TileWriter, error)) (err error) {
+	if *exportDebug {
+		tilenol.Logger.SetLevel(logrus.DebugLevel)
+	}
+
+	cover, err := parseTileCover(*exportBbox, *exportGeoJSON, *exportMinZoom, *exportMaxZoom)
+	if err != nil {
+		return err
+	}
+
+	opts := []tilenol.ConfigOption{tilenol.ConfigFile(*exportConfigFile)}
+	if *exportSimplify {
+		opts = append(opts, tilenol.SimplifyShapes)
+	}
+	s, err := tilenol.NewServer(opts...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, s.Shutdown(context.Background()))
+	}()
+
+	w, err := createWriter(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, w.Close())
+		// Incomplete archives are removed so that they can't be mistaken for a full export
+		if err != nil {
+			os.Remove(file)
+		}
+	}()
+
+	name := *exportName
+	if name == "" {
+		name = *exportLayers
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	result, err := s.Export(ctx, tilenol.ExportOptions{
+		Name:             name,
+		Description:      *exportDescription,
+		Layers:           strings.Split(*exportLayers, ","),
+		Cover:            cover,
+		Concurrency:      *exportConcurrency,
+		ProgressInterval: *exportProgressInterval,
+	}, w)
+	if err != nil {
+		return err
+	}
+	tilenol.Logger.Infof("Exported %d tiles (%d empty tiles skipped)", result.Written, result.Empty)
+	return nil
+}
+
 func main() {
 	cmd := kingpin.Parse()
 
@@ -84,6 +346,8 @@ func main() {
 		opts = append(opts, tilenol.Port(*port))
 		opts = append(opts, tilenol.InternalPort(*internalPort))
 		opts = append(opts, tilenol.ConfigFile(*configFile))
+		opts = append(opts, tilenol.ShutdownTimeout(*shutdownTimeout))
+		opts = append(opts, tilenol.AdminToken(*adminToken))
 		if *cors {
 			opts = append(opts, tilenol.EnableCORS)
 		}
@@ -95,7 +359,30 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		s.Start()
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := s.Start(ctx); err != nil {
+			tilenol.Logger.Fatalln(err)
+		}
+	case seedCmd.FullCommand():
+		if err := seed(); err != nil {
+			tilenol.Logger.Fatalln(err)
+		}
+	case exportMBTilesCmd.FullCommand():
+		err := export(*exportMBTilesFile, func(file string) (tilenol.TileWriter, error) {
+			return tilenol.NewMBTilesWriter(file)
+		})
+		if err != nil {
+			tilenol.Logger.Fatalln(err)
+		}
+	case exportPMTilesCmd.FullCommand():
+		err := export(*exportPMTilesFile, func(file string) (tilenol.TileWriter, error) {
+			return tilenol.NewPMTilesWriter(file)
+		})
+		if err != nil {
+			tilenol.Logger.Fatalln(err)
+		}
 	case versionCmd.FullCommand():
 		printVersionInfo()
 	}
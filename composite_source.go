@@ -0,0 +1,182 @@
+package tilenol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/paulmach/orb/geojson"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	// MissingCompositeSourcesErr is the error returned when a composite source has no children
+	MissingCompositeSourcesErr = errors.New("Composite source requires a list of \"sources\"")
+)
+
+// CompositeConfig is the YAML configuration structure for configuring a new CompositeSource
+type CompositeConfig struct {
+	// Sources configures the child sources, ordered by priority
+	Sources []CompositeSourceConfig `yaml:"sources"`
+	// Deduplicate configures whether or not features with the same ID as a feature of a
+	// higher-priority child source are dropped
+	Deduplicate bool `yaml:"deduplicate"`
+}
+
+// CompositeSourceConfig is the YAML configuration structure for a single child source of a
+// CompositeSource
+type CompositeSourceConfig struct {
+	SourceConfig `yaml:",inline"`
+	// Properties are extra properties set on every feature of the child source, e.g. to tag
+	// features with their origin (optional)
+	Properties map[string]interface{} `yaml:"properties"`
+}
+
+// compositeChild is a single hydrated child source of a CompositeSource
+type compositeChild struct {
+	source     Source
+	properties map[string]interface{}
+}
+
+// CompositeSource is a Source implementation that combines the features of several child
+// sources into a single layer. Children are queried concurrently, and their features are merged
+// in the order of the children.
+type CompositeSource struct {
+	// Deduplicate configures whether or not features with the same ID as a feature of a
+	// higher-priority child source are dropped
+	Deduplicate bool
+
+	children []compositeChild
+}
+
+// NewCompositeSource creates a new Source that combines the features of several child sources
+func NewCompositeSource(config *CompositeConfig) (Source, error) {
+	if len(config.Sources) == 0 {
+		return nil, MissingCompositeSourcesErr
+	}
+	c := &CompositeSource{Deduplicate: config.Deduplicate}
+	for i := range config.Sources {
+		source, err := CreateSource(&config.Sources[i].SourceConfig)
+		if err != nil {
+			// Release the children that were already created
+			c.Close()
+			return nil, fmt.Errorf("composite source %d: %w", i, err)
+		}
+		c.children = append(c.children, compositeChild{source, config.Sources[i].Properties})
+	}
+	return c, nil
+}
+
+// HealthCheck implements the HealthChecker interface by checking every child source that
+// supports it
+func (c *CompositeSource) HealthCheck(ctx context.Context) error {
+	var errs []error
+	for i, child := range c.children {
+		if checker, ok := child.source.(HealthChecker); ok {
+			if err := checker.HealthCheck(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("composite source %d: %w", i, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every child source that holds any resources
+func (c *CompositeSource) Close() error {
+	var errs []error
+	for i, child := range c.children {
+		if closer, ok := child.source.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("composite source %d: %w", i, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fields implements the FieldsSource interface, returning the feature property names of every
+// child source that can describe them, along with the extra properties of every child
+func (c *CompositeSource) Fields() []string {
+	seen := make(map[string]bool)
+	var fields []string
+	add := func(field string) {
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	for _, child := range c.children {
+		if fs, ok := child.source.(FieldsSource); ok {
+			for _, field := range fs.Fields() {
+				add(field)
+			}
+		}
+		for prop := range child.properties {
+			add(prop)
+		}
+	}
+	return fields
+}
+
+// Generation implements the VersionedSource interface, changing whenever the data of any of
+// the child sources that implement it changes
+func (c *CompositeSource) Generation() uint64 {
+	var generation uint64
+	for _, child := range c.children {
+		if vs, ok := child.source.(VersionedSource); ok {
+			generation += vs.Generation()
+		}
+	}
+	return generation
+}
+
+// GetFeatures implements the Source interface, querying every child source concurrently and
+// merging their features. Note that the request fails if any of the children fail.
+func (c *CompositeSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	eg, ctx := errgroup.WithContext(ctx)
+	results := make([]*geojson.FeatureCollection, len(c.children))
+	for i, child := range c.children {
+		i, child := i, child
+		eg.Go(func() error {
+			fc, err := child.source.GetFeatures(ctx, req)
+			if err != nil {
+				return err
+			}
+			results[i] = fc
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	// Note: IDs are compared by their string form, since different backends decode the same ID
+	// to different types (e.g. int64 and float64)
+	fc := geojson.NewFeatureCollection()
+	seen := make(map[string]bool)
+	for i, result := range results {
+		var ids []string
+		for _, feature := range result.Features {
+			if c.Deduplicate && feature.ID != nil {
+				id := fmt.Sprint(feature.ID)
+				if seen[id] {
+					continue
+				}
+				ids = append(ids, id)
+			}
+			if feature.Properties == nil && len(c.children[i].properties) > 0 {
+				feature.Properties = make(geojson.Properties)
+			}
+			for k, v := range c.children[i].properties {
+				feature.Properties[k] = v
+			}
+			fc.Append(feature)
+		}
+		// Features are only deduplicated against the features of higher-priority children
+		for _, id := range ids {
+			seen[id] = true
+		}
+	}
+	return fc, nil
+}
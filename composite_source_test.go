@@ -0,0 +1,168 @@
+package tilenol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/assert"
+)
+
+// errSource is a Source that always fails with the given error
+type errSource struct {
+	err error
+}
+
+func (e *errSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	return nil, e.err
+}
+
+// fixedSource is a Source that always returns the given feature
+type fixedSource struct {
+	feature *geojson.Feature
+}
+
+func (f *fixedSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	fc := geojson.NewFeatureCollection()
+	fc.Append(f.feature)
+	return fc, nil
+}
+
+// healthSource is a Source with a HealthCheck that fails with the given error
+type healthSource struct {
+	NilSource
+	err    error
+	closed bool
+}
+
+func (h *healthSource) HealthCheck(ctx context.Context) error {
+	return h.err
+}
+
+func (h *healthSource) Close() error {
+	h.closed = true
+	return nil
+}
+
+// versionedSource is a Source whose data version is the given generation
+type versionedSource struct {
+	NilSource
+	generation uint64
+}
+
+func (v *versionedSource) Generation() uint64 {
+	return v.generation
+}
+
+func TestCompositeSourceGeneration(t *testing.T) {
+	child := &versionedSource{}
+	source := &CompositeSource{children: []compositeChild{{source: &NilSource{}}, {source: child}}}
+	layer := Layer{Name: "a", source: source}
+	version := layer.Hash()
+
+	child.generation++
+	assert.NotEqual(t, version, layer.Hash(), "Changes to any child source should change the layer version")
+}
+
+const testCompositeConfig = `
+layers:
+  - name: buildings
+    source:
+      composite:
+        deduplicate: true
+        sources:
+          - geojson:
+              path: %s
+              sourceFields:
+                name: NAME
+            properties:
+              origin: new
+          - geojson:
+              path: %s
+              sourceFields:
+                name: NAME
+                pop: pop
+            properties:
+              origin: old
+`
+
+func TestCompositeSource(t *testing.T) {
+	// The old file has features with IDs 1, 2 and 3, while the new one has features with IDs 1
+	// and 2 (twice)
+	oldPath := writeTestFile(t, "old.geojson", testFeatureCollection)
+	newPath := writeTestFile(t, "new.ndjson", `
+{"type": "Feature", "id": 1, "geometry": {"type": "Point", "coordinates": [-122.4, 37.8]}, "properties": {"NAME": "SF"}}
+{"type": "Feature", "id": 2, "geometry": {"type": "Point", "coordinates": [-73.9, 40.7]}, "properties": {"NAME": "New York"}}
+{"type": "Feature", "id": 2, "geometry": {"type": "Point", "coordinates": [-73.8, 40.6]}, "properties": {"NAME": "Queens"}}
+`)
+	configFile, err := os.Open(writeTestFile(t, "tilenol.yml", fmt.Sprintf(testCompositeConfig, newPath, oldPath)))
+	assert.Nil(t, err)
+	defer configFile.Close()
+	config, err := LoadConfig(configFile)
+	assert.Nil(t, err)
+
+	layer, err := CreateLayer(config.Layers[0])
+	assert.Nil(t, err)
+	defer layer.Close()
+	assert.Equal(t, "composite", layer.SourceType())
+	assert.Equal(t, []string{"name", "origin", "pop"}, layer.Fields())
+
+	fc, err := layer.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+	assert.Nil(t, err)
+	var features []string
+	for _, f := range fc.Features {
+		features = append(features, fmt.Sprintf("%v:%v:%v", f.ID, f.Properties["name"], f.Properties["origin"]))
+	}
+	assert.ElementsMatch(t, []string{
+		"1:SF:new",
+		"2:New York:new",
+		"2:Queens:new",
+		"3:Bay Area:old",
+	}, features, "Only features of other children should be deduplicated")
+
+	// Without deduplication
+	layer.source.(*CompositeSource).Deduplicate = false
+	fc, err = layer.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+	assert.Nil(t, err)
+	assert.Len(t, fc.Features, 6)
+}
+
+func TestCompositeSourceErrors(t *testing.T) {
+	_, err := NewCompositeSource(&CompositeConfig{})
+	assert.Equal(t, MissingCompositeSourcesErr, err)
+
+	_, err = NewCompositeSource(&CompositeConfig{Sources: []CompositeSourceConfig{
+		{SourceConfig: SourceConfig{GeoJSON: &GeoJSONConfig{Path: writeTestFile(t, "a.geojson", testNDJSON)}}},
+		{SourceConfig: SourceConfig{}},
+	}})
+	assert.ErrorIs(t, err, NoSourcesErr)
+
+	// Request errors are returned as-is, so that e.g. invalid requests are still reported
+	invalid := InvalidRequestError{"invalid"}
+	source := &CompositeSource{children: []compositeChild{
+		{source: &NilSource{}},
+		{source: &errSource{invalid}},
+	}}
+	_, err = source.GetFeatures(context.Background(), &TileRequest{})
+	assert.Equal(t, invalid, err)
+
+	healthy, unhealthy := &healthSource{}, &healthSource{err: errors.New("down")}
+	source = &CompositeSource{children: []compositeChild{{source: healthy}, {source: unhealthy}}}
+	assert.ErrorContains(t, source.HealthCheck(context.Background()), "composite source 1: down")
+	assert.Nil(t, source.Close())
+	assert.True(t, healthy.closed && unhealthy.closed)
+}
+
+func TestCompositeSourceProperties(t *testing.T) {
+	// Features without properties are still tagged
+	source := &CompositeSource{children: []compositeChild{
+		{source: &fixedSource{&geojson.Feature{Geometry: orb.Point{1, 2}}}, properties: map[string]interface{}{"tag": 1}},
+	}}
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, geojson.Properties{"tag": 1}, fc.Features[0].Properties)
+}
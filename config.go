@@ -2,6 +2,7 @@ package tilenol
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -41,6 +42,9 @@ func ConfigFile(configFile *os.File) ConfigOption {
 			return err
 		}
 		s.Cache = cache
+		if config.Cache != nil {
+			s.Staleness = config.Cache.Staleness
+		}
 		var layers []Layer
 		for _, layerConfig := range config.Layers {
 			layer, err := CreateLayer(layerConfig)
@@ -70,6 +74,22 @@ func InternalPort(internalPort uint16) ConfigOption {
 	}
 }
 
+// ShutdownTimeout changes the grace period for draining in-flight requests upon shutdown
+func ShutdownTimeout(timeout time.Duration) ConfigOption {
+	return func(s *Server) error {
+		s.ShutdownTimeout = timeout
+		return nil
+	}
+}
+
+// AdminToken sets the bearer token required by the admin endpoints on the internal port
+func AdminToken(token string) ConfigOption {
+	return func(s *Server) error {
+		s.AdminToken = token
+		return nil
+	}
+}
+
 // EnableCORS configures the server for CORS (cross-origin resource sharing)
 func EnableCORS(s *Server) error {
 	s.EnableCORS = true
@@ -0,0 +1,414 @@
+package tilenol
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkt"
+	"github.com/paulmach/orb/geojson"
+)
+
+var (
+	// MissingCSVPathErr is the error returned when a CSV source has no file path
+	MissingCSVPathErr = errors.New("CSV source requires a \"path\"")
+	// InvalidCSVDelimiterErr is the error returned when a CSV delimiter is not a single character
+	InvalidCSVDelimiterErr = errors.New("CSV \"delimiter\" must be a single character")
+	// InvalidCSVGeometryConfigErr is the error returned when a CSV source has both a geometry
+	// column and coordinate columns, or only one of the coordinate columns
+	InvalidCSVGeometryConfigErr = errors.New("Either \"geometryField\" or \"latitudeField\" + \"longitudeField\" can be set, not both.")
+	// MissingCSVColumnErr is the error returned when a configured CSV column is not in the header
+	MissingCSVColumnErr = errors.New("CSV file is missing a column")
+	// MissingCSVGeometryErr is the error returned when no geometry columns are configured, and
+	// none can be found in the header
+	MissingCSVGeometryErr = errors.New("CSV file has no latitude/longitude or geometry columns")
+)
+
+// Column names that are recognized as geometry columns when none are configured
+var (
+	csvLatitudeNames  = []string{"latitude", "lat", "y"}
+	csvLongitudeNames = []string{"longitude", "lon", "lng", "long", "x"}
+	csvGeometryNames  = []string{"wkt", "geometry", "geom", "the_geom", "shape"}
+)
+
+// utf8BOM is the byte order mark that spreadsheet software writes at the start of UTF-8 files
+const utf8BOM = "\uFEFF"
+
+// CSVConfig is the YAML configuration structure for configuring a new CSVSource
+type CSVConfig struct {
+	// Path is the path to the delimited text file, whose first row is the header
+	Path string `yaml:"path"`
+	// Delimiter is the field delimiter (optional, defaults to a comma)
+	Delimiter string `yaml:"delimiter"`
+	// LatitudeField is the name of the column holding point latitudes
+	LatitudeField string `yaml:"latitudeField"`
+	// LongitudeField is the name of the column holding point longitudes
+	LongitudeField string `yaml:"longitudeField"`
+	// GeometryField is the name of the column holding WKT (or GeoJSON) geometries, as an
+	// alternative to LatitudeField and LongitudeField. Note that the geometry columns are
+	// detected from common column names when none are set.
+	GeometryField string `yaml:"geometryField"`
+	// SourceFields is a mapping from the feature property name to the CSV column name
+	SourceFields map[string]string `yaml:"sourceFields"`
+	// ReloadInterval is how often the file is checked for changes, where zero disables
+	// reloading (optional)
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+// CSVSource is a Source implementation that retrieves feature data from a delimited text file,
+// which is loaded into an in-memory spatial index. Column types are inferred from the values of
+// each column, so that e.g. a column of numbers produces numeric properties.
+type CSVSource struct {
+	// Path is the path to the CSV file
+	Path string
+	// SourceFields is a mapping from the feature property name to the CSV column name
+	SourceFields map[string]string
+
+	config  CSVConfig
+	mu      sync.RWMutex
+	index   *featureIndex
+	watcher *fileWatcher
+}
+
+// NewCSVSource creates a new Source that retrieves feature data from a CSV file
+func NewCSVSource(config *CSVConfig) (Source, error) {
+	if config.Path == "" {
+		return nil, MissingCSVPathErr
+	}
+	if config.Delimiter != "" && utf8.RuneCountInString(config.Delimiter) != 1 {
+		return nil, InvalidCSVDelimiterErr
+	}
+	hasLatLon := config.LatitudeField != "" || config.LongitudeField != ""
+	if hasLatLon && (config.GeometryField != "" || config.LatitudeField == "" || config.LongitudeField == "") {
+		return nil, InvalidCSVGeometryConfigErr
+	}
+	// Note: the file is stat'ed before loading, so that changes made while loading are reloaded
+	info, err := os.Stat(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	c := &CSVSource{
+		Path:         config.Path,
+		SourceFields: config.SourceFields,
+		config:       *config,
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	if config.ReloadInterval > 0 {
+		c.watcher = watchFile(config.Path, info, config.ReloadInterval, c.load)
+	}
+	return c, nil
+}
+
+// load reads the CSV file and replaces the spatial index
+func (c *CSVSource) load() error {
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	features, err := readCSVFeatures(bufio.NewReader(f), &c.config)
+	if err != nil {
+		return fmt.Errorf("Failed to read CSV file [%s]: %w", c.Path, err)
+	}
+	index := newFeatureIndex(features)
+	Logger.Debugf("Loaded %d features from CSV file [%s]", index.Len(), c.Path)
+
+	c.mu.Lock()
+	c.index = index
+	c.mu.Unlock()
+	return nil
+}
+
+// csvColumns holds the indexes of the geometry columns of a CSV file, where -1 means unset
+type csvColumns struct {
+	lat, lon, geometry int
+}
+
+// findCSVColumn returns the index of the first header column matching one of the names
+// (case-insensitively), or -1
+func findCSVColumn(header []string, names ...string) int {
+	for _, name := range names {
+		for i, column := range header {
+			if strings.EqualFold(column, name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// geometryColumns resolves the configured (or detected) geometry columns of a CSV header
+func geometryColumns(header []string, config *CSVConfig) (csvColumns, error) {
+	cols := csvColumns{-1, -1, -1}
+	if config.GeometryField == "" && config.LatitudeField == "" {
+		cols.lat = findCSVColumn(header, csvLatitudeNames...)
+		cols.lon = findCSVColumn(header, csvLongitudeNames...)
+		if cols.lat >= 0 && cols.lon >= 0 {
+			return cols, nil
+		}
+		cols.lat, cols.lon = -1, -1
+		if cols.geometry = findCSVColumn(header, csvGeometryNames...); cols.geometry >= 0 {
+			return cols, nil
+		}
+		return cols, MissingCSVGeometryErr
+	}
+	var missing []string
+	for _, col := range []struct {
+		name  string
+		index *int
+	}{
+		{config.LatitudeField, &cols.lat},
+		{config.LongitudeField, &cols.lon},
+		{config.GeometryField, &cols.geometry},
+	} {
+		if col.name == "" {
+			continue
+		}
+		if *col.index = findCSVColumn(header, col.name); *col.index < 0 {
+			missing = append(missing, col.name)
+		}
+	}
+	if len(missing) > 0 {
+		return cols, fmt.Errorf("%w: %s", MissingCSVColumnErr, strings.Join(missing, ", "))
+	}
+	return cols, nil
+}
+
+// parseCSVGeometry parses the geometry of a row, where nil means that the row has no geometry
+func parseCSVGeometry(row []string, cols csvColumns) (orb.Geometry, error) {
+	if cols.geometry >= 0 {
+		value := strings.TrimSpace(csvValue(row, cols.geometry))
+		if value == "" {
+			return nil, nil
+		}
+		if strings.HasPrefix(value, "{") {
+			g, err := geojson.UnmarshalGeometry([]byte(value))
+			if err != nil {
+				return nil, err
+			}
+			return g.Geometry(), nil
+		}
+		// Note: the WKT decoder expects single spaces between tokens
+		return wkt.Unmarshal(strings.Join(strings.Fields(value), " "))
+	}
+	latValue := strings.TrimSpace(csvValue(row, cols.lat))
+	lonValue := strings.TrimSpace(csvValue(row, cols.lon))
+	if latValue == "" && lonValue == "" {
+		return nil, nil
+	}
+	lat, err := strconv.ParseFloat(latValue, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid latitude [%s]", latValue)
+	}
+	lon, err := strconv.ParseFloat(lonValue, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid longitude [%s]", lonValue)
+	}
+	if math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return nil, fmt.Errorf("coordinates [%s, %s] are out of range", latValue, lonValue)
+	}
+	return orb.Point{lon, lat}, nil
+}
+
+// csvValue returns the value of a column, where missing trailing columns are empty
+func csvValue(row []string, i int) string {
+	if i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
+// csvColumnType is the inferred type of the values of a CSV column
+type csvColumnType int
+
+const (
+	csvInt csvColumnType = iota
+	csvFloat
+	csvBool
+	csvString
+)
+
+// csvNumber checks that a value is a plain decimal number. Note that numbers with leading
+// zeros (e.g. postal codes) are not numbers, since they would lose their zeros.
+func csvNumber(v string) bool {
+	digits := strings.TrimLeft(v, "+-")
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		return false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	return err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) && !strings.ContainsAny(v, "xX_")
+}
+
+// inferCSVColumnTypes infers the type of each column from all of its non-empty values
+func inferCSVColumnTypes(rows [][]string, columns int) []csvColumnType {
+	types := make([]csvColumnType, columns)
+	for i := range types {
+		canInt, canFloat, canBool := true, true, true
+		for _, row := range rows {
+			v := strings.TrimSpace(csvValue(row, i))
+			if v == "" {
+				continue
+			}
+			number := csvNumber(v)
+			if _, err := strconv.ParseInt(v, 10, 64); err != nil || !number {
+				canInt = false
+			}
+			canFloat = canFloat && number
+			canBool = canBool && (strings.EqualFold(v, "true") || strings.EqualFold(v, "false"))
+			if !canInt && !canFloat && !canBool {
+				break
+			}
+		}
+		switch {
+		case canInt:
+			types[i] = csvInt
+		case canFloat:
+			types[i] = csvFloat
+		case canBool:
+			types[i] = csvBool
+		default:
+			types[i] = csvString
+		}
+	}
+	return types
+}
+
+// parseCSVValue converts a value to its column type, where empty values are nil
+func parseCSVValue(v string, typ csvColumnType) interface{} {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil
+	}
+	switch typ {
+	case csvInt:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	case csvFloat:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	case csvBool:
+		return strings.EqualFold(v, "true")
+	default:
+		return v
+	}
+}
+
+// readCSVFeatures reads the features of a CSV file, with a property for each non-geometry
+// column (where an "id" column is also the feature ID). Rows without a geometry are skipped,
+// as are rows with invalid geometries (which are logged).
+func readCSVFeatures(r io.Reader, config *CSVConfig) ([]*geojson.Feature, error) {
+	reader := csv.NewReader(r)
+	if config.Delimiter != "" {
+		reader.Comma, _ = utf8.DecodeRuneInString(config.Delimiter)
+	}
+	// Spreadsheet exports commonly omit empty trailing columns
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	header[0] = strings.TrimPrefix(header[0], utf8BOM)
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	cols, err := geometryColumns(header, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	var lines []int
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, row)
+		lines = append(lines, line)
+	}
+	types := inferCSVColumnTypes(rows, len(header))
+
+	var features []*geojson.Feature
+	var invalid int
+	for i, row := range rows {
+		geom, err := parseCSVGeometry(row, cols)
+		if err != nil {
+			if invalid == 0 {
+				Logger.Warnf("Invalid geometry on line %d of CSV file [%s]: %v", lines[i], config.Path, err)
+			}
+			invalid++
+			continue
+		} else if geom == nil {
+			continue
+		}
+		feature := geojson.NewFeature(geom)
+		for j, column := range header {
+			if j == cols.lat || j == cols.lon || j == cols.geometry {
+				continue
+			}
+			v := parseCSVValue(csvValue(row, j), types[j])
+			if v == nil {
+				continue
+			}
+			// Special-case the feature ID
+			if column == "id" {
+				feature.ID = v
+			}
+			feature.Properties[column] = v
+		}
+		features = append(features, feature)
+	}
+	if invalid > 0 {
+		Logger.Warnf("Skipped %d rows of CSV file [%s] with invalid geometries", invalid, config.Path)
+	}
+	return features, nil
+}
+
+// Close stops watching the CSV file for changes
+func (c *CSVSource) Close() error {
+	if c.watcher == nil {
+		return nil
+	}
+	return c.watcher.Close()
+}
+
+// Generation implements the VersionedSource interface, changing whenever the file is reloaded
+func (c *CSVSource) Generation() uint64 {
+	if c.watcher == nil {
+		return 0
+	}
+	return c.watcher.Generation()
+}
+
+// Fields implements the FieldsSource interface
+func (c *CSVSource) Fields() []string {
+	return sourceFieldNames(c.SourceFields)
+}
+
+// GetFeatures implements the Source interface, retrieving the features that intersect the
+// requested tile from the spatial index
+func (c *CSVSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	c.mu.RLock()
+	index := c.index
+	c.mu.RUnlock()
+	return searchFeatures(index, c.SourceFields, req)
+}
@@ -0,0 +1,163 @@
+package tilenol
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+const testCSV = utf8BOM + "id,Site Name,Latitude,Longitude,capacity,zip,active\n" +
+	"1,Pier 39,37.8087,-122.4098,12.5,02134,true\n" +
+	"2,\"Brooklyn Bridge, NY\",40.7061,-73.9969,20,10001,FALSE\n" +
+	"3,Unplaced,,,,,\n" +
+	"4,Nowhere,abc,-10,1,,true\n" +
+	"5,Oakland,37.8044,-122.2712\n"
+
+func TestCSVSource(t *testing.T) {
+	path := writeTestFile(t, "sites.csv", testCSV)
+	source, err := NewCSVSource(&CSVConfig{
+		Path: path,
+		SourceFields: map[string]string{
+			"name":     "Site Name",
+			"capacity": "capacity",
+			"zip":      "zip",
+			"active":   "active",
+		},
+	})
+	assert.Nil(t, err)
+	defer source.(*CSVSource).Close()
+	assert.Equal(t, "csv", Layer{source: source}.SourceType())
+	assert.ElementsMatch(t, []string{"name", "capacity", "zip", "active"}, source.(*CSVSource).Fields())
+
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+	assert.Nil(t, err)
+	assert.Len(t, fc.Features, 3, "Rows without valid coordinates should be skipped")
+
+	fc, err = source.GetFeatures(context.Background(), &TileRequest{X: 2, Y: 6, Z: 4})
+	assert.Nil(t, err)
+	assert.Len(t, fc.Features, 2)
+	for _, f := range fc.Features {
+		switch f.ID {
+		case int64(1):
+			assert.Equal(t, orb.Point{-122.4098, 37.8087}, f.Geometry)
+			assert.Equal(t, map[string]interface{}{
+				"name":     "Pier 39",
+				"capacity": 12.5,
+				"zip":      "02134",
+				"active":   true,
+			}, map[string]interface{}(f.Properties))
+		case int64(5):
+			assert.Equal(t, map[string]interface{}{"name": "Oakland"}, map[string]interface{}(f.Properties))
+		default:
+			t.Errorf("Unexpected feature %v", f.ID)
+		}
+	}
+}
+
+func TestCSVSourceGeometryColumn(t *testing.T) {
+	path := writeTestFile(t, "areas.tsv", "name\tarea\n"+
+		"Point\tPOINT  (1 2)\n"+
+		"Square\tPOLYGON((0 0, 1 0, 1 1, 0 1, 0 0))\n"+
+		"Line\t\"{\"\"type\"\": \"\"LineString\"\", \"\"coordinates\"\": [[0, 0], [2, 2]]}\"\n"+
+		"Empty\t\n")
+	source, err := NewCSVSource(&CSVConfig{
+		Path:          path,
+		Delimiter:     "\t",
+		GeometryField: "area",
+		SourceFields:  map[string]string{"name": "name"},
+	})
+	assert.Nil(t, err)
+
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+	assert.Nil(t, err)
+	geometries := make(map[interface{}]orb.Geometry)
+	for _, f := range fc.Features {
+		geometries[f.Properties["name"]] = f.Geometry
+	}
+	assert.Equal(t, map[interface{}]orb.Geometry{
+		"Point":  orb.Point{1, 2},
+		"Square": orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}},
+		"Line":   orb.LineString{{0, 0}, {2, 2}},
+	}, geometries)
+}
+
+func TestCSVSourceExample(t *testing.T) {
+	// The example data has GeoJSON geometries in a "geometry" column
+	source, err := NewCSVSource(&CSVConfig{
+		Path:         "examples/postgis/data.csv",
+		SourceFields: map[string]string{"height": "height"},
+	})
+	assert.Nil(t, err)
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 309, Y: 378, Z: 10})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, fc.Features)
+	for _, f := range fc.Features {
+		assert.Equal(t, "Polygon", f.Geometry.GeoJSONType())
+		assert.IsType(t, float64(0), f.Properties["height"])
+	}
+}
+
+func TestCSVSourceReload(t *testing.T) {
+	path := writeTestFile(t, "sites.csv", "lat,lon\n1,2\n")
+	// Note: changes are checked for explicitly rather than waiting on the polling interval
+	source, err := NewCSVSource(&CSVConfig{Path: path, ReloadInterval: time.Hour})
+	assert.Nil(t, err)
+	defer source.(*CSVSource).Close()
+	watcher := source.(*CSVSource).watcher
+	layer := Layer{Name: "sites", source: source}
+	version := layer.Hash()
+
+	countFeatures := func() int {
+		fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+		assert.Nil(t, err)
+		return len(fc.Features)
+	}
+	assert.Equal(t, 1, countFeatures())
+
+	// Files without geometry columns are not loaded
+	assert.Nil(t, os.WriteFile(path, []byte("name\nfoo\n"), 0644))
+	watcher.check()
+	assert.Equal(t, 1, countFeatures())
+	assert.Equal(t, version, layer.Hash(), "Failed reloads should not change the layer version")
+
+	assert.Nil(t, os.WriteFile(path, []byte("lat,lon\n1,2\n3,4\n"), 0644))
+	watcher.check()
+	assert.Equal(t, 2, countFeatures())
+	assert.NotEqual(t, version, layer.Hash(), "Reloads should change the layer version, so that cached tiles are not served")
+}
+
+func TestCSVSourceConfig(t *testing.T) {
+	path := writeTestFile(t, "sites.csv", testCSV)
+	tests := []struct {
+		config   CSVConfig
+		expected error
+	}{
+		{CSVConfig{}, MissingCSVPathErr},
+		{CSVConfig{Path: path, Delimiter: ";;"}, InvalidCSVDelimiterErr},
+		{CSVConfig{Path: path, LatitudeField: "Latitude"}, InvalidCSVGeometryConfigErr},
+		{CSVConfig{Path: path, LatitudeField: "Latitude", LongitudeField: "Longitude", GeometryField: "wkt"}, InvalidCSVGeometryConfigErr},
+		{CSVConfig{Path: path, GeometryField: "wkt"}, MissingCSVColumnErr},
+		{CSVConfig{Path: writeTestFile(t, "names.csv", "name\nfoo\n")}, MissingCSVGeometryErr},
+	}
+	for _, test := range tests {
+		_, err := NewCSVSource(&test.config)
+		assert.ErrorIs(t, err, test.expected)
+	}
+}
+
+func TestInferCSVColumnTypes(t *testing.T) {
+	rows := [][]string{
+		{"1", "1.5", "true", "007", "1", " -3 ", "NaN"},
+		{"-2", "2", "False", "7", "yes", "", "1"},
+		{"", "1e3", "", "", ""},
+	}
+	assert.Equal(t, []csvColumnType{csvInt, csvFloat, csvBool, csvString, csvString, csvInt, csvString},
+		inferCSVColumnTypes(rows, 7))
+	assert.Equal(t, int64(-3), parseCSVValue(" -3 ", csvInt))
+	assert.Equal(t, false, parseCSVValue("False", csvBool))
+	assert.Nil(t, parseCSVValue("  ", csvString))
+}
@@ -0,0 +1,319 @@
+package tilenol
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/paulmach/orb/maptile"
+)
+
+const (
+	// DefaultDiskCleanupInterval is the default interval between expired/oversized entry cleanups
+	DefaultDiskCleanupInterval = 5 * time.Minute
+	// diskTempFileGracePeriod is how long temporary files of unfinished writes are kept before
+	// they are considered orphaned (e.g. after a crash) and cleaned up
+	diskTempFileGracePeriod = 10 * time.Minute
+	// diskTempFilePrefix is the name prefix of the temporary files of in-progress writes
+	diskTempFilePrefix = ".tmp-"
+)
+
+var (
+	// MissingDiskPathErr occurs when a DiskCache is configured without a path
+	MissingDiskPathErr = errors.New("DiskCache requires a \"path\" to be configured")
+)
+
+// DiskConfig is the YAML configuration for a DiskCache
+type DiskConfig struct {
+	// Path is the root directory for the cached data
+	Path string `yaml:"path"`
+	// MaxBytes is the approximate disk budget of the cache, after which the oldest entries are
+	// cleaned up (optional)
+	MaxBytes int64 `yaml:"maxBytes"`
+	// TTL is how long each cache entry should remain before refresh (optional)
+	TTL time.Duration `yaml:"ttl"`
+	// CleanupInterval is how often expired and oversized entries are cleaned up (optional)
+	CleanupInterval time.Duration `yaml:"cleanupInterval"`
+}
+
+// DiskCache is a Cache implementation that stores values as files on local disk, using a
+// directory layout that mirrors the layer/z/x/y structure of the cache keys
+type DiskCache struct {
+	// Path is the root directory for the cached data
+	Path string
+	// MaxBytes is the approximate disk budget of the cache
+	MaxBytes int64
+	// TTL is how long each cache entry should remain before refresh
+	TTL time.Duration
+
+	size      int64
+	cleaning  int32
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewDiskCache creates a new DiskCache given a DiskConfig
+func NewDiskCache(config *DiskConfig) (Cache, error) {
+	if config.Path == "" {
+		return nil, MissingDiskPathErr
+	}
+	if err := os.MkdirAll(config.Path, 0755); err != nil {
+		return nil, err
+	}
+	d := &DiskCache{
+		Path:     config.Path,
+		MaxBytes: config.MaxBytes,
+		TTL:      config.TTL,
+		done:     make(chan struct{}),
+	}
+	// Run an initial cleanup, which also computes the current size of the cache
+	if err := d.Cleanup(); err != nil {
+		return nil, err
+	}
+	interval := config.CleanupInterval
+	if interval <= 0 {
+		interval = DefaultDiskCleanupInterval
+	}
+	go d.cleanupLoop(interval)
+	return d, nil
+}
+
+// keyPath computes the file path for a given cache key. The path portion of the key (e.g.
+// layer/z/x/y) is used as the directory structure.
+func (d *DiskCache) keyPath(key string) string {
+	return filepath.Join(append([]string{d.Path}, cacheKeySegments(key)...)...)
+}
+
+// expired checks whether or not a cached file's TTL has elapsed
+func (d *DiskCache) expired(info fs.FileInfo, now time.Time) bool {
+	return d.TTL > 0 && now.Sub(info.ModTime()) > d.TTL
+}
+
+// stat retrieves the file info for a cached key, removing the file if it has expired
+func (d *DiskCache) stat(key string) (string, fs.FileInfo, bool) {
+	path := d.keyPath(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return path, nil, false
+	}
+	if d.expired(info, time.Now()) {
+		if os.Remove(path) == nil {
+			atomic.AddInt64(&d.size, -info.Size())
+		}
+		return path, nil, false
+	}
+	return path, info, true
+}
+
+// Exists checks whether or not there is an unexpired file for the given key
+func (d *DiskCache) Exists(key string) bool {
+	_, _, exists := d.stat(key)
+	return exists
+}
+
+// Get reads the cached file for the given key
+func (d *DiskCache) Get(key string) ([]byte, error) {
+	path, _, exists := d.stat(key)
+	if !exists {
+		return nil, ErrNoValue
+	}
+	val, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoValue
+	}
+	return val, err
+}
+
+// Put atomically writes a new file for the given key, by first writing to a temporary file
+// and then renaming it into place
+func (d *DiskCache) Put(key string, val []byte) error {
+	path := d.keyPath(key)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, diskTempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(val); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	var prevSize int64
+	if info, err := os.Stat(path); err == nil {
+		prevSize = info.Size()
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	size := atomic.AddInt64(&d.size, int64(len(val))-prevSize)
+	if d.MaxBytes > 0 && size > d.MaxBytes {
+		go d.runCleanup()
+	}
+	return nil
+}
+
+// Delete removes the cached file for the given key
+func (d *DiskCache) Delete(key string) error {
+	path := d.keyPath(key)
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	atomic.AddInt64(&d.size, -info.Size())
+	return nil
+}
+
+// PurgeTiles implements the TilePurger interface by walking the directory of the layer
+func (d *DiskCache) PurgeTiles(layerKey string, match func(maptile.Tile) bool) (uint64, error) {
+	root := filepath.Join(d.Path, escapeKeySegment(layerKey))
+	var count uint64
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, TileDataExtension) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		tile, ok := parseTilePath(filepath.ToSlash(rel))
+		if !ok || !match(tile) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			atomic.AddInt64(&d.size, -info.Size())
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// diskCacheFile is a cached file found while walking the cache directory
+type diskCacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Cleanup removes all expired files and orphaned temporary files, and then removes the oldest
+// files until the cache fits within its disk budget
+func (d *DiskCache) Cleanup() error {
+	now := time.Now()
+	// Note: the size counter is adjusted by the difference with the walked size (rather than
+	// overwritten), so that concurrent writes during the walk are still accounted for
+	before := atomic.LoadInt64(&d.size)
+	var files []diskCacheFile
+	var walked int64
+	err := filepath.WalkDir(d.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Files may be concurrently removed by other cleanups or expiring lookups
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		isTemp := strings.HasPrefix(entry.Name(), diskTempFilePrefix)
+		if !isTemp && !strings.HasSuffix(path, TileDataExtension) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if isTemp {
+			if now.Sub(info.ModTime()) > diskTempFileGracePeriod {
+				os.Remove(path)
+			}
+			return nil
+		}
+		if d.expired(info, now) {
+			os.Remove(path)
+			return nil
+		}
+		files = append(files, diskCacheFile{path, info.Size(), info.ModTime()})
+		walked += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	size := atomic.AddInt64(&d.size, walked-before)
+
+	if d.MaxBytes > 0 && size > d.MaxBytes {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].modTime.Before(files[j].modTime)
+		})
+		for _, f := range files {
+			if size <= d.MaxBytes {
+				break
+			}
+			if os.Remove(f.path) == nil {
+				size = atomic.AddInt64(&d.size, -f.size)
+			}
+		}
+	}
+	return nil
+}
+
+// runCleanup runs a cleanup, unless one is already running
+func (d *DiskCache) runCleanup() {
+	if !atomic.CompareAndSwapInt32(&d.cleaning, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&d.cleaning, 0)
+	if err := d.Cleanup(); err != nil {
+		Logger.Errorf("Failed to clean up disk cache [%s]: %v", d.Path, err)
+	}
+}
+
+// cleanupLoop periodically runs cleanups until the cache is closed
+func (d *DiskCache) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.runCleanup()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// Close stops the periodic cleanup of the cache
+func (d *DiskCache) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+	})
+	return nil
+}
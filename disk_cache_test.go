@@ -0,0 +1,133 @@
+package tilenol
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDiskCache(t *testing.T, config *DiskConfig) *DiskCache {
+	config.Path = t.TempDir()
+	cache, err := NewDiskCache(config)
+	if err != nil {
+		t.Fatalf("Could not create DiskCache: %v", err)
+	}
+	t.Cleanup(func() { cache.(*DiskCache).Close() })
+	return cache.(*DiskCache)
+}
+
+func TestDiskCachePutGet(t *testing.T) {
+	cache := newTestDiskCache(t, &DiskConfig{})
+	key := "buildings@abc/14/2620/6332?q=height:>10"
+
+	assert.False(t, cache.Exists(key))
+	_, err := cache.Get(key)
+	assert.Equal(t, ErrNoValue, err)
+
+	assert.Nil(t, cache.Put(key, []byte("tile")))
+	assert.True(t, cache.Exists(key))
+	val, err := cache.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("tile"), val)
+
+	// Keys that only differ by query string should not collide
+	assert.False(t, cache.Exists("buildings@abc/14/2620/6332"))
+}
+
+func TestDiskCacheLayout(t *testing.T) {
+	cache := newTestDiskCache(t, &DiskConfig{})
+	assert.Nil(t, cache.Put("buildings@abc/14/2620/6332", []byte("tile")))
+
+	path := filepath.Join(cache.Path, "buildings@abc", "14", "2620", "6332"+TileDataExtension)
+	_, err := os.Stat(path)
+	assert.Nil(t, err, "Expected cache file to be sharded by layer/z/x/y")
+
+	// Path traversal should not escape the cache directory
+	assert.True(t, strings.HasPrefix(cache.keyPath("../../etc/passwd"), cache.Path))
+}
+
+func TestDiskCacheTTL(t *testing.T) {
+	cache := newTestDiskCache(t, &DiskConfig{TTL: time.Hour})
+	assert.Nil(t, cache.Put("a/0/0/0", []byte("tile")))
+
+	// Backdate the file so that it is expired
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(cache.keyPath("a/0/0/0"), old, old)
+
+	assert.False(t, cache.Exists("a/0/0/0"))
+	_, err := os.Stat(cache.keyPath("a/0/0/0"))
+	assert.True(t, os.IsNotExist(err), "Expired file should have been removed")
+}
+
+func TestDiskCacheSizeCleanup(t *testing.T) {
+	cache := newTestDiskCache(t, &DiskConfig{})
+	for i, key := range []string{"a/0/0/0", "a/1/0/0", "a/1/1/0"} {
+		assert.Nil(t, cache.Put(key, make([]byte, 10)))
+		mtime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(cache.keyPath(key), mtime, mtime)
+	}
+
+	// Note: the budget is only set after the puts, which would otherwise trigger a background
+	// cleanup before the files are backdated
+	cache.MaxBytes = 25
+	assert.Nil(t, cache.Cleanup())
+	assert.False(t, cache.Exists("a/0/0/0"), "Oldest entry should have been cleaned up")
+	assert.True(t, cache.Exists("a/1/0/0"))
+	assert.True(t, cache.Exists("a/1/1/0"))
+	assert.Equal(t, int64(20), atomic.LoadInt64(&cache.size))
+}
+
+func TestDiskCacheTempFileCleanup(t *testing.T) {
+	cache := newTestDiskCache(t, &DiskConfig{})
+	dir := filepath.Join(cache.Path, "a", "0", "0")
+	assert.Nil(t, os.MkdirAll(dir, 0755))
+	orphaned, pending := filepath.Join(dir, ".tmp-1"), filepath.Join(dir, ".tmp-2")
+	assert.Nil(t, os.WriteFile(orphaned, []byte("tile"), 0644))
+	assert.Nil(t, os.WriteFile(pending, []byte("tile"), 0644))
+	old := time.Now().Add(-2 * diskTempFileGracePeriod)
+	os.Chtimes(orphaned, old, old)
+
+	assert.Nil(t, cache.Cleanup())
+	_, err := os.Stat(orphaned)
+	assert.True(t, os.IsNotExist(err), "Orphaned temporary file should have been removed")
+	_, err = os.Stat(pending)
+	assert.Nil(t, err, "Temporary file of an in-progress write should be kept")
+	assert.Equal(t, int64(0), atomic.LoadInt64(&cache.size), "Temporary files should not count towards the cache size")
+}
+
+func TestDiskCachePurgeTiles(t *testing.T) {
+	cache := newTestDiskCache(t, &DiskConfig{})
+	for _, key := range []string{"a@1/1/1/0", "a@1/1/1/0?s=name", "a@1/1/0/0", "b@1/1/1/0"} {
+		assert.Nil(t, cache.Put(key, []byte("tile")))
+	}
+	count, err := cache.PurgeTiles("a@1", func(tile maptile.Tile) bool {
+		return tile.X == 1
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+	assert.False(t, cache.Exists("a@1/1/1/0"))
+	assert.False(t, cache.Exists("a@1/1/1/0?s=name"))
+	assert.True(t, cache.Exists("a@1/1/0/0"))
+	assert.True(t, cache.Exists("b@1/1/1/0"))
+	assert.Equal(t, int64(8), atomic.LoadInt64(&cache.size))
+
+	count, err = cache.PurgeTiles("missing@1", func(maptile.Tile) bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), count)
+}
+
+func TestCreateDiskCache(t *testing.T) {
+	cache, err := CreateCache(&CacheConfig{Disk: &DiskConfig{Path: t.TempDir()}})
+	assert.Nil(t, err)
+	assert.IsType(t, &DiskCache{}, cache)
+	cache.(*DiskCache).Close()
+
+	_, err = CreateCache(&CacheConfig{Disk: &DiskConfig{}})
+	assert.Equal(t, MissingDiskPathErr, err)
+}
@@ -92,6 +92,34 @@ func (e *ElasticsearchSource) GetFeatures(ctx context.Context, req *TileRequest)
 	return e.doGetFeatures(ctx, req)
 }
 
+// HealthCheck implements the HealthChecker interface by pinging the Elasticsearch cluster
+// and ensuring that the configured index exists
+func (e *ElasticsearchSource) HealthCheck(ctx context.Context) error {
+	if ok, err := e.ES.Ping().Do(ctx); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("Elasticsearch cluster is not reachable")
+	}
+	if ok, err := e.ES.Indices.Exists(e.Index).Do(ctx); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("Elasticsearch index [%s] does not exist", e.Index)
+	}
+	return nil
+}
+
+// Fields implements the FieldsSource interface, returning the configured feature property
+// names along with the implicit "id" property
+func (e *ElasticsearchSource) Fields() []string {
+	fields := []string{"id"}
+	for prop := range e.SourceFields {
+		if prop != "id" {
+			fields = append(fields, prop)
+		}
+	}
+	return fields
+}
+
 // Given the list of extra source arguments that were specified with request, transform
 // these into a map of property name to ES document source path, or return an error
 // if there is a malformed extra source argument.
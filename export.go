@@ -0,0 +1,192 @@
+package tilenol
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/maptile"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// DefaultExportConcurrency is the default number of tiles rendered concurrently
+	DefaultExportConcurrency = 8
+)
+
+// TileWriter is implemented by the tile archive formats that layers can be exported to
+type TileWriter interface {
+	io.Closer
+	// WriteTile stores the gzipped vector tile data for a single tile. Note that calls to
+	// WriteTile are serialized, but tiles are not written in any particular order.
+	WriteTile(tile maptile.Tile, data []byte) error
+	// Finalize stores the metadata describing the exported tiles, after all tiles have
+	// been written
+	Finalize(metadata *TileJSON) error
+}
+
+// ExportOptions configures the export of layers to a tile archive
+type ExportOptions struct {
+	// Name is the name of the exported tileset
+	Name string
+	// Description is the description of the exported tileset (optional)
+	Description string
+	// Layers is the list of layer names to export (all layers if empty or AllLayers)
+	Layers []string
+	// Cover is the set of tiles to export
+	Cover TileCover
+	// Concurrency is the maximum number of tiles rendered concurrently
+	Concurrency int
+	// ProgressInterval is the interval between progress reports
+	ProgressInterval time.Duration
+}
+
+// ExportResult is the summary of an export
+type ExportResult struct {
+	// Total is the total number of tiles covered by the export
+	Total uint64
+	// Written is the number of tiles written to the archive
+	Written uint64
+	// Empty is the number of tiles skipped as they contain no features
+	Empty uint64
+}
+
+// exportMetadata describes the exported layers as a TileJSON document, restricted to the
+// exported area and zoom range
+func (o *ExportOptions) exportMetadata(layers []Layer) *TileJSON {
+	tj := NewTileJSON(o.Name, layers, "")
+	tj.Tiles = []string{}
+	if o.Description != "" {
+		tj.Description = o.Description
+	}
+	bound := o.Cover.Bound
+	if o.Cover.Geometry != nil {
+		bound = o.Cover.Geometry.Bound()
+	}
+	tj.Bounds = [4]float64{
+		max(bound.Min[0], WorldBound.Min[0]),
+		max(bound.Min[1], WorldBound.Min[1]),
+		min(bound.Max[0], WorldBound.Max[0]),
+		min(bound.Max[1], WorldBound.Max[1]),
+	}
+	tj.Minzoom = max(tj.Minzoom, o.Cover.MinZoom)
+	tj.Maxzoom = min(tj.Maxzoom, o.Cover.MaxZoom)
+	for i := range tj.VectorLayers {
+		tj.VectorLayers[i].Minzoom = max(tj.VectorLayers[i].Minzoom, o.Cover.MinZoom)
+		tj.VectorLayers[i].Maxzoom = min(tj.VectorLayers[i].Maxzoom, o.Cover.MaxZoom)
+	}
+	return tj
+}
+
+// center returns the center of the TileJSON bounds
+func (tj *TileJSON) center() orb.Point {
+	return orb.Point{(tj.Bounds[0] + tj.Bounds[2]) / 2, (tj.Bounds[1] + tj.Bounds[3]) / 2}
+}
+
+// renderTile computes the gzipped vector tile of the given layers through the same pipeline as
+// the tile endpoint. Note that empty layers are omitted, and tiles without any features are
+// returned as nil.
+func (s *Server) renderTile(ctx context.Context, layers []Layer, t maptile.Tile) ([]byte, error) {
+	req := &TileRequest{X: int(t.X), Y: int(t.Y), Z: int(t.Z)}
+	fcLayers, err := s.getTileLayers(ctx, req, filterLayersByZoom(layers, req.Z))
+	if err != nil {
+		return nil, err
+	}
+	var nonEmpty mvt.Layers
+	for _, fcLayer := range fcLayers {
+		if len(fcLayer.Features) > 0 {
+			nonEmpty = append(nonEmpty, fcLayer)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return nil, nil
+	}
+	return mvt.MarshalGzipped(nonEmpty)
+}
+
+// Export renders the given layers for every tile in the cover and writes them to a tile
+// archive, finalizing it with the metadata of the exported layers. Unlike seeding, the export
+// is aborted if any tile fails to render. Note that tiles are always rendered from the layer
+// sources, bypassing the cache (and its stale fallbacks).
+func (s *Server) Export(ctx context.Context, opts ExportOptions, w TileWriter) (*ExportResult, error) {
+	if s.Metrics == nil {
+		s.Metrics = NewMetrics()
+	}
+	layersToExport, err := s.layersByNames(opts.Layers)
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]Layer, len(layersToExport))
+	for i, layer := range layersToExport {
+		layer.Cacheable = false
+		layers[i] = layer
+	}
+	total, err := opts.Cover.Count()
+	if err != nil {
+		return nil, err
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = DefaultExportConcurrency
+	}
+	progressInterval := opts.ProgressInterval
+	if progressInterval <= 0 {
+		progressInterval = DefaultProgressInterval
+	}
+
+	var processed, written uint64
+	start := time.Now()
+	reportProgress := func() {
+		n := atomic.LoadUint64(&processed)
+		rate := float64(n) / time.Since(start).Seconds()
+		Logger.Infof("Exported %d/%d tiles (%.1f%%), %.1f tiles/s",
+			n, total, 100*float64(n)/float64(max(total, 1)), rate)
+	}
+	stopProgress := reportEvery(progressInterval, reportProgress)
+
+	var mu sync.Mutex
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(concurrency)
+	err = opts.Cover.Each(func(t maptile.Tile) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		eg.Go(func() error {
+			data, err := s.renderTile(ctx, layers, t)
+			if err != nil {
+				return fmt.Errorf("Failed to render tile (%d, %d, %d): %w", t.Z, t.X, t.Y, err)
+			}
+			if data != nil {
+				mu.Lock()
+				err = w.WriteTile(t, data)
+				mu.Unlock()
+				if err != nil {
+					return err
+				}
+				atomic.AddUint64(&written, 1)
+			}
+			atomic.AddUint64(&processed, 1)
+			return nil
+		})
+		return nil
+	})
+	// Note: a tile failure cancels the context, so its error takes precedence
+	if waitErr := eg.Wait(); waitErr != nil {
+		err = waitErr
+	}
+	stopProgress()
+	if err != nil {
+		return nil, err
+	}
+	reportProgress()
+
+	if err := w.Finalize(opts.exportMetadata(layers)); err != nil {
+		return nil, err
+	}
+	return &ExportResult{Total: total, Written: written, Empty: total - written}, nil
+}
@@ -0,0 +1,125 @@
+package tilenol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryTileWriter struct {
+	tiles    map[maptile.Tile][]byte
+	metadata *TileJSON
+	closed   bool
+}
+
+func (m *memoryTileWriter) WriteTile(t maptile.Tile, data []byte) error {
+	if m.tiles == nil {
+		m.tiles = make(map[maptile.Tile][]byte)
+	}
+	m.tiles[t] = data
+	return nil
+}
+
+func (m *memoryTileWriter) Finalize(metadata *TileJSON) error {
+	m.metadata = metadata
+	return nil
+}
+
+func (m *memoryTileWriter) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	layers := []Layer{
+		Layer{Name: "a", Description: "Layer A", source: &pointSource{orb.Point{1, 1}}},
+		Layer{Name: "b", Minzoom: 2, source: &pointSource{orb.Point{1, 1}}},
+		Layer{Name: "c", source: &NilSource{}},
+	}
+	server := &Server{Layers: layers, Cache: &NilCache{}}
+	w := &memoryTileWriter{}
+
+	result, err := server.Export(context.Background(), ExportOptions{
+		Name:   "test",
+		Layers: []string{"a", "b"},
+		Cover:  TileCover{Bound: orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{10, 10}}, MinZoom: 1, MaxZoom: 2},
+	}, w)
+	assert.Nil(t, err)
+	assert.Equal(t, &ExportResult{Total: 2, Written: 2}, result)
+	assert.Contains(t, w.tiles, maptile.New(1, 0, 1))
+	assert.Contains(t, w.tiles, maptile.New(2, 1, 2))
+
+	assert.Equal(t, "test", w.metadata.Name)
+	assert.Equal(t, 1, w.metadata.Minzoom)
+	assert.Equal(t, 2, w.metadata.Maxzoom)
+	assert.Equal(t, [4]float64{0, 0, 10, 10}, w.metadata.Bounds)
+	assert.Len(t, w.metadata.VectorLayers, 2)
+	assert.Equal(t, 1, w.metadata.VectorLayers[0].Minzoom)
+	assert.Equal(t, 2, w.metadata.VectorLayers[0].Maxzoom)
+	assert.Equal(t, 2, w.metadata.VectorLayers[1].Minzoom)
+}
+
+func TestExportSkipsEmptyTiles(t *testing.T) {
+	layers := []Layer{Layer{Name: "a", source: &NilSource{}}}
+	server := &Server{Layers: layers, Cache: &NilCache{}}
+	w := &memoryTileWriter{}
+
+	result, err := server.Export(context.Background(), ExportOptions{
+		Cover: TileCover{Bound: WorldBound, MinZoom: 0, MaxZoom: 1},
+	}, w)
+	assert.Nil(t, err)
+	assert.Equal(t, &ExportResult{Total: 5, Empty: 5}, result)
+	assert.Empty(t, w.tiles)
+	assert.NotNil(t, w.metadata)
+}
+
+func TestExportBypassesCache(t *testing.T) {
+	source := &flakySource{Source: &pointSource{orb.Point{1, 1}}}
+	cache := NewInMemoryCache().(*InMemoryCache)
+	layers := []Layer{Layer{Name: "a", Cacheable: true, source: source}}
+	server := &Server{
+		Layers:    layers,
+		Cache:     cache,
+		Staleness: StalenessConfig{HardTTL: time.Minute, StaleIfError: time.Hour},
+	}
+	handler, _ := server.setupRoutes()
+	assert.Equal(t, 200, requestTile(handler, "/a/0/0/0.mvt"))
+	assert.Equal(t, 1, cache.Len())
+
+	// Expired entries would be served if the source fails, but should not end up in exports
+	source.Fail = true
+	ageCacheEntries(cache, 2*time.Minute)
+	_, err := server.Export(context.Background(), ExportOptions{
+		Cover: TileCover{Bound: WorldBound, MinZoom: 0, MaxZoom: 0},
+	}, &memoryTileWriter{})
+	assert.NotNil(t, err)
+
+	source.Fail = false
+	w := &memoryTileWriter{}
+	_, err = server.Export(context.Background(), ExportOptions{
+		Cover: TileCover{Bound: WorldBound, MinZoom: 0, MaxZoom: 1},
+	}, w)
+	assert.Nil(t, err)
+	assert.Len(t, w.tiles, 5)
+	assert.Equal(t, 1, cache.Len(), "Exported tiles should not be cached")
+	assert.True(t, layers[0].Cacheable, "The server layers should not be modified")
+}
+
+func TestExportSourceError(t *testing.T) {
+	source := &flakySource{Source: &NilSource{}, Fail: true}
+	layers := []Layer{Layer{Name: "a", source: source}}
+	server := &Server{Layers: layers, Cache: &NilCache{}}
+	w := &memoryTileWriter{}
+
+	_, err := server.Export(context.Background(), ExportOptions{
+		Cover: TileCover{Bound: WorldBound, MinZoom: 0, MaxZoom: 1},
+	}, w)
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, context.Canceled), "The tile error should be returned: %s", err)
+	assert.Nil(t, w.metadata, "Failed exports should not be finalized")
+}
@@ -0,0 +1,165 @@
+package tilenol
+
+import (
+	"math"
+	"sort"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const (
+	// featureIndexNodeSize is the maximum number of children of a featureIndex node
+	featureIndexNodeSize = 16
+)
+
+// featureIndex is a static, in-memory R-tree of features, bulk loaded using the
+// Sort-Tile-Recursive algorithm. Since the file-based sources replace their whole index upon
+// reload, the tree does not support incremental updates.
+type featureIndex struct {
+	root *featureIndexNode
+	size int
+}
+
+// featureIndexNode is a single node of a featureIndex, which is either a leaf holding a single
+// feature or an internal node holding child nodes
+type featureIndexNode struct {
+	bound    orb.Bound
+	children []*featureIndexNode
+	feature  *geojson.Feature
+}
+
+// newFeatureIndex bulk loads a featureIndex from the given features. Note that features
+// without a geometry are not indexed.
+func newFeatureIndex(features []*geojson.Feature) *featureIndex {
+	nodes := make([]*featureIndexNode, 0, len(features))
+	for _, f := range features {
+		if f.Geometry == nil {
+			continue
+		}
+		nodes = append(nodes, &featureIndexNode{bound: f.Geometry.Bound(), feature: f})
+	}
+	idx := &featureIndex{size: len(nodes)}
+	if len(nodes) == 0 {
+		return idx
+	}
+	for len(nodes) > featureIndexNodeSize {
+		nodes = packFeatureIndexNodes(nodes)
+	}
+	idx.root = newFeatureIndexParent(nodes)
+	return idx
+}
+
+// newFeatureIndexParent creates an internal node for the given children
+func newFeatureIndexParent(children []*featureIndexNode) *featureIndexNode {
+	bound := children[0].bound
+	for _, child := range children[1:] {
+		bound = bound.Union(child.bound)
+	}
+	return &featureIndexNode{bound: bound, children: children}
+}
+
+// packFeatureIndexNodes groups one level of the tree into parent nodes, by sorting the nodes
+// into vertical slices along the X axis and then packing each slice along the Y axis
+func packFeatureIndexNodes(nodes []*featureIndexNode) []*featureIndexNode {
+	parentCount := int(math.Ceil(float64(len(nodes)) / featureIndexNodeSize))
+	sliceSize := int(math.Ceil(math.Sqrt(float64(parentCount)))) * featureIndexNodeSize
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].bound.Center()[0] < nodes[j].bound.Center()[0]
+	})
+	parents := make([]*featureIndexNode, 0, parentCount)
+	for start := 0; start < len(nodes); start += sliceSize {
+		slice := nodes[start:min(start+sliceSize, len(nodes))]
+		sort.Slice(slice, func(i, j int) bool {
+			return slice[i].bound.Center()[1] < slice[j].bound.Center()[1]
+		})
+		for i := 0; i < len(slice); i += featureIndexNodeSize {
+			parents = append(parents, newFeatureIndexParent(slice[i:min(i+featureIndexNodeSize, len(slice))]))
+		}
+	}
+	return parents
+}
+
+// Len returns the number of indexed features
+func (idx *featureIndex) Len() int {
+	return idx.size
+}
+
+// Search calls fn for every feature whose bounds intersect the given bounds
+func (idx *featureIndex) Search(bound orb.Bound, fn func(*geojson.Feature)) {
+	if idx.root == nil {
+		return
+	}
+	stack := []*featureIndexNode{idx.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !node.bound.Intersects(bound) {
+			continue
+		}
+		if node.feature != nil {
+			fn(node.feature)
+			continue
+		}
+		stack = append(stack, node.children...)
+	}
+}
+
+// requestSourceFields merges any extra source fields requested through the "s" argument (of
+// the form <property_name>:<source_property>) into the configured SourceFields
+func requestSourceFields(sourceFields map[string]string, req *TileRequest) (map[string]string, error) {
+	incArgs, exists := req.Args["s"]
+	if !exists {
+		return sourceFields, nil
+	}
+	extraFields, err := makeFieldMap(incArgs)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(sourceFields)+len(extraFields))
+	for prop, sourceProp := range sourceFields {
+		fields[prop] = sourceProp
+	}
+	for prop, sourceProp := range extraFields {
+		fields[prop] = sourceProp
+	}
+	return fields, nil
+}
+
+// sourceFieldNames returns the feature property names of a SourceFields mapping, e.g. for the
+// FieldsSource implementations of sources configured with SourceFields
+func sourceFieldNames(sourceFields map[string]string) []string {
+	var fields []string
+	for prop := range sourceFields {
+		fields = append(fields, prop)
+	}
+	return fields
+}
+
+// mapFeature copies an indexed feature for a response, keeping only the properties in the
+// mapping from feature property name to source property name. Note that the geometry is
+// cloned, as the tile pipeline projects and clips geometries in place.
+func mapFeature(f *geojson.Feature, fields map[string]string) *geojson.Feature {
+	feature := geojson.NewFeature(orb.Clone(f.Geometry))
+	feature.ID = f.ID
+	for prop, sourceProp := range fields {
+		if val, found := f.Properties[sourceProp]; found && val != nil {
+			feature.Properties[prop] = val
+		}
+	}
+	return feature
+}
+
+// searchFeatures retrieves the mapped features of the index that intersect the requested tile
+func searchFeatures(idx *featureIndex, sourceFields map[string]string, req *TileRequest) (*geojson.FeatureCollection, error) {
+	fields, err := requestSourceFields(sourceFields, req)
+	if err != nil {
+		return nil, err
+	}
+	fc := geojson.NewFeatureCollection()
+	idx.Search(req.MapTile().Bound(), func(f *geojson.Feature) {
+		fc.Append(mapFeature(f, fields))
+	})
+	return fc, nil
+}
@@ -0,0 +1,67 @@
+package tilenol
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureIndexSearch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var features []*geojson.Feature
+	for i := 0; i < 5000; i++ {
+		min := orb.Point{r.Float64()*360 - 180, r.Float64()*170 - 85}
+		var geom orb.Geometry = min
+		if i%2 == 0 {
+			geom = orb.LineString{min, {min[0] + r.Float64(), min[1] + r.Float64()}}
+		}
+		f := geojson.NewFeature(geom)
+		f.ID = i
+		features = append(features, f)
+	}
+	features = append(features, &geojson.Feature{ID: "empty"})
+	idx := newFeatureIndex(features)
+	assert.Equal(t, 5000, idx.Len())
+
+	for i := 0; i < 50; i++ {
+		min := orb.Point{r.Float64()*360 - 180, r.Float64()*170 - 85}
+		bound := orb.Bound{Min: min, Max: orb.Point{min[0] + r.Float64()*20, min[1] + r.Float64()*20}}
+		var expected, actual []int
+		for _, f := range features[:5000] {
+			if f.Geometry.Bound().Intersects(bound) {
+				expected = append(expected, f.ID.(int))
+			}
+		}
+		idx.Search(bound, func(f *geojson.Feature) {
+			actual = append(actual, f.ID.(int))
+		})
+		sort.Ints(actual)
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func TestFeatureIndexEmpty(t *testing.T) {
+	idx := newFeatureIndex(nil)
+	assert.Equal(t, 0, idx.Len())
+	idx.Search(WorldBound, func(f *geojson.Feature) {
+		t.Errorf("Unexpected feature: %v", f)
+	})
+}
+
+func TestMapFeature(t *testing.T) {
+	f := geojson.NewFeature(orb.LineString{{0, 0}, {1, 1}})
+	f.ID = "a"
+	f.Properties["NAME"] = "Main St"
+	f.Properties["LANES"] = nil
+	mapped := mapFeature(f, map[string]string{"name": "NAME", "lanes": "LANES", "missing": "MISSING"})
+	assert.Equal(t, "a", mapped.ID)
+	assert.Equal(t, geojson.Properties{"name": "Main St"}, mapped.Properties)
+
+	// The indexed geometry must not be affected by changes to the response
+	mapped.Geometry.(orb.LineString)[0] = orb.Point{5, 5}
+	assert.Equal(t, orb.Point{0, 0}, f.Geometry.(orb.LineString)[0])
+}
@@ -0,0 +1,82 @@
+package tilenol
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fileWatcher polls a file for changes, reloading it whenever its modification time or size
+// changes. Failed reloads are logged, and retried upon the next change.
+type fileWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+	reload  func() error
+	// generation is the number of successful reloads
+	generation uint64
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// watchFile starts polling the given file for changes at the given interval, where info is the
+// state of the file when it was last loaded
+func watchFile(path string, info os.FileInfo, interval time.Duration, reload func() error) *fileWatcher {
+	w := &fileWatcher{
+		path:    path,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		reload:  reload,
+		done:    make(chan struct{}),
+	}
+	go w.watchLoop(interval)
+	return w
+}
+
+// check reloads the file if it has changed since the last check
+func (w *fileWatcher) check() {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		Logger.Warningf("Failed to check file [%s] for changes: %v", w.path, err)
+		return
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return
+	}
+	w.modTime, w.size = info.ModTime(), info.Size()
+	start := time.Now()
+	if err := w.reload(); err != nil {
+		Logger.Errorf("Failed to reload file [%s]: %v", w.path, err)
+		return
+	}
+	atomic.AddUint64(&w.generation, 1)
+	Logger.Infof("Reloaded file [%s] in %s", w.path, time.Since(start))
+}
+
+// Generation returns the number of times the file was successfully reloaded
+func (w *fileWatcher) Generation() uint64 {
+	return atomic.LoadUint64(&w.generation)
+}
+
+// watchLoop periodically checks the file for changes until the watcher is closed
+func (w *fileWatcher) watchLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Close stops polling the file for changes
+func (w *fileWatcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+	return nil
+}
@@ -0,0 +1,475 @@
+package tilenol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const (
+	// flatgeobufNodeItemSize is the size of a node of the packed Hilbert R-tree index
+	flatgeobufNodeItemSize = 40
+	// flatgeobufMaxHeaderSize is the maximum size of a FlatGeobuf header, to guard against
+	// corrupted files
+	flatgeobufMaxHeaderSize = 10 << 20
+)
+
+// FlatGeobuf geometry types
+const (
+	fgbGeometryUnknown            = 0
+	fgbGeometryPoint              = 1
+	fgbGeometryLineString         = 2
+	fgbGeometryPolygon            = 3
+	fgbGeometryMultiPoint         = 4
+	fgbGeometryMultiLineString    = 5
+	fgbGeometryMultiPolygon       = 6
+	fgbGeometryGeometryCollection = 7
+)
+
+// FlatGeobuf column types
+const (
+	fgbColumnByte = iota
+	fgbColumnUByte
+	fgbColumnBool
+	fgbColumnShort
+	fgbColumnUShort
+	fgbColumnInt
+	fgbColumnUInt
+	fgbColumnLong
+	fgbColumnULong
+	fgbColumnFloat
+	fgbColumnDouble
+	fgbColumnString
+	fgbColumnJSON
+	fgbColumnDateTime
+	fgbColumnBinary
+)
+
+var (
+	// InvalidFlatGeobufErr is the error returned when a file is not a valid FlatGeobuf file
+	InvalidFlatGeobufErr = errors.New("Invalid FlatGeobuf file")
+	// flatgeobufMagic is the magic number at the start of FlatGeobuf (version 3) files
+	flatgeobufMagic = []byte{'f', 'g', 'b', 3}
+)
+
+// fbTable is a table of a FlatBuffers buffer, which provides just enough of the FlatBuffers
+// encoding to read FlatGeobuf headers and features. Out of bounds accesses are reported through
+// panics, which are recovered by the callers.
+type fbTable struct {
+	buf    []byte
+	pos    int
+	vtable int
+	vsize  int
+}
+
+// fbRoot reads the root table of a FlatBuffers buffer
+func fbRoot(buf []byte) fbTable {
+	return fbTableAt(buf, int(binary.LittleEndian.Uint32(buf)))
+}
+
+// fbTableAt reads the table at the given position
+func fbTableAt(buf []byte, pos int) fbTable {
+	vtable := pos - int(int32(binary.LittleEndian.Uint32(buf[pos:])))
+	return fbTable{
+		buf:    buf,
+		pos:    pos,
+		vtable: vtable,
+		vsize:  int(binary.LittleEndian.Uint16(buf[vtable:])),
+	}
+}
+
+// field returns the position of the given field of the table, or zero if the field is absent
+func (t fbTable) field(i int) int {
+	entry := 4 + 2*i
+	if entry+2 > t.vsize {
+		return 0
+	}
+	offset := int(binary.LittleEndian.Uint16(t.buf[t.vtable+entry:]))
+	if offset == 0 {
+		return 0
+	}
+	return t.pos + offset
+}
+
+// indirect follows the offset stored at the given position
+func (t fbTable) indirect(pos int) int {
+	return pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+// Uint8 reads an unsigned byte field (or bool/enum field)
+func (t fbTable) Uint8(i int, def uint8) uint8 {
+	if pos := t.field(i); pos != 0 {
+		return t.buf[pos]
+	}
+	return def
+}
+
+// Uint16 reads an unsigned short field
+func (t fbTable) Uint16(i int, def uint16) uint16 {
+	if pos := t.field(i); pos != 0 {
+		return binary.LittleEndian.Uint16(t.buf[pos:])
+	}
+	return def
+}
+
+// Int32 reads an int field
+func (t fbTable) Int32(i int, def int32) int32 {
+	if pos := t.field(i); pos != 0 {
+		return int32(binary.LittleEndian.Uint32(t.buf[pos:]))
+	}
+	return def
+}
+
+// Uint64 reads an unsigned long field
+func (t fbTable) Uint64(i int, def uint64) uint64 {
+	if pos := t.field(i); pos != 0 {
+		return binary.LittleEndian.Uint64(t.buf[pos:])
+	}
+	return def
+}
+
+// Vector returns the position of the first element and the length of a vector field
+func (t fbTable) Vector(i int) (int, int) {
+	pos := t.field(i)
+	if pos == 0 {
+		return 0, 0
+	}
+	vec := t.indirect(pos)
+	return vec + 4, int(binary.LittleEndian.Uint32(t.buf[vec:]))
+}
+
+// Bytes reads a string or byte vector field
+func (t fbTable) Bytes(i int) []byte {
+	start, n := t.Vector(i)
+	if n == 0 {
+		return nil
+	}
+	return t.buf[start : start+n]
+}
+
+// String reads a string field
+func (t fbTable) String(i int) string {
+	return string(t.Bytes(i))
+}
+
+// Table reads a table field
+func (t fbTable) Table(i int) (fbTable, bool) {
+	pos := t.field(i)
+	if pos == 0 {
+		return fbTable{}, false
+	}
+	return fbTableAt(t.buf, t.indirect(pos)), true
+}
+
+// Tables reads a vector of tables field
+func (t fbTable) Tables(i int) []fbTable {
+	start, n := t.Vector(i)
+	tables := make([]fbTable, n)
+	for j := range tables {
+		tables[j] = fbTableAt(t.buf, t.indirect(start+4*j))
+	}
+	return tables
+}
+
+// Float64s reads a vector of doubles field
+func (t fbTable) Float64s(i int) []float64 {
+	start, n := t.Vector(i)
+	values := make([]float64, n)
+	for j := range values {
+		values[j] = math.Float64frombits(binary.LittleEndian.Uint64(t.buf[start+8*j:]))
+	}
+	return values
+}
+
+// Uint32s reads a vector of unsigned ints field
+func (t fbTable) Uint32s(i int) []uint32 {
+	start, n := t.Vector(i)
+	values := make([]uint32, n)
+	for j := range values {
+		values[j] = binary.LittleEndian.Uint32(t.buf[start+4*j:])
+	}
+	return values
+}
+
+// fbRecover converts a panic caused by a corrupted FlatBuffers buffer into an error
+func fbRecover(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%w: %v", InvalidFlatGeobufErr, r)
+	}
+}
+
+// flatgeobufColumn is a column (i.e. property) definition of a FlatGeobuf file
+type flatgeobufColumn struct {
+	Name string
+	Type uint8
+}
+
+// flatgeobufHeader is the decoded header of a FlatGeobuf file
+type flatgeobufHeader struct {
+	GeometryType  uint8
+	HasZ, HasM    bool
+	HasT, HasTM   bool
+	Columns       []flatgeobufColumn
+	FeaturesCount uint64
+	IndexNodeSize uint16
+	CRSCode       int32
+	CRSWKT        string
+}
+
+// readFlatGeobufHeader reads and validates the magic number and header of a FlatGeobuf file,
+// returning the header and the offset of the index (or of the features, if there is no index)
+func readFlatGeobufHeader(r io.ReaderAt) (header *flatgeobufHeader, offset int64, err error) {
+	prefix := make([]byte, 12)
+	if _, err := r.ReadAt(prefix, 0); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", InvalidFlatGeobufErr, err)
+	}
+	if !bytes.Equal(prefix[:4], flatgeobufMagic) {
+		return nil, 0, fmt.Errorf("%w: invalid magic number", InvalidFlatGeobufErr)
+	}
+	size := binary.LittleEndian.Uint32(prefix[8:])
+	if size < 4 || size > flatgeobufMaxHeaderSize {
+		return nil, 0, fmt.Errorf("%w: invalid header size", InvalidFlatGeobufErr)
+	}
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, 12); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", InvalidFlatGeobufErr, err)
+	}
+
+	defer fbRecover(&err)
+	t := fbRoot(buf)
+	header = &flatgeobufHeader{
+		GeometryType:  t.Uint8(2, fgbGeometryUnknown),
+		HasZ:          t.Uint8(3, 0) != 0,
+		HasM:          t.Uint8(4, 0) != 0,
+		HasT:          t.Uint8(5, 0) != 0,
+		HasTM:         t.Uint8(6, 0) != 0,
+		FeaturesCount: t.Uint64(8, 0),
+		IndexNodeSize: t.Uint16(9, 16),
+	}
+	header.Columns = readFlatGeobufColumns(t.Tables(7))
+	if crs, ok := t.Table(10); ok {
+		header.CRSCode = crs.Int32(1, 0)
+		header.CRSWKT = crs.String(4)
+	}
+	return header, 12 + int64(size), nil
+}
+
+// readFlatGeobufColumns reads column definitions
+func readFlatGeobufColumns(tables []fbTable) []flatgeobufColumn {
+	columns := make([]flatgeobufColumn, len(tables))
+	for i, c := range tables {
+		columns[i] = flatgeobufColumn{Name: c.String(0), Type: c.Uint8(1, 0)}
+	}
+	return columns
+}
+
+// flatgeobufIndexSize computes the size of the packed Hilbert R-tree index, along with the
+// node ranges of each level of the tree (where the leaves are the first level)
+func flatgeobufIndexSize(numItems uint64, nodeSize uint16) (uint64, [][2]uint64) {
+	if nodeSize < 2 || numItems == 0 {
+		return 0, nil
+	}
+	n := numItems
+	numNodes := n
+	levelNumNodes := []uint64{n}
+	for n != 1 {
+		n = (n + uint64(nodeSize) - 1) / uint64(nodeSize)
+		numNodes += n
+		levelNumNodes = append(levelNumNodes, n)
+	}
+	levelBounds := make([][2]uint64, len(levelNumNodes))
+	n = numNodes
+	for i, size := range levelNumNodes {
+		levelBounds[i] = [2]uint64{n - size, n}
+		n -= size
+	}
+	return numNodes * flatgeobufNodeItemSize, levelBounds
+}
+
+// decodeFlatGeobufGeometry decodes a Geometry table, where the geometry type may be inherited
+// from the header
+func decodeFlatGeobufGeometry(t fbTable, geometryType uint8) (orb.Geometry, error) {
+	if own := t.Uint8(6, fgbGeometryUnknown); own != fgbGeometryUnknown {
+		geometryType = own
+	}
+	xy := t.Float64s(1)
+	points := make([]orb.Point, len(xy)/2)
+	for i := range points {
+		points[i] = orb.Point{xy[2*i], xy[2*i+1]}
+	}
+	// splitParts splits the points at the given ends (i.e. exclusive point indexes)
+	splitParts := func() ([][]orb.Point, error) {
+		ends := t.Uint32s(0)
+		if len(ends) == 0 {
+			return [][]orb.Point{points}, nil
+		}
+		parts := make([][]orb.Point, len(ends))
+		start := 0
+		for i, end := range ends {
+			if int(end) < start || int(end) > len(points) {
+				return nil, fmt.Errorf("%w: invalid geometry ends", InvalidFlatGeobufErr)
+			}
+			parts[i] = points[start:end]
+			start = int(end)
+		}
+		return parts, nil
+	}
+
+	switch geometryType {
+	case fgbGeometryPoint:
+		if len(points) == 0 {
+			return nil, nil
+		}
+		return points[0], nil
+	case fgbGeometryMultiPoint:
+		return orb.MultiPoint(points), nil
+	case fgbGeometryLineString:
+		return orb.LineString(points), nil
+	case fgbGeometryMultiLineString:
+		parts, err := splitParts()
+		if err != nil {
+			return nil, err
+		}
+		mls := make(orb.MultiLineString, len(parts))
+		for i, part := range parts {
+			mls[i] = orb.LineString(part)
+		}
+		return mls, nil
+	case fgbGeometryPolygon:
+		parts, err := splitParts()
+		if err != nil {
+			return nil, err
+		}
+		polygon := make(orb.Polygon, len(parts))
+		for i, part := range parts {
+			polygon[i] = orb.Ring(part)
+		}
+		return polygon, nil
+	case fgbGeometryMultiPolygon:
+		parts := t.Tables(7)
+		mp := make(orb.MultiPolygon, 0, len(parts))
+		for _, part := range parts {
+			geom, err := decodeFlatGeobufGeometry(part, fgbGeometryPolygon)
+			if err != nil {
+				return nil, err
+			}
+			mp = append(mp, geom.(orb.Polygon))
+		}
+		return mp, nil
+	case fgbGeometryGeometryCollection:
+		parts := t.Tables(7)
+		collection := make(orb.Collection, 0, len(parts))
+		for _, part := range parts {
+			geom, err := decodeFlatGeobufGeometry(part, fgbGeometryUnknown)
+			if err != nil {
+				return nil, err
+			}
+			if geom != nil {
+				collection = append(collection, geom)
+			}
+		}
+		return collection, nil
+	default:
+		return nil, fmt.Errorf("Unsupported FlatGeobuf geometry type [%d]", geometryType)
+	}
+}
+
+// decodeFlatGeobufProperties decodes the properties of a feature, which are encoded as a
+// sequence of column indexes and values
+func decodeFlatGeobufProperties(b []byte, columns []flatgeobufColumn) (geojson.Properties, error) {
+	props := make(geojson.Properties)
+	le := binary.LittleEndian
+	for pos := 0; pos < len(b); {
+		if pos+2 > len(b) {
+			return nil, fmt.Errorf("%w: truncated properties", InvalidFlatGeobufErr)
+		}
+		i := int(le.Uint16(b[pos:]))
+		pos += 2
+		if i >= len(columns) {
+			return nil, fmt.Errorf("%w: invalid column index [%d]", InvalidFlatGeobufErr, i)
+		}
+		var size int
+		switch columns[i].Type {
+		case fgbColumnByte, fgbColumnUByte, fgbColumnBool:
+			size = 1
+		case fgbColumnShort, fgbColumnUShort:
+			size = 2
+		case fgbColumnInt, fgbColumnUInt, fgbColumnFloat:
+			size = 4
+		case fgbColumnLong, fgbColumnULong, fgbColumnDouble:
+			size = 8
+		case fgbColumnString, fgbColumnJSON, fgbColumnDateTime, fgbColumnBinary:
+			if pos+4 > len(b) {
+				return nil, fmt.Errorf("%w: truncated properties", InvalidFlatGeobufErr)
+			}
+			size = int(le.Uint32(b[pos:]))
+			pos += 4
+		default:
+			return nil, fmt.Errorf("Unsupported FlatGeobuf column type [%d]", columns[i].Type)
+		}
+		if size < 0 || pos+size > len(b) {
+			return nil, fmt.Errorf("%w: truncated properties", InvalidFlatGeobufErr)
+		}
+		v := b[pos : pos+size]
+		pos += size
+
+		var value interface{}
+		switch columns[i].Type {
+		case fgbColumnByte:
+			value = int64(int8(v[0]))
+		case fgbColumnUByte:
+			value = int64(v[0])
+		case fgbColumnBool:
+			value = v[0] != 0
+		case fgbColumnShort:
+			value = int64(int16(le.Uint16(v)))
+		case fgbColumnUShort:
+			value = int64(le.Uint16(v))
+		case fgbColumnInt:
+			value = int64(int32(le.Uint32(v)))
+		case fgbColumnUInt:
+			value = int64(le.Uint32(v))
+		case fgbColumnLong:
+			value = int64(le.Uint64(v))
+		case fgbColumnULong:
+			value = le.Uint64(v)
+		case fgbColumnFloat:
+			value = float64(math.Float32frombits(le.Uint32(v)))
+		case fgbColumnDouble:
+			value = math.Float64frombits(le.Uint64(v))
+		case fgbColumnString, fgbColumnJSON, fgbColumnDateTime:
+			value = string(v)
+		case fgbColumnBinary:
+			value = append([]byte{}, v...)
+		}
+		props[columns[i].Name] = value
+	}
+	return props, nil
+}
+
+// decodeFlatGeobufFeature decodes a size-prefixed Feature buffer
+func decodeFlatGeobufFeature(buf []byte, header *flatgeobufHeader) (feature *geojson.Feature, err error) {
+	defer fbRecover(&err)
+	t := fbRoot(buf)
+	var geom orb.Geometry
+	if g, ok := t.Table(0); ok {
+		if geom, err = decodeFlatGeobufGeometry(g, header.GeometryType); err != nil {
+			return nil, err
+		}
+	}
+	columns := header.Columns
+	if own := t.Tables(2); len(own) > 0 {
+		columns = readFlatGeobufColumns(own)
+	}
+	feature = geojson.NewFeature(geom)
+	if feature.Properties, err = decodeFlatGeobufProperties(t.Bytes(1), columns); err != nil {
+		return nil, err
+	}
+	return feature, nil
+}
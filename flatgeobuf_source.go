@@ -0,0 +1,233 @@
+package tilenol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+var (
+	// MissingFlatGeobufPathErr is the error returned when a FlatGeobuf source has no file path
+	MissingFlatGeobufPathErr = errors.New("FlatGeobuf source requires a \"path\"")
+	// UnsupportedFlatGeobufCRSErr is the error returned when a FlatGeobuf file does not use WGS84
+	// coordinates, which are required to query its index with tile bounds
+	UnsupportedFlatGeobufCRSErr = errors.New("FlatGeobuf source requires WGS84 coordinates")
+)
+
+// FlatGeobufConfig is the YAML configuration structure for configuring a new FlatGeobufSource
+type FlatGeobufConfig struct {
+	// Path is the path to the FlatGeobuf file
+	Path string `yaml:"path"`
+	// SourceFields is a mapping from the feature property name to the FlatGeobuf column name
+	SourceFields map[string]string `yaml:"sourceFields"`
+}
+
+// FlatGeobufSource is a Source implementation that retrieves feature data from a local
+// FlatGeobuf file, using its packed Hilbert R-tree index (if any) to read only the features
+// that intersect the requested tile
+type FlatGeobufSource struct {
+	// Path is the path to the FlatGeobuf file
+	Path string
+	// SourceFields is a mapping from the feature property name to the FlatGeobuf column name
+	SourceFields map[string]string
+
+	file           *os.File
+	fileSize       int64
+	header         *flatgeobufHeader
+	indexOffset    int64
+	featuresOffset int64
+	levelBounds    [][2]uint64
+}
+
+// NewFlatGeobufSource creates a new Source that retrieves feature data from a FlatGeobuf file
+func NewFlatGeobufSource(config *FlatGeobufConfig) (Source, error) {
+	if config.Path == "" {
+		return nil, MissingFlatGeobufPathErr
+	}
+	file, err := os.Open(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	header, indexOffset, err := readFlatGeobufHeader(file)
+	if err == nil {
+		err = checkFlatGeobufCRS(header)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to read FlatGeobuf file [%s]: %w", config.Path, err)
+	}
+	indexSize, levelBounds := flatgeobufIndexSize(header.FeaturesCount, header.IndexNodeSize)
+	return &FlatGeobufSource{
+		Path:           config.Path,
+		SourceFields:   config.SourceFields,
+		file:           file,
+		fileSize:       info.Size(),
+		header:         header,
+		indexOffset:    indexOffset,
+		featuresOffset: indexOffset + int64(indexSize),
+		levelBounds:    levelBounds,
+	}, nil
+}
+
+// checkFlatGeobufCRS ensures that the coordinates of a FlatGeobuf file are WGS84 longitudes and
+// latitudes (where a missing CRS is assumed to be WGS84)
+func checkFlatGeobufCRS(header *flatgeobufHeader) error {
+	if header.CRSWKT != "" {
+		proj, err := wgs84ProjectionFromWKT(header.CRSWKT)
+		if err != nil || proj != nil {
+			return UnsupportedFlatGeobufCRSErr
+		}
+		return nil
+	}
+	if header.CRSCode != 0 && header.CRSCode != 4326 {
+		return fmt.Errorf("%w: EPSG:%d", UnsupportedFlatGeobufCRSErr, header.CRSCode)
+	}
+	return nil
+}
+
+// Close closes the underlying FlatGeobuf file
+func (f *FlatGeobufSource) Close() error {
+	return f.file.Close()
+}
+
+// Fields implements the FieldsSource interface
+func (f *FlatGeobufSource) Fields() []string {
+	return sourceFieldNames(f.SourceFields)
+}
+
+// searchIndex traverses the packed Hilbert R-tree index, returning the offsets (relative to the
+// start of the features) of the features whose bounds intersect the given bounds
+func (f *FlatGeobufSource) searchIndex(ctx context.Context, bound orb.Bound) ([]int64, error) {
+	nodeSize := uint64(f.header.IndexNodeSize)
+	leavesStart := f.levelBounds[0][0]
+	type queueItem struct {
+		node  uint64
+		level int
+	}
+	var offsets []int64
+	queue := []queueItem{{0, len(f.levelBounds) - 1}}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		item := queue[0]
+		queue = queue[1:]
+		end := min(item.node+nodeSize, f.levelBounds[item.level][1])
+		buf := make([]byte, (end-item.node)*flatgeobufNodeItemSize)
+		if _, err := f.file.ReadAt(buf, f.indexOffset+int64(item.node*flatgeobufNodeItemSize)); err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(buf); i += flatgeobufNodeItemSize {
+			node := buf[i : i+flatgeobufNodeItemSize]
+			nodeBound := orb.Bound{
+				Min: orb.Point{
+					math.Float64frombits(binary.LittleEndian.Uint64(node[0:])),
+					math.Float64frombits(binary.LittleEndian.Uint64(node[8:])),
+				},
+				Max: orb.Point{
+					math.Float64frombits(binary.LittleEndian.Uint64(node[16:])),
+					math.Float64frombits(binary.LittleEndian.Uint64(node[24:])),
+				},
+			}
+			if !nodeBound.Intersects(bound) {
+				continue
+			}
+			offset := binary.LittleEndian.Uint64(node[32:])
+			if item.node >= leavesStart {
+				offsets = append(offsets, int64(offset))
+			} else {
+				queue = append(queue, queueItem{offset, item.level - 1})
+			}
+		}
+	}
+	return offsets, nil
+}
+
+// readFeature reads the size-prefixed feature at the given absolute offset, returning the
+// feature and its size (including the prefix). Note that io.EOF is only returned at the end of
+// the file, while truncated features (or corrupted size prefixes) are reported as an
+// io.ErrUnexpectedEOF.
+func (f *FlatGeobufSource) readFeature(offset int64) (*geojson.Feature, int64, error) {
+	if offset == f.fileSize {
+		return nil, 0, io.EOF
+	}
+	truncatedErr := fmt.Errorf("%w: truncated feature at offset %d: %w", InvalidFlatGeobufErr, offset, io.ErrUnexpectedEOF)
+	if offset+4 > f.fileSize {
+		return nil, 0, truncatedErr
+	}
+	prefix := make([]byte, 4)
+	if _, err := f.file.ReadAt(prefix, offset); err == io.EOF {
+		return nil, 0, truncatedErr
+	} else if err != nil {
+		return nil, 0, err
+	}
+	size := int64(binary.LittleEndian.Uint32(prefix))
+	if size > f.fileSize-(offset+4) {
+		return nil, 0, truncatedErr
+	}
+	buf := make([]byte, size)
+	if _, err := f.file.ReadAt(buf, offset+4); err == io.EOF {
+		return nil, 0, truncatedErr
+	} else if err != nil {
+		return nil, 0, err
+	}
+	feature, err := decodeFlatGeobufFeature(buf, f.header)
+	return feature, 4 + size, err
+}
+
+// GetFeatures implements the Source interface, reading the features that intersect the
+// requested tile. Note that files without an index are scanned in full.
+func (f *FlatGeobufSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	fields, err := requestSourceFields(f.SourceFields, req)
+	if err != nil {
+		return nil, err
+	}
+	bound := req.MapTile().Bound()
+	fc := geojson.NewFeatureCollection()
+
+	if f.levelBounds == nil {
+		for offset := f.featuresOffset; ; {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			feature, size, err := f.readFeature(offset)
+			if err == io.EOF {
+				return fc, nil
+			} else if err != nil {
+				return nil, err
+			}
+			offset += size
+			if feature.Geometry != nil && feature.Geometry.Bound().Intersects(bound) {
+				fc.Append(mapFeature(feature, fields))
+			}
+		}
+	}
+
+	offsets, err := f.searchIndex(ctx, bound)
+	if err != nil {
+		return nil, err
+	}
+	for _, offset := range offsets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		feature, _, err := f.readFeature(f.featuresOffset + offset)
+		if err != nil {
+			return nil, err
+		}
+		fc.Append(mapFeature(feature, fields))
+	}
+	return fc, nil
+}
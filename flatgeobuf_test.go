@@ -0,0 +1,351 @@
+package tilenol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/assert"
+)
+
+// fbFields is a table of a test FlatBuffers buffer, indexed by field ID. Fields are either nil
+// (absent), scalars, strings, scalar vectors, tables or vectors of tables.
+type fbFields []interface{}
+
+// fbBuilder is a minimal FlatBuffers encoder for tests, which writes every object after the
+// object that references it
+type fbBuilder struct {
+	buf bytes.Buffer
+}
+
+// encodeFB encodes a FlatBuffers buffer with the given root table
+func encodeFB(root fbFields) []byte {
+	b := &fbBuilder{}
+	b.buf.Write(make([]byte, 4))
+	pos := b.writeTable(root)
+	data := b.buf.Bytes()
+	binary.LittleEndian.PutUint32(data, uint32(pos))
+	return data
+}
+
+// patch writes the offset from pos to target at pos
+func (b *fbBuilder) patch(pos, target int) {
+	binary.LittleEndian.PutUint32(b.buf.Bytes()[pos:], uint32(target-pos))
+}
+
+// writeTable writes a vtable followed by its table and the objects referenced by the table
+func (b *fbBuilder) writeTable(fields fbFields) int {
+	var content bytes.Buffer
+	offsets := make([]uint16, len(fields))
+	refs := map[int]interface{}{}
+	for i, field := range fields {
+		if field == nil {
+			continue
+		}
+		offsets[i] = uint16(4 + content.Len())
+		switch v := field.(type) {
+		case uint8, bool, uint16, int32, uint64:
+			binary.Write(&content, binary.LittleEndian, v)
+		default:
+			refs[4+content.Len()] = v
+			content.Write(make([]byte, 4))
+		}
+	}
+	vtable := b.buf.Len()
+	binary.Write(&b.buf, binary.LittleEndian, uint16(4+2*len(fields)))
+	binary.Write(&b.buf, binary.LittleEndian, uint16(4+content.Len()))
+	binary.Write(&b.buf, binary.LittleEndian, offsets)
+	table := b.buf.Len()
+	binary.Write(&b.buf, binary.LittleEndian, int32(table-vtable))
+	b.buf.Write(content.Bytes())
+
+	positions := make([]int, 0, len(refs))
+	for pos := range refs {
+		positions = append(positions, pos)
+	}
+	sort.Ints(positions)
+	for _, pos := range positions {
+		b.patch(table+pos, b.writeObject(refs[pos]))
+	}
+	return table
+}
+
+// writeObject writes a string, vector or table
+func (b *fbBuilder) writeObject(obj interface{}) int {
+	pos := b.buf.Len()
+	switch v := obj.(type) {
+	case string:
+		binary.Write(&b.buf, binary.LittleEndian, uint32(len(v)))
+		b.buf.WriteString(v)
+		b.buf.WriteByte(0)
+	case []byte:
+		binary.Write(&b.buf, binary.LittleEndian, uint32(len(v)))
+		b.buf.Write(v)
+	case []float64:
+		binary.Write(&b.buf, binary.LittleEndian, uint32(len(v)))
+		binary.Write(&b.buf, binary.LittleEndian, v)
+	case []uint32:
+		binary.Write(&b.buf, binary.LittleEndian, uint32(len(v)))
+		binary.Write(&b.buf, binary.LittleEndian, v)
+	case fbFields:
+		return b.writeTable(v)
+	case []fbFields:
+		binary.Write(&b.buf, binary.LittleEndian, uint32(len(v)))
+		b.buf.Write(make([]byte, 4*len(v)))
+		for i, table := range v {
+			b.patch(pos+4+4*i, b.writeTable(table))
+		}
+	default:
+		panic("unsupported FlatBuffers test object")
+	}
+	return pos
+}
+
+// encodeTestFlatGeobuf encodes a FlatGeobuf file with point features, along with "name" and
+// "rank" columns, and a packed R-tree index using the given node size (or no index if zero)
+func encodeTestFlatGeobuf(crs fbFields, nodeSize uint16, points []orb.Point, names []string) []byte {
+	var out bytes.Buffer
+	out.Write([]byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0})
+	header := encodeFB(fbFields{
+		"points", nil, uint8(fgbGeometryPoint), nil, nil, nil, nil,
+		[]fbFields{{"name", uint8(fgbColumnString)}, {"rank", uint8(fgbColumnInt)}},
+		uint64(len(points)), nodeSize, crs,
+	})
+	binary.Write(&out, binary.LittleEndian, uint32(len(header)))
+	out.Write(header)
+
+	var features bytes.Buffer
+	offsets := make([]uint64, len(points))
+	for i, point := range points {
+		var props bytes.Buffer
+		binary.Write(&props, binary.LittleEndian, uint16(0))
+		binary.Write(&props, binary.LittleEndian, uint32(len(names[i])))
+		props.WriteString(names[i])
+		binary.Write(&props, binary.LittleEndian, uint16(1))
+		binary.Write(&props, binary.LittleEndian, int32(-i))
+		feature := encodeFB(fbFields{
+			fbFields{nil, []float64{point[0], point[1]}},
+			props.Bytes(),
+		})
+		offsets[i] = uint64(features.Len())
+		binary.Write(&features, binary.LittleEndian, uint32(len(feature)))
+		features.Write(feature)
+	}
+
+	indexSize, levelBounds := flatgeobufIndexSize(uint64(len(points)), nodeSize)
+	if indexSize > 0 {
+		bounds := make([]orb.Bound, indexSize/flatgeobufNodeItemSize)
+		nodeOffsets := make([]uint64, len(bounds))
+		for i, point := range points {
+			bounds[levelBounds[0][0]+uint64(i)] = point.Bound()
+			nodeOffsets[levelBounds[0][0]+uint64(i)] = offsets[i]
+		}
+		for level := 1; level < len(levelBounds); level++ {
+			children := levelBounds[level-1]
+			for node := levelBounds[level][0]; node < levelBounds[level][1]; node++ {
+				first := children[0] + (node-levelBounds[level][0])*uint64(nodeSize)
+				nodeOffsets[node] = first
+				bounds[node] = bounds[first]
+				for child := first; child < min(first+uint64(nodeSize), children[1]); child++ {
+					bounds[node] = bounds[node].Union(bounds[child])
+				}
+			}
+		}
+		for i, bound := range bounds {
+			binary.Write(&out, binary.LittleEndian, []float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]})
+			binary.Write(&out, binary.LittleEndian, nodeOffsets[i])
+		}
+	}
+	out.Write(features.Bytes())
+	return out.Bytes()
+}
+
+// testCities are the point features of the test FlatGeobuf files
+var testCities = map[string]orb.Point{
+	"San Francisco": {-122.4, 37.8},
+	"Los Angeles":   {-118.2, 34.1},
+	"Seattle":       {-122.3, 47.6},
+	"New York":      {-73.9, 40.7},
+	"London":        {-0.1, 51.5},
+	"Tokyo":         {139.7, 35.7},
+	"Sydney":        {151.2, -33.9},
+}
+
+// writeTestFlatGeobuf writes a FlatGeobuf file of the test cities
+func writeTestFlatGeobuf(t *testing.T, crs fbFields, nodeSize uint16) string {
+	var names []string
+	for name := range testCities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var points []orb.Point
+	for _, name := range names {
+		points = append(points, testCities[name])
+	}
+	path := filepath.Join(t.TempDir(), "cities.fgb")
+	if err := os.WriteFile(path, encodeTestFlatGeobuf(crs, nodeSize, points, names), 0644); err != nil {
+		t.Fatalf("Could not write test file: %v", err)
+	}
+	return path
+}
+
+// featureNames returns the sorted "name" properties of the given features
+func featureNames(fc *geojson.FeatureCollection) []string {
+	var names []string
+	for _, f := range fc.Features {
+		names = append(names, f.Properties["name"].(string))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFlatGeobufSource(t *testing.T) {
+	for _, nodeSize := range []uint16{0, 2, 16} {
+		path := writeTestFlatGeobuf(t, fbFields{"EPSG", int32(4326)}, nodeSize)
+		source, err := NewFlatGeobufSource(&FlatGeobufConfig{
+			Path:         path,
+			SourceFields: map[string]string{"name": "name", "rank": "rank"},
+		})
+		if !assert.Nil(t, err) {
+			continue
+		}
+		assert.Equal(t, "flatgeobuf", Layer{source: source}.SourceType())
+
+		// Tile (1, 3, 3) covers the south-western United States
+		fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 1, Y: 3, Z: 3})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Los Angeles", "San Francisco"}, featureNames(fc), nodeSize)
+		for _, f := range fc.Features {
+			assert.Equal(t, testCities[f.Properties["name"].(string)], f.Geometry)
+			assert.IsType(t, int64(0), f.Properties["rank"])
+		}
+
+		fc, err = source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+		assert.Nil(t, err)
+		assert.Len(t, fc.Features, len(testCities))
+
+		fc, err = source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 2})
+		assert.Nil(t, err)
+		assert.Empty(t, fc.Features)
+		assert.Nil(t, source.(*FlatGeobufSource).Close())
+	}
+}
+
+func TestFlatGeobufSourceInvalid(t *testing.T) {
+	_, err := NewFlatGeobufSource(&FlatGeobufConfig{})
+	assert.Equal(t, MissingFlatGeobufPathErr, err)
+
+	path := writeTestFlatGeobuf(t, fbFields{"EPSG", int32(3857)}, 16)
+	_, err = NewFlatGeobufSource(&FlatGeobufConfig{Path: path})
+	assert.ErrorIs(t, err, UnsupportedFlatGeobufCRSErr)
+
+	path = writeTestFile(t, "invalid.fgb", "fgb\x03fgb\x00\xff\xff\xff\xff")
+	_, err = NewFlatGeobufSource(&FlatGeobufConfig{Path: path})
+	assert.ErrorIs(t, err, InvalidFlatGeobufErr)
+
+	// Corrupted headers are reported as errors rather than panics
+	path = writeTestFile(t, "corrupted.fgb", "fgb\x03fgb\x00\x08\x00\x00\x00\xff\xff\xff\x7f\x00\x00\x00\x00")
+	_, err = NewFlatGeobufSource(&FlatGeobufConfig{Path: path})
+	assert.ErrorIs(t, err, InvalidFlatGeobufErr)
+}
+
+func TestFlatGeobufSourceTruncated(t *testing.T) {
+	for _, nodeSize := range []uint16{0, 16} {
+		path := writeTestFlatGeobuf(t, fbFields{"EPSG", int32(4326)}, nodeSize)
+		data, err := os.ReadFile(path)
+		assert.Nil(t, err)
+
+		// A truncated last feature is an error rather than the end of the features
+		assert.Nil(t, os.WriteFile(path, data[:len(data)-1], 0644))
+		source, err := NewFlatGeobufSource(&FlatGeobufConfig{Path: path})
+		if !assert.Nil(t, err) {
+			continue
+		}
+		_, err = source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF, nodeSize)
+		assert.Nil(t, source.(*FlatGeobufSource).Close())
+
+		// Size prefixes beyond the end of the file are rejected before allocating the feature
+		fgb := source.(*FlatGeobufSource)
+		binary.LittleEndian.PutUint32(data[fgb.featuresOffset:], math.MaxUint32)
+		assert.Nil(t, os.WriteFile(path, data, 0644))
+		source, err = NewFlatGeobufSource(&FlatGeobufConfig{Path: path})
+		if !assert.Nil(t, err) {
+			continue
+		}
+		_, err = source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF, nodeSize)
+		assert.Nil(t, source.(*FlatGeobufSource).Close())
+	}
+}
+
+func TestDecodeFlatGeobufGeometry(t *testing.T) {
+	decode := func(fields fbFields, geometryType uint8) orb.Geometry {
+		geom, err := decodeFlatGeobufGeometry(fbRoot(encodeFB(fields)), geometryType)
+		assert.Nil(t, err)
+		return geom
+	}
+	square := []float64{0, 0, 1, 0, 1, 1, 0, 1, 0, 0}
+	assert.Equal(t, orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}},
+		decode(fbFields{nil, square}, fgbGeometryPolygon))
+	assert.Equal(t, orb.MultiLineString{{{0, 0}, {1, 0}}, {{1, 1}, {0, 1}, {0, 0}}},
+		decode(fbFields{[]uint32{2, 5}, square}, fgbGeometryMultiLineString))
+	assert.Equal(t, orb.MultiPolygon{{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}, {{{2, 2}, {3, 3}, {2, 2}}}},
+		decode(fbFields{nil, nil, nil, nil, nil, nil, uint8(fgbGeometryMultiPolygon), []fbFields{
+			{nil, square},
+			{nil, []float64{2, 2, 3, 3, 2, 2}},
+		}}, fgbGeometryUnknown))
+	assert.Equal(t, orb.Collection{orb.Point{1, 2}, orb.LineString{{0, 0}, {1, 1}}},
+		decode(fbFields{nil, nil, nil, nil, nil, nil, uint8(fgbGeometryGeometryCollection), []fbFields{
+			{nil, []float64{1, 2}, nil, nil, nil, nil, uint8(fgbGeometryPoint)},
+			{nil, []float64{0, 0, 1, 1}, nil, nil, nil, nil, uint8(fgbGeometryLineString)},
+		}}, fgbGeometryUnknown))
+
+	_, err := decodeFlatGeobufGeometry(fbRoot(encodeFB(fbFields{[]uint32{7}, square})), fgbGeometryPolygon)
+	assert.ErrorIs(t, err, InvalidFlatGeobufErr)
+}
+
+func TestDecodeFlatGeobufProperties(t *testing.T) {
+	columns := []flatgeobufColumn{
+		{Name: "flag", Type: fgbColumnBool},
+		{Name: "short", Type: fgbColumnShort},
+		{Name: "float", Type: fgbColumnFloat},
+		{Name: "double", Type: fgbColumnDouble},
+		{Name: "json", Type: fgbColumnJSON},
+	}
+	var buf bytes.Buffer
+	le := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	le(uint16(0))
+	le(uint8(1))
+	le(uint16(1))
+	le(int16(-2))
+	le(uint16(2))
+	le(float32(1.5))
+	le(uint16(3))
+	le(math.Pi)
+	le(uint16(4))
+	le(uint32(2))
+	buf.WriteString("{}")
+	props, err := decodeFlatGeobufProperties(buf.Bytes(), columns)
+	assert.Nil(t, err)
+	assert.Equal(t, geojson.Properties{
+		"flag":   true,
+		"short":  int64(-2),
+		"float":  1.5,
+		"double": math.Pi,
+		"json":   "{}",
+	}, props)
+
+	_, err = decodeFlatGeobufProperties(buf.Bytes()[:buf.Len()-1], columns)
+	assert.ErrorIs(t, err, InvalidFlatGeobufErr)
+	_, err = decodeFlatGeobufProperties([]byte{9, 0}, columns)
+	assert.ErrorIs(t, err, InvalidFlatGeobufErr)
+}
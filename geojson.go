@@ -0,0 +1,59 @@
+package tilenol
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/paulmach/orb/geojson"
+)
+
+// getGeoJSON computes a GeoJSON response for the incoming request. Unlike vector tiles, the
+// features are returned in WGS84 without any projection or clipping to the tile extent. For
+// requests for a single layer, a single FeatureCollection is returned, otherwise the
+// response is an object mapping each layer name to its FeatureCollection.
+func (s *Server) getGeoJSON(w http.ResponseWriter, r *http.Request) {
+	req, layersToCompute, err := s.parseTileRequest(r)
+	if err != nil {
+		s.handleError(err, w, r)
+		return
+	}
+
+	fcs := make([]*geojson.FeatureCollection, len(layersToCompute))
+	err = eachLayer(r.Context(), layersToCompute, func(ctx context.Context, i int, layer Layer) error {
+		Logger.Debugf("Retrieving GeoJSON for [%s] @ (%d, %d, %d)", layer, req.Z, req.X, req.Y)
+
+		fc, err := layer.GetFeatures(ctx, req)
+		if err != nil {
+			return err
+		}
+		fc.Features = filterEmptyGeometries(fc.Features)
+		fcs[i] = fc
+		return nil
+	})
+	if err != nil {
+		s.handleError(err, w, r)
+		return
+	}
+
+	var body interface{}
+	requestedLayers := chi.URLParam(r, "layers")
+	if requestedLayers != AllLayers && !strings.Contains(requestedLayers, ",") {
+		// Note: the layer may have been filtered out by zoom, in which case it has no features
+		fc := geojson.NewFeatureCollection()
+		if len(fcs) > 0 {
+			fc = fcs[0]
+		}
+		body = fc
+	} else {
+		keyed := make(map[string]*geojson.FeatureCollection, len(fcs))
+		for i, layer := range layersToCompute {
+			keyed[layer.Name] = fcs[i]
+		}
+		body = keyed
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	s.writeJSON(w, r, body)
+}
@@ -0,0 +1,167 @@
+package tilenol
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+var (
+	// MissingGeoJSONPathErr is the error returned when a GeoJSON source has no file path
+	MissingGeoJSONPathErr = errors.New("GeoJSON source requires a \"path\"")
+	// InvalidGeoJSONErr is the error returned when a file is neither a GeoJSON FeatureCollection
+	// nor newline-delimited GeoJSON Features
+	InvalidGeoJSONErr = errors.New("File is not a GeoJSON FeatureCollection or newline-delimited GeoJSON")
+)
+
+// GeoJSONConfig is the YAML configuration structure for configuring a new GeoJSONSource
+type GeoJSONConfig struct {
+	// Path is the path to the GeoJSON FeatureCollection or newline-delimited GeoJSON file
+	Path string `yaml:"path"`
+	// SourceFields is a mapping from the feature property name to the source feature
+	// property name
+	SourceFields map[string]string `yaml:"sourceFields"`
+	// ReloadInterval is how often the file is checked for changes, where zero disables
+	// reloading (optional)
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+// GeoJSONSource is a Source implementation that retrieves feature data from a GeoJSON file,
+// which is loaded into an in-memory spatial index
+type GeoJSONSource struct {
+	// Path is the path to the GeoJSON file
+	Path string
+	// SourceFields is a mapping from the feature property name to the source feature
+	// property name
+	SourceFields map[string]string
+
+	mu      sync.RWMutex
+	index   *featureIndex
+	watcher *fileWatcher
+}
+
+// NewGeoJSONSource creates a new Source that retrieves feature data from a GeoJSON file
+func NewGeoJSONSource(config *GeoJSONConfig) (Source, error) {
+	if config.Path == "" {
+		return nil, MissingGeoJSONPathErr
+	}
+	// Note: the file is stat'ed before loading, so that changes made while loading are reloaded
+	info, err := os.Stat(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	g := &GeoJSONSource{
+		Path:         config.Path,
+		SourceFields: config.SourceFields,
+	}
+	if err := g.load(); err != nil {
+		return nil, err
+	}
+	if config.ReloadInterval > 0 {
+		g.watcher = watchFile(config.Path, info, config.ReloadInterval, g.load)
+	}
+	return g, nil
+}
+
+// load reads the GeoJSON file and replaces the spatial index
+func (g *GeoJSONSource) load() error {
+	f, err := os.Open(g.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	features, err := readGeoJSONFeatures(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("Failed to read GeoJSON file [%s]: %w", g.Path, err)
+	}
+	index := newFeatureIndex(features)
+	Logger.Debugf("Loaded %d features from GeoJSON file [%s]", index.Len(), g.Path)
+
+	g.mu.Lock()
+	g.index = index
+	g.mu.Unlock()
+	return nil
+}
+
+// readGeoJSONFeatures reads the features of either a single FeatureCollection, or a sequence
+// of Features (e.g. newline-delimited GeoJSON)
+func readGeoJSONFeatures(r io.Reader) ([]*geojson.Feature, error) {
+	dec := json.NewDecoder(r)
+	var features []*geojson.Feature
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var object struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return nil, err
+		}
+		switch object.Type {
+		case "FeatureCollection":
+			if len(features) > 0 {
+				return nil, InvalidGeoJSONErr
+			}
+			fc, err := geojson.UnmarshalFeatureCollection(raw)
+			if err != nil {
+				return nil, err
+			}
+			features = fc.Features
+			// Note: anything after the FeatureCollection is invalid
+			if dec.More() {
+				return nil, InvalidGeoJSONErr
+			}
+		case "Feature":
+			feature, err := geojson.UnmarshalFeature(raw)
+			if err != nil {
+				return nil, err
+			}
+			features = append(features, feature)
+		default:
+			return nil, fmt.Errorf("%w: unexpected object type [%s]", InvalidGeoJSONErr, object.Type)
+		}
+	}
+	return features, nil
+}
+
+// Close stops watching the GeoJSON file for changes
+func (g *GeoJSONSource) Close() error {
+	if g.watcher == nil {
+		return nil
+	}
+	return g.watcher.Close()
+}
+
+// Generation implements the VersionedSource interface, changing whenever the file is reloaded
+func (g *GeoJSONSource) Generation() uint64 {
+	if g.watcher == nil {
+		return 0
+	}
+	return g.watcher.Generation()
+}
+
+// Fields implements the FieldsSource interface
+func (g *GeoJSONSource) Fields() []string {
+	return sourceFieldNames(g.SourceFields)
+}
+
+// GetFeatures implements the Source interface, retrieving the features that intersect the
+// requested tile from the spatial index
+func (g *GeoJSONSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	g.mu.RLock()
+	index := g.index
+	g.mu.RUnlock()
+	return searchFeatures(index, g.SourceFields, req)
+}
@@ -0,0 +1,133 @@
+package tilenol
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFeatureCollection = `{
+  "type": "FeatureCollection",
+  "features": [
+    {"type": "Feature", "id": 1, "geometry": {"type": "Point", "coordinates": [-122.4, 37.8]}, "properties": {"NAME": "San Francisco", "pop": 808437}},
+    {"type": "Feature", "id": 2, "geometry": {"type": "Point", "coordinates": [-73.9, 40.7]}, "properties": {"NAME": "New York", "pop": 8258035}},
+    {"type": "Feature", "id": 3, "geometry": {"type": "Polygon", "coordinates": [[[-123, 37], [-122, 37], [-122, 38], [-123, 38], [-123, 37]]]}, "properties": {"NAME": "Bay Area"}}
+  ]
+}`
+
+const testNDJSON = `{"type": "Feature", "id": 1, "geometry": {"type": "Point", "coordinates": [-122.4, 37.8]}, "properties": {"NAME": "San Francisco"}}
+
+{"type": "Feature", "id": 2, "geometry": {"type": "Point", "coordinates": [-73.9, 40.7]}, "properties": {"NAME": "New York"}}
+`
+
+// writeTestFile writes a temporary file with the given contents, returning its path
+func writeTestFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Could not write test file: %v", err)
+	}
+	return path
+}
+
+func TestGeoJSONSource(t *testing.T) {
+	path := writeTestFile(t, "cities.geojson", testFeatureCollection)
+	source, err := NewGeoJSONSource(&GeoJSONConfig{
+		Path:         path,
+		SourceFields: map[string]string{"name": "NAME"},
+	})
+	assert.Nil(t, err)
+	defer source.(*GeoJSONSource).Close()
+	assert.Equal(t, "geojson", Layer{source: source}.SourceType())
+	assert.Equal(t, []string{"name"}, source.(*GeoJSONSource).Fields())
+
+	// Tile (1, 3, 3) covers the western United States
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 1, Y: 3, Z: 3})
+	assert.Nil(t, err)
+	assert.Len(t, fc.Features, 2)
+	names := map[interface{}]interface{}{}
+	for _, f := range fc.Features {
+		names[f.ID] = f.Properties["name"]
+		assert.NotContains(t, f.Properties, "pop")
+	}
+	assert.Equal(t, map[interface{}]interface{}{1.0: "San Francisco", 3.0: "Bay Area"}, names)
+
+	// Extra source fields can be requested
+	fc, err = source.GetFeatures(context.Background(), &TileRequest{
+		X: 2, Y: 3, Z: 3,
+		Args: map[string][]string{"s": {"population:pop"}},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, fc.Features, 1)
+	assert.Equal(t, "New York", fc.Features[0].Properties["name"])
+	assert.Equal(t, 8258035.0, fc.Features[0].Properties["population"])
+
+	_, err = source.GetFeatures(context.Background(), &TileRequest{
+		X: 2, Y: 3, Z: 3,
+		Args: map[string][]string{"s": {"invalid"}},
+	})
+	assert.IsType(t, InvalidRequestError{}, err)
+
+	fc, err = source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 3})
+	assert.Nil(t, err)
+	assert.Empty(t, fc.Features)
+}
+
+func TestGeoJSONSourceNDJSON(t *testing.T) {
+	path := writeTestFile(t, "cities.ndjson", testNDJSON)
+	source, err := NewGeoJSONSource(&GeoJSONConfig{
+		Path:         path,
+		SourceFields: map[string]string{"name": "NAME"},
+	})
+	assert.Nil(t, err)
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+	assert.Nil(t, err)
+	assert.Len(t, fc.Features, 2)
+}
+
+func TestGeoJSONSourceReload(t *testing.T) {
+	path := writeTestFile(t, "cities.ndjson", testNDJSON)
+	// Note: changes are checked for explicitly rather than waiting on the polling interval
+	source, err := NewGeoJSONSource(&GeoJSONConfig{Path: path, ReloadInterval: time.Hour})
+	assert.Nil(t, err)
+	defer source.(*GeoJSONSource).Close()
+	watcher := source.(*GeoJSONSource).watcher
+	layer := Layer{Name: "cities", source: source}
+	version := layer.Hash()
+
+	countFeatures := func() int {
+		fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+		assert.Nil(t, err)
+		return len(fc.Features)
+	}
+	assert.Equal(t, 2, countFeatures())
+
+	// Invalid contents are not loaded
+	assert.Nil(t, os.WriteFile(path, []byte(`{"type": "Feature"`), 0644))
+	watcher.check()
+	assert.Equal(t, 2, countFeatures())
+	assert.Equal(t, version, layer.Hash(), "Failed reloads should not change the layer version")
+
+	assert.Nil(t, os.WriteFile(path, []byte(testFeatureCollection), 0644))
+	watcher.check()
+	assert.Equal(t, 3, countFeatures())
+	assert.NotEqual(t, version, layer.Hash(), "Reloads should change the layer version, so that cached tiles are not served")
+}
+
+func TestGeoJSONSourceInvalidFile(t *testing.T) {
+	_, err := NewGeoJSONSource(&GeoJSONConfig{})
+	assert.Equal(t, MissingGeoJSONPathErr, err)
+
+	for _, contents := range []string{
+		`{"type": "Point", "coordinates": [0, 0]}`,
+		testFeatureCollection + testNDJSON,
+		testNDJSON + testFeatureCollection,
+		`not json`,
+	} {
+		_, err := NewGeoJSONSource(&GeoJSONConfig{Path: writeTestFile(t, "invalid.geojson", contents)})
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,69 @@
+package tilenol
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/assert"
+)
+
+type pointSource struct {
+	point orb.Point
+}
+
+func (p *pointSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	fc := geojson.NewFeatureCollection()
+	f := geojson.NewFeature(p.point)
+	f.Properties["name"] = "somewhere"
+	fc.Append(f)
+	fc.Append(&geojson.Feature{Type: "Feature", Properties: geojson.Properties{}})
+	return fc, nil
+}
+
+func TestGeoJSONSingleLayer(t *testing.T) {
+	layers := []Layer{
+		Layer{Name: "points", source: &pointSource{orb.Point{-122.4, 37.8}}},
+	}
+	server := &Server{Layers: layers, Cache: &NilCache{}}
+	api, _ := server.setupRoutes()
+
+	r := httptest.NewRequest("GET", "/points/0/0/0.geojson", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	res := w.Result()
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "application/geo+json", res.Header.Get("Content-Type"))
+
+	var fc geojson.FeatureCollection
+	err := json.NewDecoder(res.Body).Decode(&fc)
+	assert.Nil(t, err)
+	assert.Len(t, fc.Features, 1, "Features with empty geometries should be removed")
+	assert.Equal(t, orb.Point{-122.4, 37.8}, fc.Features[0].Geometry)
+	assert.Equal(t, "somewhere", fc.Features[0].Properties["name"])
+}
+
+func TestGeoJSONMultipleLayers(t *testing.T) {
+	layers := []Layer{
+		Layer{Name: "a", source: &pointSource{orb.Point{1, 1}}},
+		Layer{Name: "b", source: &NilSource{}},
+	}
+	server := &Server{Layers: layers, Cache: &NilCache{}}
+	api, _ := server.setupRoutes()
+
+	r := httptest.NewRequest("GET", "/_all/0/0/0.geojson", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	res := w.Result()
+	assert.Equal(t, 200, res.StatusCode)
+
+	var keyed map[string]*geojson.FeatureCollection
+	err := json.NewDecoder(res.Body).Decode(&keyed)
+	assert.Nil(t, err)
+	assert.Len(t, keyed, 2)
+	assert.Len(t, keyed["a"].Features, 1)
+	assert.Len(t, keyed["b"].Features, 0)
+}
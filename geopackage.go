@@ -0,0 +1,242 @@
+package tilenol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/paulmach/orb"
+)
+
+const (
+	// geoPackageFlagLittleEndian is the header flag bit for little-endian header fields
+	geoPackageFlagLittleEndian = 0x01
+	// geoPackageFlagEmpty is the header flag bit for empty geometries
+	geoPackageFlagEmpty = 0x10
+	// geoPackageFlagExtended is the header flag bit for extended (non-standard) geometry types
+	geoPackageFlagExtended = 0x20
+)
+
+// wkbMaxDepth limits the nesting of geometry collections in WKB data
+const wkbMaxDepth = 32
+
+var (
+	// InvalidGeoPackageGeometryErr is the error returned for malformed GeoPackage geometry blobs
+	InvalidGeoPackageGeometryErr = errors.New("Invalid GeoPackage geometry")
+	// InvalidWKBErr is the error returned for malformed WKB geometries
+	InvalidWKBErr = errors.New("Invalid WKB geometry")
+)
+
+// geoPackageEnvelopeSizes are the sizes of the header envelope for each envelope indicator
+var geoPackageEnvelopeSizes = []int{0, 32, 48, 48, 64}
+
+// decodeGeoPackageGeometry decodes a GeoPackage geometry blob (a small header followed by the
+// WKB geometry), returning the geometry and the SRS ID of its coordinates. Note that empty
+// geometries are returned as nil.
+func decodeGeoPackageGeometry(data []byte) (orb.Geometry, int32, error) {
+	if len(data) < 8 || data[0] != 'G' || data[1] != 'P' {
+		return nil, 0, InvalidGeoPackageGeometryErr
+	}
+	flags := data[3]
+	if flags&geoPackageFlagExtended != 0 {
+		return nil, 0, fmt.Errorf("%w: extended geometry types are not supported", InvalidGeoPackageGeometryErr)
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if flags&geoPackageFlagLittleEndian != 0 {
+		order = binary.LittleEndian
+	}
+	srsID := int32(order.Uint32(data[4:]))
+	envelope := int(flags>>1) & 0x07
+	if envelope >= len(geoPackageEnvelopeSizes) {
+		return nil, 0, fmt.Errorf("%w: envelope indicator %d", InvalidGeoPackageGeometryErr, envelope)
+	}
+	offset := 8 + geoPackageEnvelopeSizes[envelope]
+	if flags&geoPackageFlagEmpty != 0 {
+		return nil, srsID, nil
+	}
+	if len(data) < offset {
+		return nil, 0, InvalidGeoPackageGeometryErr
+	}
+	geom, err := decodeWKB(data[offset:])
+	return geom, srsID, err
+}
+
+// decodeWKB decodes a WKB geometry, accepting the ISO and extended (PostGIS) encodings of Z, M
+// and ZM coordinates, where any extra dimensions are dropped
+func decodeWKB(data []byte) (geom orb.Geometry, err error) {
+	r := &wkbReader{data: data}
+	defer func() {
+		if rec := recover(); rec != nil {
+			geom, err = nil, InvalidWKBErr
+		}
+	}()
+	return r.geometry(0)
+}
+
+// wkbReader is a cursor over WKB data. Reads past the end of the data panic, and are recovered
+// by decodeWKB.
+type wkbReader struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+	dims  int
+}
+
+func (r *wkbReader) uint32() uint32 {
+	v := r.order.Uint32(r.data[r.pos : r.pos+4])
+	r.pos += 4
+	return v
+}
+
+func (r *wkbReader) count() int {
+	n := int(r.uint32())
+	// Every element takes at least 8 bytes, which guards against huge allocations
+	if n > (len(r.data)-r.pos)/8 {
+		panic(InvalidWKBErr)
+	}
+	return n
+}
+
+func (r *wkbReader) point() orb.Point {
+	var p orb.Point
+	for i := 0; i < r.dims; i++ {
+		v := math.Float64frombits(r.order.Uint64(r.data[r.pos : r.pos+8]))
+		if i < 2 {
+			p[i] = v
+		}
+		r.pos += 8
+	}
+	return p
+}
+
+func (r *wkbReader) points() []orb.Point {
+	points := make([]orb.Point, r.count())
+	for i := range points {
+		points[i] = r.point()
+	}
+	return points
+}
+
+func (r *wkbReader) rings() []orb.Ring {
+	rings := make([]orb.Ring, r.count())
+	for i := range rings {
+		rings[i] = r.points()
+	}
+	return rings
+}
+
+// header reads the byte order and type of a geometry, returning the base geometry type
+func (r *wkbReader) header() (uint32, error) {
+	switch r.data[r.pos] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return 0, fmt.Errorf("%w: byte order %d", InvalidWKBErr, r.data[r.pos])
+	}
+	r.pos++
+	typ := r.uint32()
+	r.dims = 2
+	// Extended WKB flags
+	if typ&0x80000000 != 0 {
+		r.dims++
+	}
+	if typ&0x40000000 != 0 {
+		r.dims++
+	}
+	if typ&0x20000000 != 0 {
+		// Skip the embedded SRID
+		r.pos += 4
+	}
+	typ &= 0x0fffffff
+	// ISO WKB types
+	switch typ / 1000 {
+	case 0:
+	case 1, 2:
+		r.dims++
+	case 3:
+		r.dims += 2
+	default:
+		return 0, fmt.Errorf("%w: geometry type %d", InvalidWKBErr, typ)
+	}
+	return typ % 1000, nil
+}
+
+// geometry reads a geometry of any type
+func (r *wkbReader) geometry(depth int) (orb.Geometry, error) {
+	if depth > wkbMaxDepth {
+		return nil, InvalidWKBErr
+	}
+	typ, err := r.header()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case 1:
+		p := r.point()
+		// Empty points are encoded with NaN coordinates
+		if math.IsNaN(p[0]) && math.IsNaN(p[1]) {
+			return nil, nil
+		}
+		return p, nil
+	case 2:
+		return orb.LineString(r.points()), nil
+	case 3:
+		return orb.Polygon(r.rings()), nil
+	case 4, 5, 6, 7:
+		n := r.count()
+		var geoms []orb.Geometry
+		for i := 0; i < n; i++ {
+			g, err := r.geometry(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if g != nil {
+				geoms = append(geoms, g)
+			}
+		}
+		return wkbCollection(typ, geoms)
+	default:
+		return nil, fmt.Errorf("%w: geometry type %d", InvalidWKBErr, typ)
+	}
+}
+
+// wkbCollection builds a multi-geometry or geometry collection from its members
+func wkbCollection(typ uint32, geoms []orb.Geometry) (orb.Geometry, error) {
+	switch typ {
+	case 4:
+		mp := make(orb.MultiPoint, 0, len(geoms))
+		for _, g := range geoms {
+			p, ok := g.(orb.Point)
+			if !ok {
+				return nil, fmt.Errorf("%w: MultiPoint member is a %s", InvalidWKBErr, g.GeoJSONType())
+			}
+			mp = append(mp, p)
+		}
+		return mp, nil
+	case 5:
+		mls := make(orb.MultiLineString, 0, len(geoms))
+		for _, g := range geoms {
+			ls, ok := g.(orb.LineString)
+			if !ok {
+				return nil, fmt.Errorf("%w: MultiLineString member is a %s", InvalidWKBErr, g.GeoJSONType())
+			}
+			mls = append(mls, ls)
+		}
+		return mls, nil
+	case 6:
+		mp := make(orb.MultiPolygon, 0, len(geoms))
+		for _, g := range geoms {
+			p, ok := g.(orb.Polygon)
+			if !ok {
+				return nil, fmt.Errorf("%w: MultiPolygon member is a %s", InvalidWKBErr, g.GeoJSONType())
+			}
+			mp = append(mp, p)
+		}
+		return mp, nil
+	default:
+		return orb.Collection(geoms), nil
+	}
+}
@@ -0,0 +1,350 @@
+package tilenol
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	// SQL deps
+	"github.com/doug-martin/goqu/v9"
+	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
+	// Geo deps
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+var (
+	// MissingGeoPackagePathErr is the error returned when a GeoPackage source has no file path
+	MissingGeoPackagePathErr = errors.New("GeoPackage source requires a \"path\"")
+	// InvalidGeoPackageTableErr is the error returned when a GeoPackage source has both a table
+	// and a table expression
+	InvalidGeoPackageTableErr = errors.New("Either \"tableExpression\" or \"table\" can be set, not both.")
+	// MissingGeoPackageTableErr is the error returned when a GeoPackage source has neither a
+	// table nor a table expression
+	MissingGeoPackageTableErr = errors.New("Either \"tableExpression\" or \"table\" must be set.")
+	// MissingGeoPackageGeometryFieldErr is the error returned when a GeoPackage table expression
+	// has no geometry field
+	MissingGeoPackageGeometryFieldErr = errors.New("GeoPackage \"tableExpression\" requires a \"geometryField\"")
+	// UnknownGeoPackageTableErr is the error returned when a table is not registered as a
+	// GeoPackage feature table
+	UnknownGeoPackageTableErr = errors.New("Table is not a GeoPackage feature table")
+	// UnknownGeoPackageSRSErr is the error returned for geometries with an SRS ID that is not
+	// defined in the GeoPackage
+	UnknownGeoPackageSRSErr = errors.New("Unknown GeoPackage SRS")
+)
+
+// GeoPackageConfig is the YAML configuration structure for configuring a new GeoPackageSource
+type GeoPackageConfig struct {
+	// Path is the path to the GeoPackage file
+	Path string `yaml:"path"`
+	// Table is the name of the feature table to use for queries
+	Table string `yaml:"table"`
+	// TableExpression is a valid SQL query that is used as an alternative to Table
+	TableExpression string `yaml:"tableExpression"`
+	// GeometryField is the name of the column that holds the feature geometry, which defaults to
+	// the registered geometry column of Table
+	GeometryField string `yaml:"geometryField"`
+	// SourceFields is a mapping from the feature property name to the source row
+	// column names
+	SourceFields map[string]string `yaml:"sourceFields"`
+}
+
+// Dataset constructs a subquery to be used as the source table for all request-time queries
+func (c *GeoPackageConfig) Dataset() (*goqu.SelectDataset, error) {
+	// Ensure that table configuration makes sense
+	if c.TableExpression != "" && c.Table != "" {
+		return nil, InvalidGeoPackageTableErr
+	}
+
+	dialect := goqu.Dialect("sqlite3")
+	if c.Table != "" {
+		return dialect.From(goqu.T(c.Table)), nil
+	} else if c.TableExpression != "" {
+		var tableExp = strings.TrimSpace(c.TableExpression)
+		if !strings.HasPrefix(tableExp, "(") {
+			tableExp = fmt.Sprintf("(%s)", tableExp)
+		}
+		return dialect.From(goqu.Literal(tableExp).As(TableAlias)), nil
+	}
+	return nil, MissingGeoPackageTableErr
+}
+
+// GeoPackageSource is a Source implementation that retrieves feature data from a GeoPackage
+// file, using the RTree spatial index of the feature table (if any) to only read the features
+// that intersect the requested tile
+type GeoPackageSource struct {
+	// DB is the read-only connection to the GeoPackage SQLite database
+	DB            *sql.DB
+	Dataset       *goqu.SelectDataset
+	GeometryField string
+	SourceFields  map[string]string
+
+	// srsID is the SRS ID of the feature table geometries
+	srsID int32
+	// rtree is the name of the RTree spatial index of the feature table (if any)
+	rtree string
+	// primaryKey is the name of the primary key column of the feature table
+	primaryKey string
+
+	mu         sync.Mutex
+	transforms map[int32]*crsTransform
+}
+
+// NewGeoPackageSource creates a new Source that retrieves feature data from a GeoPackage file
+func NewGeoPackageSource(config *GeoPackageConfig) (Source, error) {
+	if config.Path == "" {
+		return nil, MissingGeoPackagePathErr
+	}
+	// Create the base select dataset for request-time queries
+	dataset, err := config.Dataset()
+	if err != nil {
+		return nil, err
+	}
+	if config.TableExpression != "" && config.GeometryField == "" {
+		return nil, MissingGeoPackageGeometryFieldErr
+	}
+
+	db, err := openSQLiteReadOnly(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	g := &GeoPackageSource{
+		DB:            db,
+		Dataset:       dataset,
+		GeometryField: config.GeometryField,
+		SourceFields:  config.SourceFields,
+		transforms:    make(map[int32]*crsTransform),
+	}
+	if config.Table != "" {
+		if err := g.loadTable(config.Table); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("Failed to read GeoPackage table [%s]: %w", config.Table, err)
+		}
+	}
+	return g, nil
+}
+
+// loadTable looks up the geometry column, coordinate reference system, spatial index and
+// primary key of a feature table
+func (g *GeoPackageSource) loadTable(table string) error {
+	q := "SELECT column_name, srs_id FROM gpkg_geometry_columns WHERE table_name = ?"
+	args := []interface{}{table}
+	if g.GeometryField != "" {
+		q += " AND column_name = ?"
+		args = append(args, g.GeometryField)
+	}
+	err := g.DB.QueryRow(q, args...).Scan(&g.GeometryField, &g.srsID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UnknownGeoPackageTableErr
+	} else if err != nil {
+		return err
+	}
+	// Ensure that the coordinate reference system is supported
+	if _, err := g.transform(g.srsID); err != nil {
+		return err
+	}
+
+	// The spatial index is only usable when the table has an integer primary key
+	rtree := fmt.Sprintf("rtree_%s_%s", table, g.GeometryField)
+	var exists int
+	if err := g.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", rtree).Scan(&exists); err != nil {
+		return err
+	}
+	err = g.DB.QueryRow("SELECT name FROM pragma_table_info(?) WHERE pk = 1", table).Scan(&g.primaryKey)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if exists > 0 && g.primaryKey != "" {
+		g.rtree = rtree
+	} else {
+		Logger.Warnf("GeoPackage table [%s] has no spatial index, so every request scans the whole table", table)
+	}
+	return nil
+}
+
+// transform returns the (cached) transform between the coordinates of an SRS and WGS84, where
+// nil means that no reprojection is required. Note that the undefined SRSs (0 and -1) are
+// assumed to be WGS84.
+func (g *GeoPackageSource) transform(srsID int32) (*crsTransform, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if t, ok := g.transforms[srsID]; ok {
+		return t, nil
+	}
+	var definition, organization string
+	var code int64
+	err := g.DB.QueryRow(
+		"SELECT definition, organization, organization_coordsys_id FROM gpkg_spatial_ref_sys WHERE srs_id = ?",
+		srsID).Scan(&definition, &organization, &code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", UnknownGeoPackageSRSErr, srsID)
+	} else if err != nil {
+		return nil, err
+	}
+	var t *crsTransform
+	if srsID > 0 && !(strings.EqualFold(organization, "EPSG") && code == 4326) {
+		if t, err = crsTransformFromWKT(definition); err != nil {
+			return nil, fmt.Errorf("SRS %d: %w", srsID, err)
+		}
+	}
+	g.transforms[srsID] = t
+	return t, nil
+}
+
+// Close closes the underlying SQLite database
+func (g *GeoPackageSource) Close() error {
+	return g.DB.Close()
+}
+
+// HealthCheck implements the HealthChecker interface by pinging the database
+func (g *GeoPackageSource) HealthCheck(ctx context.Context) error {
+	return CheckPingContext(ctx, g.DB)
+}
+
+// Fields implements the FieldsSource interface
+func (g *GeoPackageSource) Fields() []string {
+	return sourceFieldNames(g.SourceFields)
+}
+
+// Constructs a raw SQL statement from the tile request parameters
+func (g *GeoPackageSource) buildSQL(bounds orb.Bound, sourceFields map[string]string, extraFilters ...goqu.Expression) (string, error) {
+	// Create the base query from the provided table or table expression
+	var q = g.Dataset.Clone().(*goqu.SelectDataset)
+
+	// Add the columns we want to select out of the table
+	var selectColumns = []interface{}{goqu.I(g.GeometryField)}
+	for dst, src := range sourceFields {
+		selectColumns = append(selectColumns, goqu.L(src).As(dst))
+	}
+	q = q.Select(selectColumns...)
+
+	// Restrict the query to the rows whose bounds intersect the tile bounds (in the coordinates
+	// of the table), using the spatial index
+	if g.rtree != "" {
+		t, err := g.transform(g.srsID)
+		if err != nil {
+			return "", err
+		}
+		if t != nil {
+			bounds = t.Bound(bounds)
+		}
+		index := goqu.Dialect("sqlite3").From(goqu.T(g.rtree)).Select(goqu.C("id")).Where(
+			goqu.C("minx").Lte(bounds.Max.X()),
+			goqu.C("maxx").Gte(bounds.Min.X()),
+			goqu.C("miny").Lte(bounds.Max.Y()),
+			goqu.C("maxy").Gte(bounds.Min.Y()),
+		)
+		q = q.Where(goqu.I(g.primaryKey).In(index))
+	}
+
+	// Add any extra request-time filter expressions to the WHERE clause of the query
+	q = q.Where(extraFilters...)
+
+	// Lastly, compile and return the results
+	sql, _, err := q.ToSQL()
+	if err != nil {
+		return "", err
+	}
+	return sql, nil
+}
+
+// Actually runs the compiled SQL query, and returns a list of mapped records upon success.
+// Note that the database connection is read-only, so request-time filters can't write to it.
+func (g *GeoPackageSource) runQuery(ctx context.Context, q string) ([]map[string]interface{}, error) {
+	// Create a cancellable context using a timeout
+	qCtx, qCancel := context.WithTimeout(ctx, QueryTimeout)
+	defer qCancel()
+
+	Logger.Debugf("Executing SQL: %s\n", q)
+	rows, err := g.DB.QueryContext(qCtx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Geometry blobs are decoded separately, since they are not plain WKB
+	records, err := RowsToMaps(rows, "")
+	if err != nil {
+		return nil, err
+	}
+	return records, rows.Err()
+}
+
+// decodeGeometry decodes a GeoPackage geometry blob, reprojecting it to WGS84
+func (g *GeoPackageSource) decodeGeometry(value interface{}) (orb.Geometry, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, ok := value.([]byte)
+	if !ok {
+		return nil, InvalidGeometryErr
+	}
+	geom, srsID, err := decodeGeoPackageGeometry(data)
+	if err != nil || geom == nil {
+		return nil, err
+	}
+	t, err := g.transform(srsID)
+	if err != nil || t == nil {
+		return geom, err
+	}
+	return reprojectGeometry(geom, t.ToWGS84), nil
+}
+
+// GetFeatures implements the Source interface, to get feature data from a GeoPackage file
+func (g *GeoPackageSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	// Check for extra fields specifications, as with PostGIS sources
+	sourceFields, err := requestSourceFields(g.SourceFields, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Also check extra source filtering ("q" parameter)
+	var extraFilters []goqu.Expression
+	if qs, exists := req.Args["q"]; exists && len(qs) > 0 {
+		for _, q := range qs {
+			extraFilters = append(extraFilters, goqu.Literal(q))
+		}
+	}
+
+	// Create the final SQL query
+	bounds := req.MapTile().Bound()
+	q, err := g.buildSQL(bounds, sourceFields, extraFilters...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute the SQL query and retrieve the mapped records
+	records, err := g.runQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Then turn each record into a feature, dropping features outside of the tile (which the
+	// spatial index, if any, only filters approximately)
+	fc := geojson.NewFeatureCollection()
+	for _, r := range records {
+		geom, err := g.decodeGeometry(r[g.GeometryField])
+		if err != nil {
+			return nil, err
+		}
+		if geom == nil || !geom.Bound().Intersects(bounds) {
+			continue
+		}
+		feature := geojson.NewFeature(geom)
+		for k, v := range r {
+			// Special-case the feature ID
+			if k == "id" {
+				feature.ID = v
+			}
+			// Omit the geometry field and null values
+			if k != g.GeometryField && v != nil {
+				feature.Properties[k] = v
+			}
+		}
+		fc.Append(feature)
+	}
+	return fc, nil
+}
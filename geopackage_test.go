@@ -0,0 +1,300 @@
+package tilenol
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/stretchr/testify/assert"
+)
+
+// webMercatorWKT is the GDAL definition of EPSG:3857
+const webMercatorWKT = `PROJCS["WGS 84 / Pseudo-Mercator",GEOGCS["WGS 84",DATUM["WGS_1984",` +
+	`SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],` +
+	`PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],` +
+	`AUTHORITY["EPSG","4326"]],PROJECTION["Mercator_1SP"],PARAMETER["central_meridian",0],` +
+	`PARAMETER["scale_factor",1],PARAMETER["false_easting",0],PARAMETER["false_northing",0],` +
+	`UNIT["metre",1,AUTHORITY["EPSG","9001"]],AXIS["Easting",EAST],AXIS["Northing",NORTH],` +
+	`AUTHORITY["EPSG","3857"]]`
+
+// encodeTestGeoPackageGeometry encodes a geometry as a GeoPackage blob with an XY envelope
+func encodeTestGeoPackageGeometry(t *testing.T, g orb.Geometry, srsID int32) []byte {
+	data, err := wkb.Marshal(g, binary.LittleEndian)
+	assert.Nil(t, err)
+	bound := g.Bound()
+	header := []byte{'G', 'P', 0, geoPackageFlagLittleEndian | 1<<1}
+	header = binary.LittleEndian.AppendUint32(header, uint32(srsID))
+	for _, v := range []float64{bound.Min[0], bound.Max[0], bound.Min[1], bound.Max[1]} {
+		header = binary.LittleEndian.AppendUint64(header, math.Float64bits(v))
+	}
+	return append(header, data...)
+}
+
+// encodeTestWKB encodes WKB data from a byte order, a geometry type and raw values, where
+// values are either uint32 counts, float64 coordinates or nested WKB data
+func encodeTestWKB(order binary.AppendByteOrder, typ uint32, values ...interface{}) []byte {
+	data := []byte{0}
+	if order == binary.LittleEndian {
+		data[0] = 1
+	}
+	data = order.AppendUint32(data, typ)
+	for _, v := range values {
+		switch v := v.(type) {
+		case uint32:
+			data = order.AppendUint32(data, v)
+		case float64:
+			data = order.AppendUint64(data, math.Float64bits(v))
+		case []byte:
+			data = append(data, v...)
+		}
+	}
+	return data
+}
+
+var testGeoPackageCities = []struct {
+	name       string
+	point      orb.Point
+	population int
+}{
+	{"Seattle", orb.Point{-122.33, 47.61}, 750000},
+	{"London", orb.Point{-0.13, 51.51}, 9000000},
+	{"Sydney", orb.Point{151.21, -33.87}, 5000000},
+}
+
+// writeTestGeoPackage writes a GeoPackage with a "cities" table (in Web Mercator coordinates,
+// with a spatial index) and a "towns" table (in WGS84 coordinates, without a spatial index)
+func writeTestGeoPackage(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "test.gpkg")
+	db, err := sql.Open("sqlite", path)
+	assert.Nil(t, err)
+	defer db.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER PRIMARY KEY,
+			organization TEXT NOT NULL, organization_coordsys_id INTEGER NOT NULL,
+			definition TEXT NOT NULL, description TEXT)`,
+		`CREATE TABLE gpkg_geometry_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL,
+			geometry_type_name TEXT NOT NULL, srs_id INTEGER NOT NULL, z TINYINT NOT NULL, m TINYINT NOT NULL)`,
+		`CREATE TABLE cities (fid INTEGER PRIMARY KEY AUTOINCREMENT, geom BLOB, name TEXT, population INTEGER)`,
+		`CREATE VIRTUAL TABLE rtree_cities_geom USING rtree(id, minx, maxx, miny, maxy)`,
+		`CREATE TABLE towns (fid INTEGER PRIMARY KEY AUTOINCREMENT, shape BLOB, name TEXT)`,
+		`INSERT INTO gpkg_geometry_columns VALUES ('cities', 'geom', 'POINT', 3857, 0, 0),
+			('towns', 'shape', 'POINT', 4326, 0, 0)`,
+	} {
+		_, err := db.Exec(stmt)
+		assert.Nil(t, err)
+	}
+	_, err = db.Exec("INSERT INTO gpkg_spatial_ref_sys VALUES (?, ?, ?, ?, ?, NULL)",
+		"WGS 84 / Pseudo-Mercator", 3857, "EPSG", 3857, webMercatorWKT)
+	assert.Nil(t, err)
+	_, err = db.Exec("INSERT INTO gpkg_spatial_ref_sys VALUES (?, ?, ?, ?, ?, NULL)",
+		"WGS 84", 4326, "EPSG", 4326, "undefined")
+	assert.Nil(t, err)
+
+	mercator, err := crsTransformFromWKT(webMercatorWKT)
+	assert.Nil(t, err)
+	for i, city := range testGeoPackageCities {
+		p := mercator.FromWGS84(city.point)
+		_, err := db.Exec("INSERT INTO cities (fid, geom, name, population) VALUES (?, ?, ?, ?)",
+			i+1, encodeTestGeoPackageGeometry(t, p, 3857), city.name, city.population)
+		assert.Nil(t, err)
+		_, err = db.Exec("INSERT INTO rtree_cities_geom VALUES (?, ?, ?, ?, ?)", i+1, p[0], p[0], p[1], p[1])
+		assert.Nil(t, err)
+		_, err = db.Exec("INSERT INTO towns (shape, name) VALUES (?, ?)",
+			encodeTestGeoPackageGeometry(t, city.point, 4326), city.name)
+		assert.Nil(t, err)
+	}
+	// Null geometries are skipped
+	_, err = db.Exec("INSERT INTO cities (name, population) VALUES ('Nowhere', 0)")
+	assert.Nil(t, err)
+	return path
+}
+
+func TestGeoPackageSource(t *testing.T) {
+	path := writeTestGeoPackage(t)
+	source, err := NewGeoPackageSource(&GeoPackageConfig{
+		Path:         path,
+		Table:        "cities",
+		SourceFields: map[string]string{"id": "fid", "name": "name"},
+	})
+	assert.Nil(t, err)
+	g := source.(*GeoPackageSource)
+	defer g.Close()
+	assert.Equal(t, "geopackage", Layer{source: source}.SourceType())
+	assert.Nil(t, g.HealthCheck(context.Background()))
+	assert.Equal(t, "geom", g.GeometryField)
+	assert.Equal(t, "rtree_cities_geom", g.rtree)
+
+	q, err := g.buildSQL(orb.Bound{Max: orb.Point{1, 1}}, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, q, "`rtree_cities_geom`")
+
+	// The north-western quarter of the world
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 1})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"Seattle", "London"}, featureNames(fc))
+	for _, f := range fc.Features {
+		if f.Properties["name"] == "Seattle" {
+			assert.Equal(t, int64(1), f.ID)
+			assert.InDelta(t, -122.33, f.Point().X(), 1e-9)
+			assert.InDelta(t, 47.61, f.Point().Y(), 1e-9)
+		}
+		assert.NotContains(t, f.Properties, "geom")
+	}
+
+	// Extra source fields and filters
+	fc, err = source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 1, Args: map[string][]string{
+		"s": {"millions:population / 1000000"},
+		"q": {"population > 1000000"},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"London"}, featureNames(fc))
+	assert.Equal(t, int64(9), fc.Features[0].Properties["millions"])
+
+	fc, err = source.GetFeatures(context.Background(), &TileRequest{X: 1, Y: 1, Z: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Sydney"}, featureNames(fc))
+}
+
+func TestGeoPackageSourceWithoutIndex(t *testing.T) {
+	path := writeTestGeoPackage(t)
+	source, err := NewGeoPackageSource(&GeoPackageConfig{
+		Path:         path,
+		Table:        "towns",
+		SourceFields: map[string]string{"name": "name"},
+	})
+	assert.Nil(t, err)
+	defer source.(*GeoPackageSource).Close()
+	assert.Equal(t, "shape", source.(*GeoPackageSource).GeometryField)
+	assert.Empty(t, source.(*GeoPackageSource).rtree)
+
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 1})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"Seattle", "London"}, featureNames(fc))
+}
+
+func TestGeoPackageSourceTableExpression(t *testing.T) {
+	path := writeTestGeoPackage(t)
+	source, err := NewGeoPackageSource(&GeoPackageConfig{
+		Path:            path,
+		TableExpression: "SELECT fid, geom, upper(name) AS name FROM cities WHERE population > 1000000",
+		GeometryField:   "geom",
+		SourceFields:    map[string]string{"name": "name"},
+	})
+	assert.Nil(t, err)
+	defer source.(*GeoPackageSource).Close()
+
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"LONDON"}, featureNames(fc))
+	assert.InDelta(t, -0.13, fc.Features[0].Point().X(), 1e-9)
+	assert.InDelta(t, 51.51, fc.Features[0].Point().Y(), 1e-9)
+}
+
+func TestGeoPackageSourceConfig(t *testing.T) {
+	path := writeTestGeoPackage(t)
+	tests := []struct {
+		config   GeoPackageConfig
+		expected error
+	}{
+		{GeoPackageConfig{Table: "cities"}, MissingGeoPackagePathErr},
+		{GeoPackageConfig{Path: path}, MissingGeoPackageTableErr},
+		{GeoPackageConfig{Path: path, Table: "cities", TableExpression: "SELECT 1"}, InvalidGeoPackageTableErr},
+		{GeoPackageConfig{Path: path, TableExpression: "SELECT 1"}, MissingGeoPackageGeometryFieldErr},
+		{GeoPackageConfig{Path: path, Table: "gpkg_spatial_ref_sys"}, UnknownGeoPackageTableErr},
+		{GeoPackageConfig{Path: path, Table: "cities", GeometryField: "shape"}, UnknownGeoPackageTableErr},
+	}
+	for _, test := range tests {
+		_, err := NewGeoPackageSource(&test.config)
+		assert.ErrorIs(t, err, test.expected)
+	}
+	_, err := NewGeoPackageSource(&GeoPackageConfig{Path: filepath.Join(t.TempDir(), "missing.gpkg"), Table: "a"})
+	assert.NotNil(t, err)
+}
+
+func TestDecodeGeoPackageGeometry(t *testing.T) {
+	geom, srsID, err := decodeGeoPackageGeometry(encodeTestGeoPackageGeometry(t, orb.Point{1, 2}, 4326))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4326), srsID)
+	assert.Equal(t, orb.Point{1, 2}, geom)
+
+	// Big-endian header without an envelope
+	data := []byte{'G', 'P', 0, 0, 0, 0, 0x0f, 0x11}
+	data = append(data, encodeTestWKB(binary.BigEndian, 1, 3.0, 4.0)...)
+	geom, srsID, err = decodeGeoPackageGeometry(data)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3857), srsID)
+	assert.Equal(t, orb.Point{3, 4}, geom)
+
+	// Empty geometries
+	geom, _, err = decodeGeoPackageGeometry([]byte{'G', 'P', 0, geoPackageFlagEmpty | geoPackageFlagLittleEndian, 0, 0, 0, 0})
+	assert.Nil(t, err)
+	assert.Nil(t, geom)
+
+	for _, data := range [][]byte{
+		nil,
+		[]byte("GX\x00\x01\x00\x00\x00\x00"),
+		{'G', 'P', 0, geoPackageFlagExtended, 0, 0, 0, 0},
+		{'G', 'P', 0, 5 << 1, 0, 0, 0, 0},
+		{'G', 'P', 0, 1 << 1, 0, 0, 0, 0},
+	} {
+		_, _, err := decodeGeoPackageGeometry(data)
+		assert.ErrorIs(t, err, InvalidGeoPackageGeometryErr)
+	}
+}
+
+func TestDecodeWKB(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected orb.Geometry
+	}{
+		{
+			name:     "ISO point Z",
+			data:     encodeTestWKB(binary.LittleEndian, 1001, 1.0, 2.0, 3.0),
+			expected: orb.Point{1, 2},
+		},
+		{
+			name:     "ISO line string ZM",
+			data:     encodeTestWKB(binary.BigEndian, 3002, uint32(2), 1.0, 2.0, 3.0, 4.0, 5.0, 6.0, 7.0, 8.0),
+			expected: orb.LineString{{1, 2}, {5, 6}},
+		},
+		{
+			name: "extended multipolygon Z with an SRID",
+			data: encodeTestWKB(binary.LittleEndian, 0xa0000006, uint32(4326), uint32(1),
+				encodeTestWKB(binary.BigEndian, 0x80000003, uint32(1), uint32(4),
+					0.0, 0.0, 9.0, 1.0, 0.0, 9.0, 1.0, 1.0, 9.0, 0.0, 0.0, 9.0)),
+			expected: orb.MultiPolygon{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}},
+		},
+		{
+			name: "collection with an empty point M",
+			data: encodeTestWKB(binary.LittleEndian, 7, uint32(2),
+				encodeTestWKB(binary.LittleEndian, 2001, math.NaN(), math.NaN(), 0.0),
+				encodeTestWKB(binary.LittleEndian, 1, 1.0, 2.0)),
+			expected: orb.Collection{orb.Point{1, 2}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			geom, err := decodeWKB(test.data)
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, geom)
+		})
+	}
+
+	for _, data := range [][]byte{
+		nil,
+		{2, 1, 0, 0, 0},
+		encodeTestWKB(binary.LittleEndian, 1, 1.0),
+		encodeTestWKB(binary.LittleEndian, 4001, 1.0, 2.0),
+		encodeTestWKB(binary.LittleEndian, 2, uint32(math.MaxUint32)),
+		encodeTestWKB(binary.LittleEndian, 4, uint32(1), encodeTestWKB(binary.LittleEndian, 2, uint32(0))),
+	} {
+		_, err := decodeWKB(data)
+		assert.ErrorIs(t, err, InvalidWKBErr)
+	}
+}
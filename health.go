@@ -0,0 +1,112 @@
+package tilenol
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// HealthCheckTimeout is the maximum time to wait for all components to report their health
+	HealthCheckTimeout = 5 * time.Second
+	// StatusOK is the status reported for healthy components
+	StatusOK = "ok"
+	// StatusError is the status reported for unhealthy components
+	StatusError = "error"
+)
+
+// HealthChecker is an optional interface for sources and caches that can check whether or
+// not their backend is reachable
+type HealthChecker interface {
+	// HealthCheck returns an error if the backend is not healthy
+	HealthCheck(context.Context) error
+}
+
+// ComponentHealth is the health status of a single server component
+type ComponentHealth struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the aggregate health status of all server components
+type HealthReport struct {
+	Status     string            `json:"status"`
+	Components []ComponentHealth `json:"components"`
+}
+
+// checkComponentHealth runs a single component health check, timing how long it took
+func checkComponentHealth(ctx context.Context, name string, checker HealthChecker) ComponentHealth {
+	start := time.Now()
+	err := checker.HealthCheck(ctx)
+	health := ComponentHealth{
+		Name:      name,
+		Status:    StatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		health.Status = StatusError
+		health.Error = err.Error()
+	}
+	return health
+}
+
+// CheckHealth concurrently probes every layer source and the cache that implement the
+// HealthChecker interface
+func (s *Server) CheckHealth(ctx context.Context) *HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+
+	var names []string
+	var checkers []HealthChecker
+	for _, layer := range s.Layers {
+		if checker, ok := layer.source.(HealthChecker); ok {
+			names = append(names, fmt.Sprintf("layer:%s", layer.Name))
+			checkers = append(checkers, checker)
+		}
+	}
+	if checker, ok := s.Cache.(HealthChecker); ok {
+		names = append(names, "cache")
+		checkers = append(checkers, checker)
+	}
+
+	report := &HealthReport{
+		Status:     StatusOK,
+		Components: make([]ComponentHealth, len(checkers)),
+	}
+	var wg sync.WaitGroup
+	for i, checker := range checkers {
+		wg.Add(1)
+		go func(i int, checker HealthChecker) {
+			defer wg.Done()
+			report.Components[i] = checkComponentHealth(ctx, names[i], checker)
+		}(i, checker)
+	}
+	wg.Wait()
+
+	for _, component := range report.Components {
+		if component.Status != StatusOK {
+			report.Status = StatusError
+		}
+	}
+	return report
+}
+
+// liveness implements a liveness endpoint, which only reports that the process is serving
+func (s *Server) liveness(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, r, &HealthReport{Status: StatusOK, Components: []ComponentHealth{}})
+}
+
+// readiness implements a readiness endpoint, which reports the health of every layer source
+// and the cache
+func (s *Server) readiness(w http.ResponseWriter, r *http.Request) {
+	report := s.CheckHealth(r.Context())
+	if report.Status != StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	s.writeJSON(w, r, report)
+}
@@ -0,0 +1,75 @@
+package tilenol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type healthCheckingSource struct {
+	NilSource
+	err error
+}
+
+func (h *healthCheckingSource) HealthCheck(ctx context.Context) error {
+	return h.err
+}
+
+func TestLiveness(t *testing.T) {
+	server := &Server{Cache: &NilCache{}}
+	_, internal := server.setupRoutes()
+
+	r := httptest.NewRequest("GET", "/livez", nil)
+	w := httptest.NewRecorder()
+	internal.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Result().StatusCode)
+}
+
+func TestReadinessHealthy(t *testing.T) {
+	layers := []Layer{
+		Layer{Name: "a", source: &healthCheckingSource{}},
+		Layer{Name: "b", source: &NilSource{}},
+	}
+	server := &Server{Layers: layers, Cache: &NilCache{}}
+	_, internal := server.setupRoutes()
+
+	r := httptest.NewRequest("GET", "/readyz", nil)
+	w := httptest.NewRecorder()
+	internal.ServeHTTP(w, r)
+	res := w.Result()
+	assert.Equal(t, 200, res.StatusCode)
+
+	var report HealthReport
+	err := json.NewDecoder(res.Body).Decode(&report)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Len(t, report.Components, 1, "Only components implementing HealthChecker are reported")
+	assert.Equal(t, "layer:a", report.Components[0].Name)
+}
+
+func TestReadinessUnhealthy(t *testing.T) {
+	layers := []Layer{
+		Layer{Name: "a", source: &healthCheckingSource{}},
+		Layer{Name: "b", source: &healthCheckingSource{err: errors.New("connection refused")}},
+	}
+	server := &Server{Layers: layers, Cache: &NilCache{}}
+	_, internal := server.setupRoutes()
+
+	r := httptest.NewRequest("GET", "/readyz", nil)
+	w := httptest.NewRecorder()
+	internal.ServeHTTP(w, r)
+	res := w.Result()
+	assert.Equal(t, 503, res.StatusCode)
+
+	var report HealthReport
+	err := json.NewDecoder(res.Body).Decode(&report)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusError, report.Status)
+	assert.Equal(t, StatusOK, report.Components[0].Status)
+	assert.Equal(t, StatusError, report.Components[1].Status)
+	assert.Equal(t, "connection refused", report.Components[1].Error)
+}
@@ -0,0 +1,310 @@
+package tilenol
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+const (
+	// DefaultHTTPTimeout is the default maximum time to wait for a single upstream request
+	DefaultHTTPTimeout = 10 * time.Second
+	// DefaultHTTPCacheSize is the default number of upstream tiles kept in memory by an
+	// HTTPSource for conditional requests
+	DefaultHTTPCacheSize = 256
+	// httpRetryBackoff is the delay before the first retry, which doubles for each retry
+	httpRetryBackoff = 100 * time.Millisecond
+	// httpMaxTileSize is the maximum size of an upstream tile
+	httpMaxTileSize = 32 << 20
+)
+
+var (
+	// MissingHTTPURLErr is the error returned when an HTTP source has no URL template
+	MissingHTTPURLErr = errors.New("HTTP source requires a \"url\"")
+	// MissingHTTPLayerErr is the error returned when an HTTP source has no layer name
+	MissingHTTPLayerErr = errors.New("HTTP source requires a \"layer\"")
+	// InvalidHTTPURLErr is the error returned when an HTTP source URL is not a valid template
+	InvalidHTTPURLErr = errors.New("HTTP source \"url\" must be an http(s) URL with {z}, {x} and {y} (or {-y}) placeholders")
+)
+
+// HTTPConfig is the YAML configuration structure for configuring a new HTTPSource
+type HTTPConfig struct {
+	// URL is the XYZ URL template of the upstream tiles, where {z}, {x} and {y} are replaced with
+	// the tile coordinates (and {-y} with the TMS row)
+	URL string `yaml:"url"`
+	// Layer is the name of the vector tile layer to extract from the upstream tiles
+	Layer string `yaml:"layer"`
+	// SourceFields is a mapping from the feature property name to the upstream feature property
+	// name, where all properties are kept if unset and no extra source fields are requested
+	// (optional)
+	SourceFields map[string]string `yaml:"sourceFields"`
+	// Headers are extra headers sent with upstream requests, e.g. API keys (optional)
+	Headers map[string]string `yaml:"headers"`
+	// Timeout is the maximum time to wait for a single upstream request (optional)
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is the number of times a failed upstream request is retried (optional)
+	Retries int `yaml:"retries"`
+	// MaxZoom is the maximum zoom level of the upstream tiles, above which the features of the
+	// ancestor tile at MaxZoom are used (optional)
+	MaxZoom int `yaml:"maxzoom"`
+	// CacheSize is the number of upstream tiles kept in memory for conditional requests
+	// (optional)
+	CacheSize int `yaml:"cacheSize"`
+}
+
+// httpTileCache is a concurrency-safe LRU cache of upstream tiles, keyed by their URL
+type httpTileCache struct {
+	size  int
+	mu    sync.Mutex
+	lru   *list.List
+	cache map[string]*list.Element
+}
+
+// httpCachedTile is a single upstream tile and its validators, tracked in the LRU list
+type httpCachedTile struct {
+	url          string
+	etag         string
+	lastModified string
+	data         []byte
+}
+
+// get retrieves a cached tile, marking it as recently used
+func (c *httpTileCache) get(url string) (*httpCachedTile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, exists := c.cache[url]
+	if !exists {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*httpCachedTile), true
+}
+
+// put stores a tile, evicting the least recently used tile if the cache is full
+func (c *httpTileCache) put(tile *httpCachedTile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, exists := c.cache[tile.url]; exists {
+		elem.Value = tile
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.cache[tile.url] = c.lru.PushFront(tile)
+	for c.lru.Len() > c.size {
+		cached := c.lru.Remove(c.lru.Back()).(*httpCachedTile)
+		delete(c.cache, cached.url)
+	}
+}
+
+// HTTPSource is a Source implementation that retrieves feature data from the vector tiles of
+// an upstream tile server. Upstream tiles with an ETag or Last-Modified header are kept in
+// memory, and revalidated with conditional requests.
+type HTTPSource struct {
+	// URL is the XYZ URL template of the upstream tiles
+	URL string
+	// Layer is the name of the vector tile layer to extract from the upstream tiles
+	Layer string
+	// SourceFields is a mapping from the feature property name to the upstream feature property
+	// name (if any)
+	SourceFields map[string]string
+
+	client  *http.Client
+	headers map[string]string
+	retries int
+	maxZoom int
+	tiles   *httpTileCache
+}
+
+// NewHTTPSource creates a new Source that retrieves feature data from an upstream tile server
+func NewHTTPSource(config *HTTPConfig) (Source, error) {
+	if config.URL == "" {
+		return nil, MissingHTTPURLErr
+	}
+	if config.Layer == "" {
+		return nil, MissingHTTPLayerErr
+	}
+	if err := checkHTTPURLTemplate(config.URL); err != nil {
+		return nil, err
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	cacheSize := config.CacheSize
+	if cacheSize <= 0 {
+		cacheSize = DefaultHTTPCacheSize
+	}
+	return &HTTPSource{
+		URL:          config.URL,
+		Layer:        config.Layer,
+		SourceFields: config.SourceFields,
+		client:       &http.Client{Timeout: timeout},
+		headers:      config.Headers,
+		retries:      config.Retries,
+		maxZoom:      config.MaxZoom,
+		tiles: &httpTileCache{
+			size:  cacheSize,
+			lru:   list.New(),
+			cache: make(map[string]*list.Element),
+		},
+	}, nil
+}
+
+// checkHTTPURLTemplate ensures that a URL template has the tile coordinate placeholders, and
+// is an absolute http(s) URL
+func checkHTTPURLTemplate(template string) error {
+	hasY := strings.Contains(template, "{y}") || strings.Contains(template, "{-y}")
+	if !strings.Contains(template, "{z}") || !strings.Contains(template, "{x}") || !hasY {
+		return InvalidHTTPURLErr
+	}
+	u, err := url.Parse(expandHTTPURLTemplate(template, maptile.New(0, 0, 0)))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return InvalidHTTPURLErr
+	}
+	return nil
+}
+
+// expandHTTPURLTemplate replaces the placeholders of a URL template with tile coordinates
+func expandHTTPURLTemplate(template string, tile maptile.Tile) string {
+	return strings.NewReplacer(
+		"{z}", strconv.Itoa(int(tile.Z)),
+		"{x}", strconv.FormatUint(uint64(tile.X), 10),
+		"{y}", strconv.FormatUint(uint64(tile.Y), 10),
+		"{-y}", strconv.FormatUint(uint64(flipY(tile)), 10),
+	).Replace(template)
+}
+
+// Fields implements the FieldsSource interface
+func (h *HTTPSource) Fields() []string {
+	return sourceFieldNames(h.SourceFields)
+}
+
+// retryableHTTPStatus checks whether a failed upstream request is worth retrying
+func retryableHTTPStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// fetch requests an upstream tile, revalidating the cached tile (if any). Note that a nil
+// tile is returned for missing tiles.
+func (h *HTTPSource) fetch(ctx context.Context, tileURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	cached, isCached := h.tiles.get(tileURL)
+	if isCached {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && isCached:
+		return cached.data, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, upstreamHTTPError{resp.StatusCode}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, httpMaxTileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > httpMaxTileSize {
+		return nil, fmt.Errorf("Upstream tile [%s] is larger than %d bytes", tileURL, httpMaxTileSize)
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag != "" || lastModified != "" {
+		h.tiles.put(&httpCachedTile{tileURL, etag, lastModified, data})
+	}
+	return data, nil
+}
+
+// upstreamHTTPError is the error for unexpected upstream response statuses
+type upstreamHTTPError struct {
+	status int
+}
+
+func (e upstreamHTTPError) Error() string {
+	return fmt.Sprintf("Upstream tile server responded with status %d", e.status)
+}
+
+// fetchWithRetries requests an upstream tile, retrying failed requests with an exponential
+// backoff. Note that client errors (other than rate limiting) are not retried.
+func (h *HTTPSource) fetchWithRetries(ctx context.Context, tileURL string) ([]byte, error) {
+	backoff := httpRetryBackoff
+	for attempt := 0; ; attempt++ {
+		data, err := h.fetch(ctx, tileURL)
+		if err == nil || attempt >= h.retries || ctx.Err() != nil {
+			return data, err
+		}
+		var statusErr upstreamHTTPError
+		if errors.As(err, &statusErr) && !retryableHTTPStatus(statusErr.status) {
+			return nil, err
+		}
+		Logger.Debugf("Retrying upstream tile [%s] after error: %v", tileURL, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// GetFeatures implements the Source interface, fetching the requested tile from the upstream
+// tile server and extracting the configured layer
+func (h *HTTPSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	fields, err := requestSourceFields(h.SourceFields, req)
+	if err != nil {
+		return nil, err
+	}
+	tile := req.MapTile()
+	// Over-zoomed tiles use the features of their ancestor, which are clipped by the pipeline
+	if h.maxZoom > 0 && req.Z > h.maxZoom {
+		shift := uint32(req.Z - h.maxZoom)
+		tile = maptile.New(tile.X>>shift, tile.Y>>shift, maptile.Zoom(h.maxZoom))
+	}
+	tileURL := expandHTTPURLTemplate(h.URL, tile)
+	data, err := h.fetchWithRetries(ctx, tileURL)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return geojson.NewFeatureCollection(), nil
+	}
+	fc, err := extractTileLayer(data, h.Layer, tile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode upstream tile [%s]: %w", tileURL, err)
+	}
+	if fields == nil {
+		return fc, nil
+	}
+	for i, f := range fc.Features {
+		fc.Features[i] = mapFeature(f, fields)
+	}
+	return fc, nil
+}
@@ -0,0 +1,143 @@
+package tilenol
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTileServer serves a single tile with an ETag at /tiles/2/2/1.mvt, and responds to the
+// requests of every other path with a fixed status
+type testTileServer struct {
+	*httptest.Server
+	requests    int32
+	conditional int32
+}
+
+func newTestTileServer(t *testing.T, status int) *testTileServer {
+	tile := encodeTestTile(t, maptile.New(2, 1, 2), orb.Point{10, 20}, "roads", "pois")
+	s := &testTileServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.requests, 1)
+		if r.URL.Path != "/tiles/2/2/1.mvt" {
+			w.WriteHeader(status)
+			return
+		}
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&s.conditional, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(tile)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestHTTPSource(t *testing.T) {
+	server := newTestTileServer(t, http.StatusNotFound)
+	source, err := NewHTTPSource(&HTTPConfig{
+		URL:          server.URL + "/tiles/{z}/{x}/{y}.mvt",
+		Layer:        "pois",
+		SourceFields: map[string]string{"kind": "layer"},
+		Headers:      map[string]string{"X-Api-Key": "secret"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "http", Layer{source: source}.SourceType())
+	assert.Equal(t, []string{"kind"}, source.(*HTTPSource).Fields())
+
+	for i := 0; i < 2; i++ {
+		fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 2, Y: 1, Z: 2})
+		assert.Nil(t, err)
+		assert.Len(t, fc.Features, 1)
+		assert.Equal(t, map[string]interface{}{"kind": "pois"}, map[string]interface{}(fc.Features[0].Properties))
+		point := fc.Features[0].Geometry.(orb.Point)
+		assert.InDelta(t, 10, point.X(), 0.01)
+		assert.InDelta(t, 20, point.Y(), 0.01)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.conditional), "Cached tiles should be revalidated")
+
+	// Extra source fields can be requested
+	req := &TileRequest{X: 2, Y: 1, Z: 2, Args: map[string][]string{"s": {"source:layer"}}}
+	fc, err := source.GetFeatures(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"kind": "pois", "source": "pois"}, map[string]interface{}(fc.Features[0].Properties))
+	req.Args["s"] = []string{"invalid"}
+	_, err = source.GetFeatures(context.Background(), req)
+	assert.IsType(t, InvalidRequestError{}, err)
+
+	fc, err = source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 2})
+	assert.Nil(t, err)
+	assert.Empty(t, fc.Features, "Missing tiles should return no features")
+}
+
+func TestHTTPSourceTemplates(t *testing.T) {
+	server := newTestTileServer(t, http.StatusNotFound)
+	tests := []struct {
+		config HTTPConfig
+		req    TileRequest
+	}{
+		{HTTPConfig{URL: server.URL + "/tiles/{z}/{x}/{-y}.mvt"}, TileRequest{X: 2, Y: 2, Z: 2}},
+		{HTTPConfig{URL: server.URL + "/tiles/{z}/{x}/{y}.mvt", MaxZoom: 2}, TileRequest{X: 10, Y: 5, Z: 4}},
+	}
+	for _, test := range tests {
+		test.config.Layer = "roads"
+		test.config.Headers = map[string]string{"X-Api-Key": "secret"}
+		source, err := NewHTTPSource(&test.config)
+		assert.Nil(t, err)
+		fc, err := source.GetFeatures(context.Background(), &test.req)
+		assert.Nil(t, err)
+		assert.Len(t, fc.Features, 1)
+		assert.Equal(t, "roads", fc.Features[0].Properties["layer"], "All properties should be kept")
+	}
+}
+
+func TestHTTPSourceRetries(t *testing.T) {
+	tests := []struct {
+		status   int
+		retries  int
+		expected int32
+	}{
+		{http.StatusServiceUnavailable, 2, 3},
+		{http.StatusTooManyRequests, 1, 2},
+		{http.StatusForbidden, 2, 1},
+		{http.StatusInternalServerError, 0, 1},
+	}
+	for _, test := range tests {
+		server := newTestTileServer(t, test.status)
+		source, err := NewHTTPSource(&HTTPConfig{
+			URL:     server.URL + "/{z}/{x}/{y}",
+			Layer:   "roads",
+			Retries: test.retries,
+		})
+		assert.Nil(t, err)
+		_, err = source.GetFeatures(context.Background(), &TileRequest{X: 0, Y: 0, Z: 0})
+		assert.Equal(t, upstreamHTTPError{test.status}, err)
+		assert.Equal(t, test.expected, atomic.LoadInt32(&server.requests))
+	}
+}
+
+func TestHTTPSourceConfig(t *testing.T) {
+	tests := []struct {
+		config   HTTPConfig
+		expected error
+	}{
+		{HTTPConfig{Layer: "a"}, MissingHTTPURLErr},
+		{HTTPConfig{URL: "http://example.com/{z}/{x}/{y}"}, MissingHTTPLayerErr},
+		{HTTPConfig{URL: "http://example.com/{z}/{x}", Layer: "a"}, InvalidHTTPURLErr},
+		{HTTPConfig{URL: "file:///tiles/{z}/{x}/{y}", Layer: "a"}, InvalidHTTPURLErr},
+		{HTTPConfig{URL: "/tiles/{z}/{x}/{y}", Layer: "a"}, InvalidHTTPURLErr},
+	}
+	for _, test := range tests {
+		_, err := NewHTTPSource(&test.config)
+		assert.Equal(t, test.expected, err)
+	}
+}
@@ -1,32 +1,176 @@
 package tilenol
 
-// InMemoryCache implements the Cache interface backed by an in-memory map
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb/maptile"
+)
+
+const (
+	// DefaultInMemoryMaxBytes is the default memory budget for an InMemoryCache
+	DefaultInMemoryMaxBytes = 256 * 1024 * 1024
+)
+
+// InMemoryConfig is the YAML configuration for an InMemoryCache
+type InMemoryConfig struct {
+	// MaxBytes is the approximate memory budget of the cache, after which the least recently
+	// used entries are evicted
+	MaxBytes int64 `yaml:"maxBytes"`
+	// TTL is how long each cache entry should remain before refresh (optional)
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// inMemoryEntry is a single cached value, tracked in the LRU list
+type inMemoryEntry struct {
+	key       string
+	val       []byte
+	expiresAt time.Time
+}
+
+// size approximates the number of bytes used by the entry
+func (e *inMemoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.val))
+}
+
+// expired checks whether or not the entry's TTL has elapsed
+func (e *inMemoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// InMemoryCache implements the Cache interface backed by a concurrency-safe, size-bounded
+// in-memory LRU map
 type InMemoryCache struct {
-	cache map[string][]byte
+	// MaxBytes is the approximate memory budget of the cache
+	MaxBytes int64
+	// TTL is how long each cache entry should remain before refresh
+	TTL time.Duration
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	cache map[string]*list.Element
 }
 
-// NewInMemoryCache allocates a new InMemoryCache
+// NewInMemoryCache allocates a new InMemoryCache with the default memory budget and no TTL
 func NewInMemoryCache() Cache {
-	return &InMemoryCache{make(map[string][]byte)}
+	cache, _ := NewInMemoryCacheFromConfig(&InMemoryConfig{})
+	return cache
+}
+
+// NewInMemoryCacheFromConfig allocates a new InMemoryCache given an InMemoryConfig
+func NewInMemoryCacheFromConfig(config *InMemoryConfig) (Cache, error) {
+	maxBytes := config.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultInMemoryMaxBytes
+	}
+	return &InMemoryCache{
+		MaxBytes: maxBytes,
+		TTL:      config.TTL,
+		lru:      list.New(),
+		cache:    make(map[string]*list.Element),
+	}, nil
+}
+
+// lookup finds the live entry for a given key, removing it if it has expired. Note that the
+// caller must hold the lock.
+func (i *InMemoryCache) lookup(key string) (*list.Element, bool) {
+	elem, exists := i.cache[key]
+	if !exists {
+		return nil, false
+	}
+	if elem.Value.(*inMemoryEntry).expired(time.Now()) {
+		i.remove(elem)
+		return nil, false
+	}
+	return elem, true
+}
+
+// remove evicts an entry from the cache. Note that the caller must hold the lock.
+func (i *InMemoryCache) remove(elem *list.Element) {
+	entry := i.lru.Remove(elem).(*inMemoryEntry)
+	delete(i.cache, entry.key)
+	i.size -= entry.size()
 }
 
 // Exists checks the internal map for the existence of the key
 func (i *InMemoryCache) Exists(key string) bool {
-	_, exists := i.cache[key]
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	_, exists := i.lookup(key)
 	return exists
 }
 
-// Get retrieves the value stored in the internal map
+// Get retrieves the value stored in the internal map, marking it as recently used
 func (i *InMemoryCache) Get(key string) ([]byte, error) {
-	v, exists := i.cache[key]
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	elem, exists := i.lookup(key)
 	if !exists {
 		return nil, ErrNoValue
 	}
-	return v, nil
+	i.lru.MoveToFront(elem)
+	return elem.Value.(*inMemoryEntry).val, nil
 }
 
-// Put stores a new value in the internal map at a given key
+// Put stores a new value in the internal map at a given key, evicting the least recently
+// used entries if the cache exceeds its memory budget
 func (i *InMemoryCache) Put(key string, val []byte) error {
-	i.cache[key] = val
+	entry := &inMemoryEntry{key: key, val: val}
+	if i.TTL > 0 {
+		entry.expiresAt = time.Now().Add(i.TTL)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if elem, exists := i.cache[key]; exists {
+		i.remove(elem)
+	}
+	// Values that could never fit in the cache are simply not stored
+	if entry.size() > i.MaxBytes {
+		return nil
+	}
+	i.cache[key] = i.lru.PushFront(entry)
+	i.size += entry.size()
+	for i.size > i.MaxBytes {
+		i.remove(i.lru.Back())
+	}
 	return nil
 }
+
+// Delete removes the value stored in the internal map at a given key
+func (i *InMemoryCache) Delete(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if elem, exists := i.cache[key]; exists {
+		i.remove(elem)
+	}
+	return nil
+}
+
+// PurgeTiles implements the TilePurger interface by scanning the keys of the layer
+func (i *InMemoryCache) PurgeTiles(layerKey string, match func(maptile.Tile) bool) (uint64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var count uint64
+	for key, elem := range i.cache {
+		tilePath, isLayerKey := strings.CutPrefix(key, layerKey+"/")
+		if !isLayerKey {
+			continue
+		}
+		if tile, ok := parseTilePath(tilePath); ok && match(tile) {
+			i.remove(elem)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Len returns the number of entries currently stored in the cache
+func (i *InMemoryCache) Len() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.lru.Len()
+}
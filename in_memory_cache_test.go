@@ -0,0 +1,64 @@
+package tilenol
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryCacheLRUEviction(t *testing.T) {
+	cache, _ := NewInMemoryCacheFromConfig(&InMemoryConfig{MaxBytes: 30})
+	cache.Put("a", make([]byte, 9))
+	cache.Put("b", make([]byte, 9))
+	cache.Put("c", make([]byte, 9))
+
+	// Touch "a" so that "b" becomes the least recently used entry
+	_, err := cache.Get("a")
+	assert.Nil(t, err)
+
+	cache.Put("d", make([]byte, 9))
+	assert.True(t, cache.Exists("a"))
+	assert.False(t, cache.Exists("b"), "Least recently used entry should have been evicted")
+	assert.True(t, cache.Exists("c"))
+	assert.True(t, cache.Exists("d"))
+	assert.Equal(t, 3, cache.(*InMemoryCache).Len())
+}
+
+func TestInMemoryCacheOversizedValue(t *testing.T) {
+	cache, _ := NewInMemoryCacheFromConfig(&InMemoryConfig{MaxBytes: 10})
+	err := cache.Put("a", make([]byte, 100))
+	assert.Nil(t, err)
+	assert.False(t, cache.Exists("a"))
+}
+
+func TestInMemoryCacheTTL(t *testing.T) {
+	cache, _ := NewInMemoryCacheFromConfig(&InMemoryConfig{TTL: 10 * time.Millisecond})
+	cache.Put("a", []byte("a"))
+	assert.True(t, cache.Exists("a"))
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, cache.Exists("a"))
+	_, err := cache.Get("a")
+	assert.Equal(t, ErrNoValue, err)
+}
+
+func TestInMemoryCacheConcurrency(t *testing.T) {
+	cache, _ := NewInMemoryCacheFromConfig(&InMemoryConfig{MaxBytes: 512})
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("%d/%d", i, j%10)
+				cache.Put(key, []byte(key))
+				cache.Exists(key)
+				cache.Get(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.True(t, cache.(*InMemoryCache).size <= 512)
+}
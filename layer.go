@@ -7,15 +7,20 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"io"
+	"sort"
 
+	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
 )
 
 var (
-	MultipleSourcesErr         = errors.New("Layers can only support a single backend source")
+	MultipleSourcesErr         = errors.New("Layers can only support a single backend source, use \"composite\" to combine several sources")
 	NoSourcesErr               = errors.New("Layers must have a single backend source configured")
 	LayerMinZoomOutOfBoundsErr = errors.New("Layer Min zoom is below absolute min zoom")
 	LayerMaxZoomOutOfBoundsErr = errors.New("Layer Max Zoom is above absolute max zoom")
+	InvalidLayerBoundsErr      = errors.New("Layer bounds must be given as [minLon, minLat, maxLon, maxLat]")
+	ReservedLayerNameErr       = errors.New("Layer names \"layers\" and \"_all\" are reserved by the tile server endpoints")
 )
 
 // SourceConfig represents a generic YAML source configuration object
@@ -24,6 +29,24 @@ type SourceConfig struct {
 	Elasticsearch *ElasticsearchConfig `yaml:"elasticsearch"`
 	// PostGIS is an optional YAML key for configuring a PostGISConfig
 	PostGIS *PostGISConfig `yaml:"postgis"`
+	// MBTiles is an optional YAML key for configuring an MBTilesConfig
+	MBTiles *MBTilesConfig `yaml:"mbtiles"`
+	// PMTiles is an optional YAML key for configuring a PMTilesConfig
+	PMTiles *PMTilesConfig `yaml:"pmtiles"`
+	// GeoJSON is an optional YAML key for configuring a GeoJSONConfig
+	GeoJSON *GeoJSONConfig `yaml:"geojson"`
+	// Shapefile is an optional YAML key for configuring a ShapefileConfig
+	Shapefile *ShapefileConfig `yaml:"shapefile"`
+	// FlatGeobuf is an optional YAML key for configuring a FlatGeobufConfig
+	FlatGeobuf *FlatGeobufConfig `yaml:"flatgeobuf"`
+	// GeoPackage is an optional YAML key for configuring a GeoPackageConfig
+	GeoPackage *GeoPackageConfig `yaml:"geopackage"`
+	// CSV is an optional YAML key for configuring a CSVConfig
+	CSV *CSVConfig `yaml:"csv"`
+	// HTTP is an optional YAML key for configuring an HTTPConfig
+	HTTP *HTTPConfig `yaml:"http"`
+	// Composite is an optional YAML key for configuring a CompositeConfig
+	Composite *CompositeConfig `yaml:"composite"`
 }
 
 // LayerConfig represents a general YAML layer configuration object
@@ -38,6 +61,9 @@ type LayerConfig struct {
 	Maxzoom int `yaml:"maxzoom"`
 	// NoCache indicates that this layer should not cache its source data
 	NoCache bool `yaml:"nocache"`
+	// Bounds optionally specifies the area covered by the layer as [minLon, minLat, maxLon,
+	// maxLat], which is advertised to clients in TileJSON documents
+	Bounds []float64 `yaml:"bounds"`
 	// Source configures the underlying Source for the layer
 	Source SourceConfig `yaml:"source"`
 }
@@ -48,6 +74,20 @@ type Source interface {
 	GetFeatures(context.Context, *TileRequest) (*geojson.FeatureCollection, error)
 }
 
+// FieldsSource is an optional interface for sources that can describe the feature
+// properties they produce
+type FieldsSource interface {
+	// Fields returns the names of the feature properties produced by the source
+	Fields() []string
+}
+
+// VersionedSource is an optional interface for sources whose data can change while the server
+// is running (e.g. reloaded files), so that the version of their layers changes along with it
+type VersionedSource interface {
+	// Generation returns a number that changes whenever the data of the source changes
+	Generation() uint64
+}
+
 // Layer is a configured, hydrated tile server layer
 type Layer struct {
 	Name        string
@@ -55,9 +95,90 @@ type Layer struct {
 	Minzoom     int
 	Maxzoom     int
 	Cacheable   bool
+	Bounds      *orb.Bound
 	source      Source // Note that source is not exported to avoid encoding issues
 }
 
+// count returns the number of backend sources configured
+func (c *SourceConfig) count() int {
+	var n int
+	if c.Elasticsearch != nil {
+		n++
+	}
+	if c.PostGIS != nil {
+		n++
+	}
+	if c.MBTiles != nil {
+		n++
+	}
+	if c.PMTiles != nil {
+		n++
+	}
+	if c.GeoJSON != nil {
+		n++
+	}
+	if c.Shapefile != nil {
+		n++
+	}
+	if c.FlatGeobuf != nil {
+		n++
+	}
+	if c.GeoPackage != nil {
+		n++
+	}
+	if c.CSV != nil {
+		n++
+	}
+	if c.HTTP != nil {
+		n++
+	}
+	if c.Composite != nil {
+		n++
+	}
+	return n
+}
+
+// CreateSource creates a new Source given a SourceConfig
+func CreateSource(config *SourceConfig) (Source, error) {
+	if config.count() > 1 {
+		return nil, MultipleSourcesErr
+	}
+	if config.Elasticsearch != nil {
+		return NewElasticsearchSource(config.Elasticsearch)
+	}
+	if config.PostGIS != nil {
+		return NewPostGISSource(config.PostGIS)
+	}
+	if config.MBTiles != nil {
+		return NewMBTilesSource(config.MBTiles)
+	}
+	if config.PMTiles != nil {
+		return NewPMTilesSource(config.PMTiles)
+	}
+	if config.GeoJSON != nil {
+		return NewGeoJSONSource(config.GeoJSON)
+	}
+	if config.Shapefile != nil {
+		return NewShapefileSource(config.Shapefile)
+	}
+	if config.FlatGeobuf != nil {
+		return NewFlatGeobufSource(config.FlatGeobuf)
+	}
+	if config.GeoPackage != nil {
+		return NewGeoPackageSource(config.GeoPackage)
+	}
+	if config.CSV != nil {
+		return NewCSVSource(config.CSV)
+	}
+	if config.HTTP != nil {
+		return NewHTTPSource(config.HTTP)
+	}
+	if config.Composite != nil {
+		return NewCompositeSource(config.Composite)
+	}
+	return nil, NoSourcesErr
+}
+
 // CreateLayer creates a new Layer given a LayerConfig
 func CreateLayer(layerConfig LayerConfig) (*Layer, error) {
 	layer := &Layer{
@@ -67,11 +188,14 @@ func CreateLayer(layerConfig LayerConfig) (*Layer, error) {
 		Maxzoom:     layerConfig.Maxzoom,
 		Cacheable:   !layerConfig.NoCache,
 	}
-	// TODO: How can we make this more generic?
-	if layerConfig.Source.Elasticsearch != nil && layerConfig.Source.PostGIS != nil {
-		return nil, MultipleSourcesErr
+	// Note: a layer named after the catalog endpoints (or the special all layers parameter)
+	// could never be requested on its own
+	if layerConfig.Name == CatalogPath || layerConfig.Name == AllLayers {
+		return nil, ReservedLayerNameErr
 	}
-	if layerConfig.Source.Elasticsearch == nil && layerConfig.Source.PostGIS == nil {
+	if n := layerConfig.Source.count(); n > 1 {
+		return nil, MultipleSourcesErr
+	} else if n == 0 {
 		return nil, NoSourcesErr
 	}
 	if layerConfig.Minzoom < MinZoom {
@@ -80,23 +204,19 @@ func CreateLayer(layerConfig LayerConfig) (*Layer, error) {
 	if layerConfig.Maxzoom > MaxZoom {
 		return nil, LayerMaxZoomOutOfBoundsErr
 	}
-	if layerConfig.Source.Elasticsearch != nil {
-		source, err := NewElasticsearchSource(layerConfig.Source.Elasticsearch)
-		if err != nil {
-			return nil, err
+	if layerConfig.Bounds != nil {
+		bounds := layerConfig.Bounds
+		if len(bounds) != 4 || bounds[0] > bounds[2] || bounds[1] > bounds[3] {
+			return nil, InvalidLayerBoundsErr
 		}
-		layer.source = source
-		return layer, nil
+		layer.Bounds = &orb.Bound{Min: orb.Point{bounds[0], bounds[1]}, Max: orb.Point{bounds[2], bounds[3]}}
 	}
-	if layerConfig.Source.PostGIS != nil {
-		source, err := NewPostGISSource(layerConfig.Source.PostGIS)
-		if err != nil {
-			return nil, err
-		}
-		layer.source = source
-		return layer, nil
+	source, err := CreateSource(&layerConfig.Source)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("Invalid layer source config for layer: %s", layerConfig.Name)
+	layer.source = source
+	return layer, nil
 }
 
 // GetFeatures implements a passthrough interface to the layer's underlying source
@@ -104,7 +224,61 @@ func (l Layer) GetFeatures(ctx context.Context, r *TileRequest) (*geojson.Featur
 	return l.source.GetFeatures(ctx, r)
 }
 
-// Hash computes a content-based SHA256 digest to diff layer "versions"
+// Close closes the layer's underlying source, if the source holds any resources
+func (l Layer) Close() error {
+	if closer, ok := l.source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Fields returns the sorted names of the feature properties produced by the layer's
+// underlying source, if the source is able to describe them
+func (l Layer) Fields() []string {
+	fs, ok := l.source.(FieldsSource)
+	if !ok {
+		return nil
+	}
+	fields := append([]string{}, fs.Fields()...)
+	sort.Strings(fields)
+	return fields
+}
+
+// SourceType returns a short descriptor of the kind of backend source used by the layer
+func (l Layer) SourceType() string {
+	switch l.source.(type) {
+	case *ElasticsearchSource:
+		return "elasticsearch"
+	case *PostGISSource:
+		return "postgis"
+	case *MBTilesSource:
+		return "mbtiles"
+	case *PMTilesSource:
+		return "pmtiles"
+	case *GeoJSONSource:
+		return "geojson"
+	case *ShapefileSource:
+		return "shapefile"
+	case *FlatGeobufSource:
+		return "flatgeobuf"
+	case *GeoPackageSource:
+		return "geopackage"
+	case *CSVSource:
+		return "csv"
+	case *HTTPSource:
+		return "http"
+	case *CompositeSource:
+		return "composite"
+	case *NilSource:
+		return "nil"
+	default:
+		return "unknown"
+	}
+}
+
+// Hash computes a content-based SHA256 digest to diff layer "versions". Note that the hash of
+// a layer whose source implements VersionedSource changes along with the source data, so that
+// previously cached tiles are no longer served once the data changes.
 func (l Layer) Hash() string {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -114,6 +288,9 @@ func (l Layer) Hash() string {
 	}
 	hash := sha256.New()
 	hash.Write(buf.Bytes())
+	if vs, ok := l.source.(VersionedSource); ok {
+		fmt.Fprintf(hash, "%d", vs.Generation())
+	}
 	hashBytes := hash.Sum(nil)
 	return fmt.Sprintf("%x", hashBytes)
 }
@@ -3,6 +3,7 @@ package tilenol
 import (
 	"testing"
 
+	"github.com/paulmach/orb"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -62,3 +63,34 @@ func TestCreateLayerMaxZoomOutOfBounds(t *testing.T) {
 	_, err := CreateLayer(config)
 	assert.Equal(t, LayerMaxZoomOutOfBoundsErr, err, "Expected to fail because layer max zoom is greater than absolute allowed max")
 }
+
+func TestCreateLayerBounds(t *testing.T) {
+	config := LayerConfig{
+		Bounds: []float64{-10, -5, 10, 5},
+		Source: SourceConfig{
+			Elasticsearch: new(ElasticsearchConfig),
+		},
+	}
+	layer, err := CreateLayer(config)
+	assert.Nil(t, err)
+	assert.Equal(t, &orb.Bound{Min: orb.Point{-10, -5}, Max: orb.Point{10, 5}}, layer.Bounds)
+
+	for _, bounds := range [][]float64{{-10, -5, 10}, {10, -5, -10, 5}} {
+		config.Bounds = bounds
+		_, err = CreateLayer(config)
+		assert.Equal(t, InvalidLayerBoundsErr, err, "Expected to fail due to invalid bounds %v", bounds)
+	}
+}
+
+func TestCreateLayerReservedNames(t *testing.T) {
+	for _, name := range []string{CatalogPath, AllLayers} {
+		config := LayerConfig{
+			Name: name,
+			Source: SourceConfig{
+				Elasticsearch: new(ElasticsearchConfig),
+			},
+		}
+		_, err := CreateLayer(config)
+		assert.Equal(t, ReservedLayerNameErr, err, "Expected to fail due to reserved layer name [%s]", name)
+	}
+}
@@ -0,0 +1,153 @@
+package tilenol
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/paulmach/orb/maptile"
+	// Register the pure-Go SQLite driver
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// MBTilesBatchSize is the number of tiles written per MBTiles transaction
+	MBTilesBatchSize = 1000
+)
+
+var (
+	// ArchiveExistsErr is the error returned when exporting to an existing archive file
+	ArchiveExistsErr = errors.New("Archive file already exists")
+)
+
+// mbtilesSchema is the schema of an MBTiles 1.3 archive
+const mbtilesSchema = `
+CREATE TABLE metadata (name TEXT, value TEXT);
+CREATE UNIQUE INDEX name ON metadata (name);
+CREATE TABLE tiles (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB);
+CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row);
+`
+
+// MBTilesWriter implements the TileWriter interface for MBTiles archives, i.e. SQLite
+// databases following the MBTiles 1.3 specification
+type MBTilesWriter struct {
+	// Path is the path of the MBTiles file
+	Path string
+
+	db      *sql.DB
+	tx      *sql.Tx
+	pending int
+}
+
+// NewMBTilesWriter creates a new MBTiles archive at the given path
+func NewMBTilesWriter(path string) (*MBTilesWriter, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%w: %s", ArchiveExistsErr, path)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// Note: SQLite only supports a single writer
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(mbtilesSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &MBTilesWriter{Path: path, db: db}, nil
+}
+
+// flipY converts an XYZ tile row to a TMS tile row, as used by MBTiles
+func flipY(t maptile.Tile) uint32 {
+	return (uint32(1) << uint32(t.Z)) - 1 - t.Y
+}
+
+// commit commits the pending tile writes
+func (m *MBTilesWriter) commit() error {
+	if m.tx == nil {
+		return nil
+	}
+	err := m.tx.Commit()
+	m.tx, m.pending = nil, 0
+	return err
+}
+
+// WriteTile stores the gzipped vector tile data for a single tile, batching writes into
+// transactions
+func (m *MBTilesWriter) WriteTile(t maptile.Tile, data []byte) error {
+	if m.tx == nil {
+		tx, err := m.db.Begin()
+		if err != nil {
+			return err
+		}
+		m.tx = tx
+	}
+	_, err := m.tx.Exec(
+		"INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)",
+		t.Z, t.X, flipY(t), data)
+	if err != nil {
+		return err
+	}
+	m.pending++
+	if m.pending >= MBTilesBatchSize {
+		return m.commit()
+	}
+	return nil
+}
+
+// Finalize commits any pending tiles and stores the archive metadata
+func (m *MBTilesWriter) Finalize(metadata *TileJSON) error {
+	if err := m.commit(); err != nil {
+		return err
+	}
+	vectorLayers, err := json.Marshal(map[string]interface{}{
+		"vector_layers": metadata.VectorLayers,
+	})
+	if err != nil {
+		return err
+	}
+	center := metadata.center()
+	values := map[string]string{
+		"name":        metadata.Name,
+		"format":      "pbf",
+		"type":        "overlay",
+		"version":     "2",
+		"description": metadata.Description,
+		"bounds": fmt.Sprintf("%s,%s,%s,%s",
+			formatCoord(metadata.Bounds[0]), formatCoord(metadata.Bounds[1]),
+			formatCoord(metadata.Bounds[2]), formatCoord(metadata.Bounds[3])),
+		"center":  fmt.Sprintf("%s,%s,%d", formatCoord(center[0]), formatCoord(center[1]), metadata.Minzoom),
+		"minzoom": strconv.Itoa(metadata.Minzoom),
+		"maxzoom": strconv.Itoa(metadata.Maxzoom),
+		"json":    string(vectorLayers),
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO metadata (name, value) VALUES (?, ?)", name, value); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// formatCoord formats a coordinate with the minimum number of digits needed
+func formatCoord(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Close closes the underlying SQLite database, discarding any uncommitted tiles
+func (m *MBTilesWriter) Close() error {
+	if m.tx != nil {
+		m.tx.Rollback()
+		m.tx = nil
+	}
+	return m.db.Close()
+}
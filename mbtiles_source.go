@@ -0,0 +1,147 @@
+package tilenol
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+var (
+	// MissingMBTilesPathErr is the error returned when an MBTiles source has no file path
+	MissingMBTilesPathErr = errors.New("MBTiles source requires a \"path\"")
+	// MissingMBTilesLayerErr is the error returned when an MBTiles source has no layer name
+	MissingMBTilesLayerErr = errors.New("MBTiles source requires a \"layer\"")
+)
+
+// gzipMagic is the magic number at the start of gzipped data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// MBTilesConfig is the YAML configuration structure for configuring a new MBTilesSource
+type MBTilesConfig struct {
+	// Path is the path to the MBTiles file
+	Path string `yaml:"path"`
+	// Layer is the name of the vector tile layer to extract from the stored tiles
+	Layer string `yaml:"layer"`
+}
+
+// MBTilesSource is a Source implementation that retrieves feature data from the
+// pre-rendered vector tiles of an MBTiles file
+type MBTilesSource struct {
+	// DB is the read-only connection to the MBTiles SQLite database
+	DB *sql.DB
+	// Layer is the name of the vector tile layer to extract from the stored tiles
+	Layer string
+}
+
+// NewMBTilesSource creates a new Source that retrieves feature data from an MBTiles file
+func NewMBTilesSource(config *MBTilesConfig) (Source, error) {
+	if config.Path == "" {
+		return nil, MissingMBTilesPathErr
+	}
+	if config.Layer == "" {
+		return nil, MissingMBTilesLayerErr
+	}
+	db, err := openSQLiteReadOnly(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &MBTilesSource{DB: db, Layer: config.Layer}, nil
+}
+
+// openSQLiteReadOnly opens a read-only connection to a SQLite database file, checking that
+// the file exists and is readable
+func openSQLiteReadOnly(path string) (*sql.DB, error) {
+	dsn := (&url.URL{Scheme: "file", Opaque: url.PathEscape(path), RawQuery: "mode=ro"}).String()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckPing(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Close closes the underlying SQLite database
+func (m *MBTilesSource) Close() error {
+	return m.DB.Close()
+}
+
+// HealthCheck implements the HealthChecker interface by pinging the database
+func (m *MBTilesSource) HealthCheck(ctx context.Context) error {
+	return CheckPingContext(ctx, m.DB)
+}
+
+// Fields implements the FieldsSource interface, using the vector_layers metadata of the
+// MBTiles file (if any)
+func (m *MBTilesSource) Fields() []string {
+	var raw string
+	if err := m.DB.QueryRow("SELECT value FROM metadata WHERE name = 'json'").Scan(&raw); err != nil {
+		return nil
+	}
+	var doc struct {
+		VectorLayers []VectorLayer `json:"vector_layers"`
+	}
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return nil
+	}
+	for _, layer := range doc.VectorLayers {
+		if layer.ID != m.Layer {
+			continue
+		}
+		var fields []string
+		for field := range layer.Fields {
+			fields = append(fields, field)
+		}
+		return fields
+	}
+	return nil
+}
+
+// extractTileLayer decodes vector tile data (gzipped or not) and extracts the features of the
+// named layer, projected back to WGS84
+func extractTileLayer(data []byte, name string, tile maptile.Tile) (*geojson.FeatureCollection, error) {
+	var layers mvt.Layers
+	var err error
+	if bytes.HasPrefix(data, gzipMagic) {
+		layers, err = mvt.UnmarshalGzipped(data)
+	} else {
+		layers, err = mvt.Unmarshal(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fc := geojson.NewFeatureCollection()
+	for _, layer := range layers {
+		if layer.Name != name {
+			continue
+		}
+		layer.ProjectToWGS84(tile)
+		fc.Features = append(fc.Features, layer.Features...)
+	}
+	return fc, nil
+}
+
+// GetFeatures implements the Source interface, extracting the configured layer from the stored
+// tile (if any). Note that MBTiles files store tile rows in the TMS scheme.
+func (m *MBTilesSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	tile := req.MapTile()
+	var data []byte
+	err := m.DB.QueryRowContext(ctx,
+		"SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?",
+		tile.Z, tile.X, flipY(tile)).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return geojson.NewFeatureCollection(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return extractTileLayer(data, m.Layer, tile)
+}
@@ -0,0 +1,75 @@
+package tilenol
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+// encodeTestTile encodes a gzipped vector tile with a single point feature per layer name
+func encodeTestTile(t *testing.T, tile maptile.Tile, point orb.Point, names ...string) []byte {
+	var layers mvt.Layers
+	for _, name := range names {
+		fc := geojson.NewFeatureCollection()
+		f := geojson.NewFeature(point)
+		f.Properties["layer"] = name
+		fc.Append(f)
+		layer := mvt.NewLayer(name, fc)
+		layer.ProjectToTile(tile)
+		layers = append(layers, layer)
+	}
+	data, err := mvt.MarshalGzipped(layers)
+	if err != nil {
+		t.Fatalf("Could not encode tile: %v", err)
+	}
+	return data
+}
+
+func TestMBTilesSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy tiles.mbtiles")
+	tile := maptile.New(2, 1, 2)
+	w, err := NewMBTilesWriter(path)
+	assert.Nil(t, err)
+	assert.Nil(t, w.WriteTile(tile, encodeTestTile(t, tile, orb.Point{1, 1}, "roads", "buildings")))
+	assert.Nil(t, w.Finalize(&TileJSON{
+		Name: "legacy",
+		VectorLayers: []VectorLayer{
+			VectorLayer{ID: "buildings", Fields: map[string]string{"layer": "String"}},
+		},
+	}))
+	assert.Nil(t, w.Close())
+
+	source, err := NewMBTilesSource(&MBTilesConfig{Path: path, Layer: "buildings"})
+	assert.Nil(t, err)
+	defer source.(*MBTilesSource).Close()
+	assert.Equal(t, "mbtiles", Layer{source: source}.SourceType())
+	assert.Equal(t, []string{"layer"}, source.(*MBTilesSource).Fields())
+	assert.Nil(t, source.(*MBTilesSource).HealthCheck(context.Background()))
+
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 2, Y: 1, Z: 2})
+	assert.Nil(t, err)
+	assert.Len(t, fc.Features, 1)
+	assert.Equal(t, "buildings", fc.Features[0].Properties["layer"])
+	point := fc.Features[0].Geometry.(orb.Point)
+	assert.InDelta(t, 1, point.X(), 0.01)
+	assert.InDelta(t, 1, point.Y(), 0.01)
+
+	fc, err = source.GetFeatures(context.Background(), &TileRequest{X: 2, Y: 2, Z: 2})
+	assert.Nil(t, err)
+	assert.Empty(t, fc.Features, "Missing tiles should return no features")
+}
+
+func TestMBTilesSourceConfig(t *testing.T) {
+	_, err := NewMBTilesSource(&MBTilesConfig{Layer: "a"})
+	assert.Equal(t, MissingMBTilesPathErr, err)
+	_, err = NewMBTilesSource(&MBTilesConfig{Path: "a.mbtiles"})
+	assert.Equal(t, MissingMBTilesLayerErr, err)
+	_, err = NewMBTilesSource(&MBTilesConfig{Path: filepath.Join(t.TempDir(), "missing.mbtiles"), Layer: "a"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,76 @@
+package tilenol
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMBTilesWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mbtiles")
+	w, err := NewMBTilesWriter(path)
+	assert.Nil(t, err)
+
+	assert.Nil(t, w.WriteTile(maptile.New(1, 0, 1), []byte("tile-1-1-0")))
+	assert.Nil(t, w.WriteTile(maptile.New(3, 1, 2), []byte("tile-2-3-1")))
+	assert.Nil(t, w.Finalize(&TileJSON{
+		Name:    "test",
+		Minzoom: 1,
+		Maxzoom: 2,
+		Bounds:  [4]float64{-10, -5, 10, 5},
+		VectorLayers: []VectorLayer{
+			VectorLayer{ID: "a", Minzoom: 1, Maxzoom: 2, Fields: map[string]string{"name": ""}},
+		},
+	}))
+	assert.Nil(t, w.Close())
+
+	db, err := sql.Open("sqlite", path)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// Tile rows are stored in the TMS scheme
+	var data []byte
+	err = db.QueryRow("SELECT tile_data FROM tiles WHERE zoom_level = 1 AND tile_column = 1 AND tile_row = 1").Scan(&data)
+	assert.Nil(t, err)
+	assert.Equal(t, "tile-1-1-0", string(data))
+	err = db.QueryRow("SELECT tile_data FROM tiles WHERE zoom_level = 2 AND tile_column = 3 AND tile_row = 2").Scan(&data)
+	assert.Nil(t, err)
+	assert.Equal(t, "tile-2-3-1", string(data))
+
+	metadata := make(map[string]string)
+	rows, err := db.Query("SELECT name, value FROM metadata")
+	assert.Nil(t, err)
+	for rows.Next() {
+		var name, value string
+		assert.Nil(t, rows.Scan(&name, &value))
+		metadata[name] = value
+	}
+	assert.Equal(t, "test", metadata["name"])
+	assert.Equal(t, "pbf", metadata["format"])
+	assert.Equal(t, "-10,-5,10,5", metadata["bounds"])
+	assert.Equal(t, "0,0,1", metadata["center"])
+	assert.Equal(t, "1", metadata["minzoom"])
+	assert.Equal(t, "2", metadata["maxzoom"])
+
+	var doc struct {
+		VectorLayers []VectorLayer `json:"vector_layers"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(metadata["json"]), &doc))
+	assert.Len(t, doc.VectorLayers, 1)
+	assert.Equal(t, "a", doc.VectorLayers[0].ID)
+}
+
+func TestMBTilesWriterExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mbtiles")
+	w, err := NewMBTilesWriter(path)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	_, err = NewMBTilesWriter(path)
+	assert.True(t, errors.Is(err, ArchiveExistsErr))
+}
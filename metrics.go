@@ -0,0 +1,127 @@
+package tilenol
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// MetricsNamespace is the Prometheus namespace for all tilenol metrics
+	MetricsNamespace = "tilenol"
+)
+
+// Metrics is the collection of Prometheus metrics tracked by a tilenol server
+type Metrics struct {
+	// Registry is the Prometheus registry that all of the server metrics are registered to
+	Registry *prometheus.Registry
+	// LayerRequests counts the number of layer data requests per layer
+	LayerRequests *prometheus.CounterVec
+	// SourceLatency tracks the time spent retrieving features from each layer's source
+	SourceLatency *prometheus.HistogramVec
+	// SourceErrors counts the number of failed source requests per layer
+	SourceErrors *prometheus.CounterVec
+	// CacheLookups counts cache hits and misses per layer
+	CacheLookups *prometheus.CounterVec
+	// CacheErrors counts failed cache operations per layer
+	CacheErrors *prometheus.CounterVec
+	// CoalescedRequests counts layer data requests that were deduplicated into an identical
+	// in-flight computation
+	CoalescedRequests *prometheus.CounterVec
+	// StaleResponses counts stale cache entries served per layer, by reason (revalidate or
+	// error)
+	StaleResponses *prometheus.CounterVec
+	// TileSize tracks the size in bytes of the tiles served
+	TileSize prometheus.Histogram
+	// TileFeatures tracks the number of features per layer in the tiles served
+	TileFeatures *prometheus.HistogramVec
+	// InFlight tracks the number of tile server requests currently being served
+	InFlight prometheus.Gauge
+}
+
+// NewMetrics creates a new set of server metrics registered to their own registry
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		LayerRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "layer_requests_total",
+			Help:      "Number of layer data requests",
+		}, []string{"layer"}),
+		SourceLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      "source_duration_seconds",
+			Help:      "Time spent retrieving features from the layer source",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"layer"}),
+		SourceErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "source_errors_total",
+			Help:      "Number of failed layer source requests",
+		}, []string{"layer"}),
+		CacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "cache_lookups_total",
+			Help:      "Number of layer data cache lookups by result (hit or miss)",
+		}, []string{"layer", "result"}),
+		CacheErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "cache_errors_total",
+			Help:      "Number of failed layer data cache operations by operation (get or put)",
+		}, []string{"layer", "op"}),
+		CoalescedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "coalesced_requests_total",
+			Help:      "Number of layer data requests deduplicated into an identical in-flight computation",
+		}, []string{"layer"}),
+		StaleResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "stale_responses_total",
+			Help:      "Number of stale cache entries served by reason (revalidate or error)",
+		}, []string{"layer", "reason"}),
+		TileSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      "tile_size_bytes",
+			Help:      "Size of the gzipped tiles served",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 9),
+		}),
+		TileFeatures: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      "tile_features",
+			Help:      "Number of features per layer in the tiles served",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 9),
+		}, []string{"layer"}),
+		InFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "requests_in_flight",
+			Help:      "Number of tile server requests currently being served",
+		}),
+	}
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.LayerRequests,
+		m.SourceLatency,
+		m.SourceErrors,
+		m.CacheLookups,
+		m.CacheErrors,
+		m.CoalescedRequests,
+		m.StaleResponses,
+		m.TileSize,
+		m.TileFeatures,
+		m.InFlight,
+	)
+	return m
+}
+
+// Handler returns the HTTP handler that exposes the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// InFlightMiddleware is a router middleware that tracks the number of in-flight requests
+func (m *Metrics) InFlightMiddleware(next http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerInFlight(m.InFlight, next)
+}
@@ -0,0 +1,41 @@
+package tilenol
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	cache := NewInMemoryCache()
+	layers := []Layer{
+		Layer{Name: "cached", Cacheable: true, source: &NilSource{}},
+	}
+	server := &Server{Layers: layers, Cache: cache}
+	api, internal := server.setupRoutes()
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("GET", "/_all/0/0/0.mvt", nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		assert.Equal(t, 200, w.Result().StatusCode)
+	}
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(server.Metrics.LayerRequests.WithLabelValues("cached")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.Metrics.CacheLookups.WithLabelValues("cached", "miss")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(server.Metrics.CacheLookups.WithLabelValues("cached", "hit")))
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	internal.ServeHTTP(w, r)
+	res := w.Result()
+	assert.Equal(t, 200, res.StatusCode)
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.True(t, strings.Contains(string(body), "tilenol_layer_requests_total"))
+	assert.True(t, strings.Contains(string(body), "tilenol_tile_size_bytes"))
+	assert.True(t, strings.Contains(string(body), "tilenol_requests_in_flight"))
+}
@@ -1,5 +1,7 @@
 package tilenol
 
+import "github.com/paulmach/orb/maptile"
+
 // NilCache implements the Cache interface as a no-op
 type NilCache struct{}
 
@@ -17,3 +19,13 @@ func (n *NilCache) Get(key string) ([]byte, error) {
 func (n *NilCache) Put(key string, val []byte) error {
 	return nil
 }
+
+// Delete is a no-op for the NilCache
+func (n *NilCache) Delete(key string) error {
+	return nil
+}
+
+// PurgeTiles is a no-op for the NilCache
+func (n *NilCache) PurgeTiles(layerKey string, match func(maptile.Tile) bool) (uint64, error) {
+	return 0, nil
+}
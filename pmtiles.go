@@ -0,0 +1,515 @@
+package tilenol
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/paulmach/orb/maptile"
+)
+
+const (
+	// PMTilesHeaderSize is the size of the fixed-length PMTiles v3 header
+	PMTilesHeaderSize = 127
+	// PMTilesMaxRootSize is the maximum size of the header and root directory, which clients
+	// fetch with a single initial request
+	PMTilesMaxRootSize = 16384
+)
+
+// PMTiles compression types
+const (
+	pmtilesCompressionUnknown = 0
+	pmtilesCompressionNone    = 1
+	pmtilesCompressionGzip    = 2
+)
+
+// PMTiles tile types
+const (
+	pmtilesTileTypeMVT = 1
+)
+
+var (
+	// pmtilesMagic is the magic number at the start of every PMTiles archive
+	pmtilesMagic = []byte("PMTiles")
+	// InvalidPMTilesErr is the error returned when reading a file that isn't a PMTiles v3 archive
+	InvalidPMTilesErr = errors.New("Invalid PMTiles v3 archive")
+)
+
+// pmtilesHeader is the fixed-length header of a PMTiles v3 archive
+type pmtilesHeader struct {
+	RootOffset          uint64
+	RootLength          uint64
+	MetadataOffset      uint64
+	MetadataLength      uint64
+	LeafOffset          uint64
+	LeafLength          uint64
+	TileDataOffset      uint64
+	TileDataLength      uint64
+	AddressedTiles      uint64
+	TileEntries         uint64
+	TileContents        uint64
+	Clustered           bool
+	InternalCompression uint8
+	TileCompression     uint8
+	TileType            uint8
+	MinZoom             uint8
+	MaxZoom             uint8
+	MinLonE7            int32
+	MinLatE7            int32
+	MaxLonE7            int32
+	MaxLatE7            int32
+	CenterZoom          uint8
+	CenterLonE7         int32
+	CenterLatE7         int32
+}
+
+// marshal encodes the header into its binary representation
+func (h *pmtilesHeader) marshal() []byte {
+	b := make([]byte, PMTilesHeaderSize)
+	copy(b, pmtilesMagic)
+	b[7] = 3
+	for i, v := range []uint64{
+		h.RootOffset, h.RootLength, h.MetadataOffset, h.MetadataLength, h.LeafOffset,
+		h.LeafLength, h.TileDataOffset, h.TileDataLength, h.AddressedTiles, h.TileEntries,
+		h.TileContents,
+	} {
+		binary.LittleEndian.PutUint64(b[8+8*i:], v)
+	}
+	if h.Clustered {
+		b[96] = 1
+	}
+	b[97] = h.InternalCompression
+	b[98] = h.TileCompression
+	b[99] = h.TileType
+	b[100] = h.MinZoom
+	b[101] = h.MaxZoom
+	binary.LittleEndian.PutUint32(b[102:], uint32(h.MinLonE7))
+	binary.LittleEndian.PutUint32(b[106:], uint32(h.MinLatE7))
+	binary.LittleEndian.PutUint32(b[110:], uint32(h.MaxLonE7))
+	binary.LittleEndian.PutUint32(b[114:], uint32(h.MaxLatE7))
+	b[118] = h.CenterZoom
+	binary.LittleEndian.PutUint32(b[119:], uint32(h.CenterLonE7))
+	binary.LittleEndian.PutUint32(b[123:], uint32(h.CenterLatE7))
+	return b
+}
+
+// unmarshalPMTilesHeader decodes a PMTiles v3 header from its binary representation
+func unmarshalPMTilesHeader(b []byte) (*pmtilesHeader, error) {
+	if len(b) < PMTilesHeaderSize || !bytes.Equal(b[:7], pmtilesMagic) || b[7] != 3 {
+		return nil, InvalidPMTilesErr
+	}
+	var v [11]uint64
+	for i := range v {
+		v[i] = binary.LittleEndian.Uint64(b[8+8*i:])
+	}
+	return &pmtilesHeader{
+		RootOffset:          v[0],
+		RootLength:          v[1],
+		MetadataOffset:      v[2],
+		MetadataLength:      v[3],
+		LeafOffset:          v[4],
+		LeafLength:          v[5],
+		TileDataOffset:      v[6],
+		TileDataLength:      v[7],
+		AddressedTiles:      v[8],
+		TileEntries:         v[9],
+		TileContents:        v[10],
+		Clustered:           b[96] == 1,
+		InternalCompression: b[97],
+		TileCompression:     b[98],
+		TileType:            b[99],
+		MinZoom:             b[100],
+		MaxZoom:             b[101],
+		MinLonE7:            int32(binary.LittleEndian.Uint32(b[102:])),
+		MinLatE7:            int32(binary.LittleEndian.Uint32(b[106:])),
+		MaxLonE7:            int32(binary.LittleEndian.Uint32(b[110:])),
+		MaxLatE7:            int32(binary.LittleEndian.Uint32(b[114:])),
+		CenterZoom:          b[118],
+		CenterLonE7:         int32(binary.LittleEndian.Uint32(b[119:])),
+		CenterLatE7:         int32(binary.LittleEndian.Uint32(b[123:])),
+	}, nil
+}
+
+// toE7 converts a coordinate to the fixed-point representation used by PMTiles headers
+func toE7(f float64) int32 {
+	return int32(math.Round(f * 1e7))
+}
+
+// pmtilesTileID computes the PMTiles tile ID of a tile, i.e. its position along the Hilbert
+// curve of its zoom level, offset by the number of tiles in all lower zoom levels
+func pmtilesTileID(t maptile.Tile) uint64 {
+	z := uint64(t.Z)
+	id := ((uint64(1) << (2 * z)) - 1) / 3
+	x, y := uint64(t.X), uint64(t.Y)
+	for s := (uint64(1) << z) >> 1; s > 0; s >>= 1 {
+		var rx, ry uint64
+		if x&s != 0 {
+			rx = 1
+		}
+		if y&s != 0 {
+			ry = 1
+		}
+		id += s * s * ((3 * rx) ^ ry)
+		// Rotate the quadrant so that the curve is continuous (only the lower bits are
+		// relevant to the next iterations, so wrapping around is harmless)
+		if ry == 0 {
+			if rx == 1 {
+				x = s - 1 - x
+				y = s - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+	return id
+}
+
+// pmtilesEntry is a single entry of a PMTiles directory, which either points to the data of
+// RunLength consecutive tiles, or to a leaf directory if RunLength is 0
+type pmtilesEntry struct {
+	TileID    uint64
+	Offset    uint64
+	Length    uint32
+	RunLength uint32
+}
+
+// serializePMTilesDirectory encodes and gzips a directory of entries sorted by tile ID
+func serializePMTilesDirectory(entries []pmtilesEntry) ([]byte, error) {
+	var b []byte
+	b = binary.AppendUvarint(b, uint64(len(entries)))
+	var lastID uint64
+	for _, e := range entries {
+		b = binary.AppendUvarint(b, e.TileID-lastID)
+		lastID = e.TileID
+	}
+	for _, e := range entries {
+		b = binary.AppendUvarint(b, uint64(e.RunLength))
+	}
+	for _, e := range entries {
+		b = binary.AppendUvarint(b, uint64(e.Length))
+	}
+	for i, e := range entries {
+		// Note: offsets of contiguous entries are encoded as 0, and other offsets as offset+1
+		if i > 0 && e.Offset == entries[i-1].Offset+uint64(entries[i-1].Length) {
+			b = binary.AppendUvarint(b, 0)
+		} else {
+			b = binary.AppendUvarint(b, e.Offset+1)
+		}
+	}
+	return gzipBytes(b)
+}
+
+// deserializePMTilesDirectory decodes a gzipped directory
+func deserializePMTilesDirectory(data []byte) ([]pmtilesEntry, error) {
+	raw, err := gunzipBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return parsePMTilesDirectory(raw)
+}
+
+// parsePMTilesDirectory decodes an uncompressed directory
+func parsePMTilesDirectory(raw []byte) ([]pmtilesEntry, error) {
+	r := bytes.NewReader(raw)
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	// Each entry takes at least 4 bytes, which guards against corrupted lengths
+	if n > uint64(len(raw))/4 {
+		return nil, InvalidPMTilesErr
+	}
+	entries := make([]pmtilesEntry, n)
+	var lastID uint64
+	for i := range entries {
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		lastID += delta
+		entries[i].TileID = lastID
+	}
+	for i := range entries {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		entries[i].RunLength = uint32(v)
+	}
+	for i := range entries {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		entries[i].Length = uint32(v)
+	}
+	for i := range entries {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if v == 0 && i > 0 {
+			entries[i].Offset = entries[i-1].Offset + uint64(entries[i-1].Length)
+		} else if v == 0 {
+			return nil, fmt.Errorf("%w: first directory entry has no offset", InvalidPMTilesErr)
+		} else {
+			entries[i].Offset = v - 1
+		}
+	}
+	return entries, nil
+}
+
+// gzipBytes compresses data with gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipBytes decompresses gzipped data
+func gunzipBytes(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// findPMTilesEntry finds the directory entry containing the given tile ID, which is either the
+// entry of its tile data or of the leaf directory to search next
+func findPMTilesEntry(entries []pmtilesEntry, tileID uint64) (pmtilesEntry, bool) {
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].TileID > tileID }) - 1
+	if i < 0 {
+		return pmtilesEntry{}, false
+	}
+	e := entries[i]
+	if e.RunLength == 0 || tileID < e.TileID+uint64(e.RunLength) {
+		return e, true
+	}
+	return pmtilesEntry{}, false
+}
+
+// buildPMTilesDirectories serializes the root directory of the entries, splitting them into
+// leaf directories if the root directory doesn't fit within the initial request
+func buildPMTilesDirectories(entries []pmtilesEntry) ([]byte, []byte, error) {
+	maxRootLength := PMTilesMaxRootSize - PMTilesHeaderSize
+	root, err := serializePMTilesDirectory(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(root) <= maxRootLength {
+		return root, nil, nil
+	}
+
+	leafSize := max(len(entries)/3500, 4096)
+	for {
+		var rootEntries []pmtilesEntry
+		var leaves []byte
+		for i := 0; i < len(entries); i += leafSize {
+			leaf, err := serializePMTilesDirectory(entries[i:min(i+leafSize, len(entries))])
+			if err != nil {
+				return nil, nil, err
+			}
+			rootEntries = append(rootEntries, pmtilesEntry{
+				TileID: entries[i].TileID,
+				Offset: uint64(len(leaves)),
+				Length: uint32(len(leaf)),
+			})
+			leaves = append(leaves, leaf...)
+		}
+		root, err := serializePMTilesDirectory(rootEntries)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(root) <= maxRootLength {
+			return root, leaves, nil
+		}
+		leafSize += leafSize / 5
+	}
+}
+
+// pmtilesMetadata is the JSON metadata stored in PMTiles archives
+type pmtilesMetadata struct {
+	Name         string        `json:"name"`
+	Description  string        `json:"description,omitempty"`
+	VectorLayers []VectorLayer `json:"vector_layers"`
+}
+
+// pmtilesContent locates the data of a tile in the temporary tile data file
+type pmtilesContent struct {
+	offset uint64
+	length uint32
+}
+
+// pmtilesTile is a tile written to a PMTilesWriter
+type pmtilesTile struct {
+	tileID  uint64
+	content pmtilesContent
+}
+
+// PMTilesWriter implements the TileWriter interface for PMTiles v3 archives. As tiles can be
+// written in any order, tile data is first spooled to a temporary file, and is then clustered
+// (i.e. ordered by tile ID) and deduplicated when the archive is finalized.
+type PMTilesWriter struct {
+	// Path is the path of the PMTiles file
+	Path string
+
+	file     *os.File
+	tmp      *os.File
+	tmpSize  uint64
+	tiles    []pmtilesTile
+	contents map[[sha256.Size]byte]pmtilesContent
+}
+
+// NewPMTilesWriter creates a new PMTiles archive at the given path
+func NewPMTilesWriter(path string) (*PMTilesWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("%w: %s", ArchiveExistsErr, path)
+	} else if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &PMTilesWriter{
+		Path:     path,
+		file:     file,
+		tmp:      tmp,
+		contents: make(map[[sha256.Size]byte]pmtilesContent),
+	}, nil
+}
+
+// WriteTile spools the gzipped vector tile data for a single tile, only storing identical
+// tile data once
+func (p *PMTilesWriter) WriteTile(t maptile.Tile, data []byte) error {
+	hash := sha256.Sum256(data)
+	content, exists := p.contents[hash]
+	if !exists {
+		if _, err := p.tmp.Write(data); err != nil {
+			return err
+		}
+		content = pmtilesContent{offset: p.tmpSize, length: uint32(len(data))}
+		p.contents[hash] = content
+		p.tmpSize += uint64(len(data))
+	}
+	p.tiles = append(p.tiles, pmtilesTile{tileID: pmtilesTileID(t), content: content})
+	return nil
+}
+
+// Finalize clusters the spooled tile data and writes the archive with the given metadata
+func (p *PMTilesWriter) Finalize(metadata *TileJSON) error {
+	sort.Slice(p.tiles, func(i, j int) bool { return p.tiles[i].tileID < p.tiles[j].tileID })
+
+	// Lay out the tile data in tile ID order, merging runs of consecutive identical tiles
+	var entries []pmtilesEntry
+	var order []pmtilesContent
+	offsets := make(map[pmtilesContent]uint64)
+	var dataLength uint64
+	for _, t := range p.tiles {
+		offset, exists := offsets[t.content]
+		if !exists {
+			offset = dataLength
+			offsets[t.content] = offset
+			order = append(order, t.content)
+			dataLength += uint64(t.content.length)
+		}
+		if n := len(entries); n > 0 {
+			last := &entries[n-1]
+			if last.Offset == offset && last.TileID+uint64(last.RunLength) == t.tileID {
+				last.RunLength++
+				continue
+			}
+		}
+		entries = append(entries, pmtilesEntry{
+			TileID:    t.tileID,
+			Offset:    offset,
+			Length:    t.content.length,
+			RunLength: 1,
+		})
+	}
+
+	root, leaves, err := buildPMTilesDirectories(entries)
+	if err != nil {
+		return err
+	}
+	metadataJSON, err := json.Marshal(&pmtilesMetadata{
+		Name:         metadata.Name,
+		Description:  metadata.Description,
+		VectorLayers: metadata.VectorLayers,
+	})
+	if err != nil {
+		return err
+	}
+	metadataBytes, err := gzipBytes(metadataJSON)
+	if err != nil {
+		return err
+	}
+
+	center := metadata.center()
+	header := &pmtilesHeader{
+		RootOffset:          PMTilesHeaderSize,
+		RootLength:          uint64(len(root)),
+		MetadataOffset:      PMTilesHeaderSize + uint64(len(root)),
+		MetadataLength:      uint64(len(metadataBytes)),
+		TileDataLength:      dataLength,
+		AddressedTiles:      uint64(len(p.tiles)),
+		TileEntries:         uint64(len(entries)),
+		TileContents:        uint64(len(order)),
+		Clustered:           true,
+		InternalCompression: pmtilesCompressionGzip,
+		TileCompression:     pmtilesCompressionGzip,
+		TileType:            pmtilesTileTypeMVT,
+		MinZoom:             uint8(metadata.Minzoom),
+		MaxZoom:             uint8(metadata.Maxzoom),
+		MinLonE7:            toE7(metadata.Bounds[0]),
+		MinLatE7:            toE7(metadata.Bounds[1]),
+		MaxLonE7:            toE7(metadata.Bounds[2]),
+		MaxLatE7:            toE7(metadata.Bounds[3]),
+		CenterZoom:          uint8(metadata.Minzoom),
+		CenterLonE7:         toE7(center[0]),
+		CenterLatE7:         toE7(center[1]),
+	}
+	header.LeafOffset = header.MetadataOffset + header.MetadataLength
+	header.LeafLength = uint64(len(leaves))
+	header.TileDataOffset = header.LeafOffset + header.LeafLength
+
+	w := bufio.NewWriter(p.file)
+	for _, section := range [][]byte{header.marshal(), root, metadataBytes, leaves} {
+		if _, err := w.Write(section); err != nil {
+			return err
+		}
+	}
+	for _, content := range order {
+		if _, err := io.Copy(w, io.NewSectionReader(p.tmp, int64(content.offset), int64(content.length))); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Close closes the archive file and removes the temporary tile data file
+func (p *PMTilesWriter) Close() error {
+	p.tmp.Close()
+	tmpErr := os.Remove(p.tmp.Name())
+	return errors.Join(p.file.Close(), tmpErr)
+}
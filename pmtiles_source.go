@@ -0,0 +1,249 @@
+package tilenol
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+const (
+	// DefaultPMTilesDirectoryCacheSize is the default number of leaf directories kept in memory
+	// by a PMTilesSource
+	DefaultPMTilesDirectoryCacheSize = 64
+	// pmtilesMaxDepth is the maximum depth of nested directories
+	pmtilesMaxDepth = 4
+)
+
+var (
+	// MissingPMTilesPathErr is the error returned when a PMTiles source has no file path
+	MissingPMTilesPathErr = errors.New("PMTiles source requires a \"path\"")
+	// MissingPMTilesLayerErr is the error returned when a PMTiles source has no layer name
+	MissingPMTilesLayerErr = errors.New("PMTiles source requires a \"layer\"")
+)
+
+// PMTilesConfig is the YAML configuration structure for configuring a new PMTilesSource
+type PMTilesConfig struct {
+	// Path is the path to the PMTiles archive
+	Path string `yaml:"path"`
+	// Layer is the name of the vector tile layer to extract from the stored tiles
+	Layer string `yaml:"layer"`
+	// DirectoryCacheSize is the number of leaf directories kept in memory (optional)
+	DirectoryCacheSize int `yaml:"directoryCacheSize"`
+}
+
+// pmtilesDirectoryCache is a concurrency-safe LRU cache of decoded leaf directories, keyed by
+// their offset in the archive
+type pmtilesDirectoryCache struct {
+	size  int
+	mu    sync.Mutex
+	lru   *list.List
+	cache map[uint64]*list.Element
+}
+
+// pmtilesCachedDirectory is a single leaf directory, tracked in the LRU list
+type pmtilesCachedDirectory struct {
+	offset  uint64
+	entries []pmtilesEntry
+}
+
+// get retrieves a cached directory, marking it as recently used
+func (c *pmtilesDirectoryCache) get(offset uint64) ([]pmtilesEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, exists := c.cache[offset]
+	if !exists {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*pmtilesCachedDirectory).entries, true
+}
+
+// put stores a directory, evicting the least recently used directory if the cache is full
+func (c *pmtilesDirectoryCache) put(offset uint64, entries []pmtilesEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.cache[offset]; exists {
+		return
+	}
+	c.cache[offset] = c.lru.PushFront(&pmtilesCachedDirectory{offset, entries})
+	for c.lru.Len() > c.size {
+		dir := c.lru.Remove(c.lru.Back()).(*pmtilesCachedDirectory)
+		delete(c.cache, dir.offset)
+	}
+}
+
+// PMTilesSource is a Source implementation that retrieves feature data from the pre-rendered
+// vector tiles of a local PMTiles v3 archive
+type PMTilesSource struct {
+	// Layer is the name of the vector tile layer to extract from the stored tiles
+	Layer string
+
+	file        *os.File
+	header      *pmtilesHeader
+	root        []pmtilesEntry
+	fields      []string
+	directories *pmtilesDirectoryCache
+}
+
+// NewPMTilesSource creates a new Source that retrieves feature data from a PMTiles archive
+func NewPMTilesSource(config *PMTilesConfig) (Source, error) {
+	if config.Path == "" {
+		return nil, MissingPMTilesPathErr
+	}
+	if config.Layer == "" {
+		return nil, MissingPMTilesLayerErr
+	}
+	cacheSize := config.DirectoryCacheSize
+	if cacheSize <= 0 {
+		cacheSize = DefaultPMTilesDirectoryCacheSize
+	}
+	file, err := os.Open(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	p := &PMTilesSource{
+		Layer: config.Layer,
+		file:  file,
+		directories: &pmtilesDirectoryCache{
+			size:  cacheSize,
+			lru:   list.New(),
+			cache: make(map[uint64]*list.Element),
+		},
+	}
+	if err := p.readHeader(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to read PMTiles archive [%s]: %w", config.Path, err)
+	}
+	return p, nil
+}
+
+// readSection reads a section of the archive, decompressing it using the archive's internal
+// compression
+func (p *PMTilesSource) readSection(offset, length uint64) ([]byte, error) {
+	data := make([]byte, length)
+	if _, err := p.file.ReadAt(data, int64(offset)); err != nil {
+		return nil, err
+	}
+	switch p.header.InternalCompression {
+	case pmtilesCompressionGzip:
+		return gunzipBytes(data)
+	case pmtilesCompressionNone:
+		return data, nil
+	default:
+		return nil, fmt.Errorf("Unsupported PMTiles internal compression [%d]", p.header.InternalCompression)
+	}
+}
+
+// readHeader reads and validates the archive header, root directory and metadata
+func (p *PMTilesSource) readHeader() error {
+	b := make([]byte, PMTilesHeaderSize)
+	if _, err := p.file.ReadAt(b, 0); err != nil {
+		return err
+	}
+	header, err := unmarshalPMTilesHeader(b)
+	if err != nil {
+		return err
+	}
+	if header.TileType != pmtilesTileTypeMVT {
+		return fmt.Errorf("Unsupported PMTiles tile type [%d]", header.TileType)
+	}
+	switch header.TileCompression {
+	case pmtilesCompressionUnknown, pmtilesCompressionNone, pmtilesCompressionGzip:
+	default:
+		return fmt.Errorf("Unsupported PMTiles tile compression [%d]", header.TileCompression)
+	}
+	p.header = header
+
+	raw, err := p.readSection(header.RootOffset, header.RootLength)
+	if err != nil {
+		return err
+	}
+	if p.root, err = parsePMTilesDirectory(raw); err != nil {
+		return err
+	}
+
+	if header.MetadataLength > 0 {
+		raw, err := p.readSection(header.MetadataOffset, header.MetadataLength)
+		if err != nil {
+			return err
+		}
+		var metadata pmtilesMetadata
+		if err := json.Unmarshal(raw, &metadata); err != nil {
+			return err
+		}
+		for _, layer := range metadata.VectorLayers {
+			if layer.ID != p.Layer {
+				continue
+			}
+			for field := range layer.Fields {
+				p.fields = append(p.fields, field)
+			}
+		}
+	}
+	return nil
+}
+
+// leafDirectory retrieves a leaf directory, using the directory cache when possible
+func (p *PMTilesSource) leafDirectory(offset uint64, length uint32) ([]pmtilesEntry, error) {
+	if entries, ok := p.directories.get(offset); ok {
+		return entries, nil
+	}
+	raw, err := p.readSection(p.header.LeafOffset+offset, uint64(length))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parsePMTilesDirectory(raw)
+	if err != nil {
+		return nil, err
+	}
+	p.directories.put(offset, entries)
+	return entries, nil
+}
+
+// Close closes the underlying archive file
+func (p *PMTilesSource) Close() error {
+	return p.file.Close()
+}
+
+// Fields implements the FieldsSource interface, using the vector_layers metadata of the
+// archive (if any)
+func (p *PMTilesSource) Fields() []string {
+	return p.fields
+}
+
+// GetFeatures implements the Source interface, looking up the requested tile through the
+// archive directories and extracting the configured layer from its data (if any)
+func (p *PMTilesSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	tile := req.MapTile()
+	if uint8(tile.Z) < p.header.MinZoom || uint8(tile.Z) > p.header.MaxZoom {
+		return geojson.NewFeatureCollection(), nil
+	}
+
+	tileID := pmtilesTileID(tile)
+	entries := p.root
+	for depth := 0; depth < pmtilesMaxDepth; depth++ {
+		entry, ok := findPMTilesEntry(entries, tileID)
+		if !ok {
+			return geojson.NewFeatureCollection(), nil
+		}
+		if entry.RunLength > 0 {
+			data := make([]byte, entry.Length)
+			if _, err := p.file.ReadAt(data, int64(p.header.TileDataOffset+entry.Offset)); err != nil {
+				return nil, err
+			}
+			return extractTileLayer(data, p.Layer, tile)
+		}
+		leaf, err := p.leafDirectory(entry.Offset, entry.Length)
+		if err != nil {
+			return nil, err
+		}
+		entries = leaf
+	}
+	return nil, fmt.Errorf("%w: directories are nested too deeply", InvalidPMTilesErr)
+}
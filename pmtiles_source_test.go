@@ -0,0 +1,102 @@
+package tilenol
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPMTilesSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "static.pmtiles")
+	w, err := NewPMTilesWriter(path)
+	assert.Nil(t, err)
+	tile := maptile.New(2, 1, 2)
+	assert.Nil(t, w.WriteTile(tile, encodeTestTile(t, tile, orb.Point{1, 1}, "roads", "buildings")))
+	// Identical neighbouring tiles are stored as a single run-length entry
+	for _, neighbour := range []maptile.Tile{maptile.New(0, 0, 1), maptile.New(0, 1, 1)} {
+		assert.Nil(t, w.WriteTile(neighbour, []byte("ocean")))
+	}
+	assert.Nil(t, w.Finalize(&TileJSON{
+		Name:    "static",
+		Minzoom: 1,
+		Maxzoom: 2,
+		VectorLayers: []VectorLayer{
+			VectorLayer{ID: "buildings", Fields: map[string]string{"layer": ""}},
+		},
+	}))
+	assert.Nil(t, w.Close())
+
+	source, err := NewPMTilesSource(&PMTilesConfig{Path: path, Layer: "buildings"})
+	assert.Nil(t, err)
+	defer source.(*PMTilesSource).Close()
+	assert.Equal(t, "pmtiles", Layer{source: source}.SourceType())
+	assert.Equal(t, []string{"layer"}, source.(*PMTilesSource).Fields())
+
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 2, Y: 1, Z: 2})
+	assert.Nil(t, err)
+	assert.Len(t, fc.Features, 1)
+	assert.Equal(t, "buildings", fc.Features[0].Properties["layer"])
+	point := fc.Features[0].Geometry.(orb.Point)
+	assert.InDelta(t, 1, point.X(), 0.01)
+	assert.InDelta(t, 1, point.Y(), 0.01)
+
+	for _, req := range []*TileRequest{{X: 0, Y: 0, Z: 0}, {X: 3, Y: 3, Z: 2}, {X: 0, Y: 0, Z: 3}} {
+		fc, err = source.GetFeatures(context.Background(), req)
+		assert.Nil(t, err)
+		assert.Empty(t, fc.Features, "Missing tiles should return no features")
+	}
+}
+
+func TestPMTilesSourceLeafDirectories(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "large.pmtiles")
+	w, err := NewPMTilesWriter(path)
+	assert.Nil(t, err)
+
+	// Write enough tiles with distinct content to require leaf directories
+	r := rand.New(rand.NewSource(1))
+	target := maptile.New(100, 100, 9)
+	for x := uint32(0); x < 250; x++ {
+		for y := uint32(0); y < 250; y++ {
+			tile := maptile.New(x, y, 9)
+			data := make([]byte, 1+r.Intn(200))
+			r.Read(data)
+			if tile == target {
+				data = encodeTestTile(t, tile, tile.Center(), "buildings")
+			}
+			assert.Nil(t, w.WriteTile(tile, data))
+		}
+	}
+	assert.Nil(t, w.Finalize(&TileJSON{Name: "large", Minzoom: 9, Maxzoom: 9}))
+	assert.Nil(t, w.Close())
+
+	source, err := NewPMTilesSource(&PMTilesConfig{Path: path, Layer: "buildings", DirectoryCacheSize: 1})
+	assert.Nil(t, err)
+	defer source.(*PMTilesSource).Close()
+	p := source.(*PMTilesSource)
+	assert.NotZero(t, p.header.LeafLength, "Archive should contain leaf directories")
+
+	for i := 0; i < 2; i++ {
+		fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 100, Y: 100, Z: 9})
+		assert.Nil(t, err)
+		assert.Len(t, fc.Features, 1)
+	}
+	assert.Equal(t, 1, p.directories.lru.Len())
+}
+
+func TestPMTilesSourceInvalidArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invalid.pmtiles")
+	assert.Nil(t, os.WriteFile(path, []byte("not a pmtiles archive"), 0644))
+	_, err := NewPMTilesSource(&PMTilesConfig{Path: path, Layer: "a"})
+	assert.NotNil(t, err)
+
+	_, err = NewPMTilesSource(&PMTilesConfig{Layer: "a"})
+	assert.Equal(t, MissingPMTilesPathErr, err)
+	_, err = NewPMTilesSource(&PMTilesConfig{Path: path})
+	assert.Equal(t, MissingPMTilesLayerErr, err)
+}
@@ -0,0 +1,130 @@
+package tilenol
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPMTilesTileID(t *testing.T) {
+	assert.Equal(t, uint64(0), pmtilesTileID(maptile.New(0, 0, 0)))
+	assert.Equal(t, uint64(1), pmtilesTileID(maptile.New(0, 0, 1)))
+	assert.Equal(t, uint64(2), pmtilesTileID(maptile.New(0, 1, 1)))
+	assert.Equal(t, uint64(3), pmtilesTileID(maptile.New(1, 1, 1)))
+	assert.Equal(t, uint64(4), pmtilesTileID(maptile.New(1, 0, 1)))
+	assert.Equal(t, uint64(5), pmtilesTileID(maptile.New(0, 0, 2)))
+	assert.Equal(t, uint64(19078479), pmtilesTileID(maptile.New(3423, 1763, 12)))
+}
+
+func TestPMTilesDirectoryRoundTrip(t *testing.T) {
+	entries := []pmtilesEntry{
+		{TileID: 0, Offset: 0, Length: 10, RunLength: 1},
+		{TileID: 1, Offset: 10, Length: 20, RunLength: 3},
+		{TileID: 5, Offset: 0, Length: 10, RunLength: 1},
+	}
+	data, err := serializePMTilesDirectory(entries)
+	assert.Nil(t, err)
+	decoded, err := deserializePMTilesDirectory(data)
+	assert.Nil(t, err)
+	assert.Equal(t, entries, decoded)
+
+	e, ok := findPMTilesEntry(entries, 3)
+	assert.True(t, ok)
+	assert.Equal(t, entries[1], e)
+	_, ok = findPMTilesEntry(entries, 4)
+	assert.False(t, ok)
+}
+
+func TestPMTilesLeafDirectories(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var entries []pmtilesEntry
+	var offset uint64
+	for i := 0; i < 100000; i++ {
+		length := uint32(r.Intn(100000))
+		entries = append(entries, pmtilesEntry{TileID: uint64(i * 2), Offset: offset, Length: length, RunLength: 1})
+		offset += uint64(length)
+	}
+	root, leaves, err := buildPMTilesDirectories(entries)
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, len(root), PMTilesMaxRootSize-PMTilesHeaderSize)
+	assert.NotEmpty(t, leaves)
+
+	rootEntries, err := deserializePMTilesDirectory(root)
+	assert.Nil(t, err)
+	leafEntry, ok := findPMTilesEntry(rootEntries, 123456)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0), leafEntry.RunLength)
+	leafEntries, err := deserializePMTilesDirectory(leaves[leafEntry.Offset : leafEntry.Offset+uint64(leafEntry.Length)])
+	assert.Nil(t, err)
+	e, ok := findPMTilesEntry(leafEntries, 123456)
+	assert.True(t, ok)
+	assert.Equal(t, entries[123456/2], e)
+}
+
+func TestPMTilesWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.pmtiles")
+	w, err := NewPMTilesWriter(path)
+	assert.Nil(t, err)
+
+	// Tiles are written out of order, with duplicated content
+	assert.Nil(t, w.WriteTile(maptile.New(1, 0, 1), []byte("ocean")))
+	assert.Nil(t, w.WriteTile(maptile.New(0, 0, 0), []byte("world")))
+	assert.Nil(t, w.WriteTile(maptile.New(0, 0, 1), []byte("ocean")))
+	assert.Nil(t, w.WriteTile(maptile.New(0, 1, 1), []byte("ocean")))
+	assert.Nil(t, w.WriteTile(maptile.New(1, 1, 1), []byte("land")))
+	assert.Nil(t, w.Finalize(&TileJSON{
+		Name:         "test",
+		Minzoom:      0,
+		Maxzoom:      1,
+		Bounds:       [4]float64{-180, -MaxLatitude, 180, MaxLatitude},
+		VectorLayers: []VectorLayer{VectorLayer{ID: "a", Maxzoom: 1}},
+	}))
+	assert.Nil(t, w.Close())
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	header, err := unmarshalPMTilesHeader(data)
+	assert.Nil(t, err)
+	assert.True(t, header.Clustered)
+	assert.Equal(t, uint64(5), header.AddressedTiles)
+	assert.Equal(t, uint64(4), header.TileEntries, "Consecutive identical tiles should be merged")
+	assert.Equal(t, uint64(3), header.TileContents, "Identical tiles should be stored once")
+	assert.Equal(t, uint64(len("world")+len("ocean")+len("land")), header.TileDataLength)
+	assert.Equal(t, uint8(pmtilesCompressionGzip), header.TileCompression)
+	assert.Equal(t, uint8(pmtilesTileTypeMVT), header.TileType)
+	assert.Equal(t, int32(-1800000000), header.MinLonE7)
+	assert.Equal(t, uint64(len(data)), header.TileDataOffset+header.TileDataLength)
+
+	root, err := deserializePMTilesDirectory(data[header.RootOffset : header.RootOffset+header.RootLength])
+	assert.Nil(t, err)
+	tileData := data[header.TileDataOffset:]
+	for tile, expected := range map[maptile.Tile]string{
+		maptile.New(0, 0, 0): "world",
+		maptile.New(0, 0, 1): "ocean",
+		maptile.New(0, 1, 1): "ocean",
+		maptile.New(1, 1, 1): "land",
+		maptile.New(1, 0, 1): "ocean",
+	} {
+		e, ok := findPMTilesEntry(root, pmtilesTileID(tile))
+		assert.True(t, ok)
+		assert.Equal(t, expected, string(tileData[e.Offset:e.Offset+uint64(e.Length)]))
+	}
+
+	metadataBytes, err := gunzipBytes(data[header.MetadataOffset : header.MetadataOffset+header.MetadataLength])
+	assert.Nil(t, err)
+	var metadata pmtilesMetadata
+	assert.Nil(t, json.Unmarshal(metadataBytes, &metadata))
+	assert.Equal(t, "test", metadata.Name)
+	assert.Len(t, metadata.VectorLayers, 1)
+
+	_, err = NewPMTilesWriter(path)
+	assert.True(t, errors.Is(err, ArchiveExistsErr))
+	matches, _ := filepath.Glob(path + ".*.tmp")
+	assert.Empty(t, matches, "The temporary tile data file should be removed")
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -80,7 +81,12 @@ type PostGISSource struct {
 
 // CheckPing asserts that we can ping the connected database
 func CheckPing(db *sql.DB) error {
-	if connErr := db.Ping(); connErr != nil {
+	return CheckPingContext(context.Background(), db)
+}
+
+// CheckPingContext asserts that we can ping the connected database within the given context
+func CheckPingContext(ctx context.Context, db *sql.DB) error {
+	if connErr := db.PingContext(ctx); connErr != nil {
 		return connErr
 	}
 	return nil
@@ -133,6 +139,31 @@ func NewPostGISSource(config *PostGISConfig) (Source, error) {
 	}, nil
 }
 
+// Close closes the underlying database connection pool
+func (p *PostGISSource) Close() error {
+	if p.DB == nil {
+		return nil
+	}
+	if closer, ok := p.DB.Db.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// HealthCheck implements the HealthChecker interface by pinging the database
+func (p *PostGISSource) HealthCheck(ctx context.Context) error {
+	db, ok := p.DB.Db.(*sql.DB)
+	if !ok {
+		return nil
+	}
+	return CheckPingContext(ctx, db)
+}
+
+// Fields implements the FieldsSource interface
+func (p *PostGISSource) Fields() []string {
+	return sourceFieldNames(p.SourceFields)
+}
+
 // Creates a new PostGISSource from the input object, but adds extra SourceFields
 // to include to the new PostGISSource instance.
 func (p *PostGISSource) withExtraFields(extraFields map[string]string) *PostGISSource {
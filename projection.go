@@ -0,0 +1,649 @@
+package tilenol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/project"
+)
+
+const (
+	// projectionMaxIterations is the maximum number of iterations used when computing latitudes
+	// through iterative inverse projection formulas
+	projectionMaxIterations = 15
+	// projectionTolerance is the convergence threshold (in radians) of iterative formulas
+	projectionTolerance = 1e-12
+)
+
+var (
+	// InvalidWKTErr is the error returned when a coordinate reference system definition is not
+	// valid WKT
+	InvalidWKTErr = errors.New("Invalid WKT coordinate reference system")
+	// UnsupportedProjectionErr is the error returned when a coordinate reference system uses a
+	// projection that cannot be converted to WGS84
+	UnsupportedProjectionErr = errors.New("Unsupported coordinate reference system projection")
+)
+
+// wktNode is a single keyword node of a WKT definition, e.g. UNIT["metre",1]. Arguments are
+// either strings, numbers or nested nodes.
+type wktNode struct {
+	Keyword string
+	Args    []interface{}
+}
+
+// wktParser is a simple recursive descent parser for WKT (version 1) definitions
+type wktParser struct {
+	s   string
+	pos int
+}
+
+// parseWKT parses a WKT definition into its root node
+func parseWKT(s string) (*wktNode, error) {
+	p := &wktParser{s: s}
+	node, err := p.parseNode()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("%w: unexpected trailing data at offset %d", InvalidWKTErr, p.pos)
+	}
+	return node, nil
+}
+
+// skipSpace advances the parser past any whitespace
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// parseKeyword parses a keyword, i.e. the name of a node or a bare enumeration value
+func (p *wktParser) parseKeyword() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && (p.s[p.pos] == '_' || unicode.IsLetter(rune(p.s[p.pos])) || unicode.IsDigit(rune(p.s[p.pos]))) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// parseNode parses a keyword followed by its bracketed arguments
+func (p *wktParser) parseNode() (*wktNode, error) {
+	start := p.pos
+	keyword := p.parseKeyword()
+	p.skipSpace()
+	if keyword == "" || p.pos >= len(p.s) || (p.s[p.pos] != '[' && p.s[p.pos] != '(') {
+		return nil, fmt.Errorf("%w: expected keyword at offset %d", InvalidWKTErr, start)
+	}
+	return p.parseArgs(strings.ToUpper(keyword))
+}
+
+// parseArgs parses the bracketed, comma-separated arguments of a node
+func (p *wktParser) parseArgs(keyword string) (*wktNode, error) {
+	node := &wktNode{Keyword: keyword}
+	closing := byte(']')
+	if p.s[p.pos] == '(' {
+		closing = ')'
+	}
+	p.pos++
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("%w: unexpected end of definition", InvalidWKTErr)
+		}
+		switch c := p.s[p.pos]; {
+		case c == '"':
+			str, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			node.Args = append(node.Args, str)
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			start := p.pos
+			for p.pos < len(p.s) && strings.IndexByte("+-.eE0123456789", p.s[p.pos]) >= 0 {
+				p.pos++
+			}
+			num, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid number at offset %d", InvalidWKTErr, start)
+			}
+			node.Args = append(node.Args, num)
+		default:
+			start := p.pos
+			keyword := p.parseKeyword()
+			if keyword == "" {
+				return nil, fmt.Errorf("%w: unexpected character at offset %d", InvalidWKTErr, start)
+			}
+			p.skipSpace()
+			if p.pos < len(p.s) && (p.s[p.pos] == '[' || p.s[p.pos] == '(') {
+				child, err := p.parseArgs(strings.ToUpper(keyword))
+				if err != nil {
+					return nil, err
+				}
+				node.Args = append(node.Args, child)
+			} else {
+				// Note: some arguments are bare enumeration values, e.g. AXIS["Easting",EAST]
+				node.Args = append(node.Args, keyword)
+			}
+		}
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("%w: unexpected end of definition", InvalidWKTErr)
+		}
+		if p.s[p.pos] == closing {
+			p.pos++
+			return node, nil
+		}
+		if p.s[p.pos] != ',' {
+			return nil, fmt.Errorf("%w: expected separator at offset %d", InvalidWKTErr, p.pos)
+		}
+		p.pos++
+	}
+}
+
+// parseString parses a double-quoted string, where quotes are escaped by doubling them
+func (p *wktParser) parseString() (string, error) {
+	var sb strings.Builder
+	p.pos++
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		if c != '"' {
+			sb.WriteByte(c)
+			continue
+		}
+		if p.pos < len(p.s) && p.s[p.pos] == '"' {
+			sb.WriteByte('"')
+			p.pos++
+			continue
+		}
+		return sb.String(), nil
+	}
+	return "", fmt.Errorf("%w: unterminated string", InvalidWKTErr)
+}
+
+// Name returns the name of the node, i.e. its first string argument
+func (n *wktNode) Name() string {
+	if len(n.Args) > 0 {
+		if name, ok := n.Args[0].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// Number returns the numeric argument at the given index (if any)
+func (n *wktNode) Number(i int) (float64, bool) {
+	if i < len(n.Args) {
+		num, ok := n.Args[i].(float64)
+		return num, ok
+	}
+	return 0, false
+}
+
+// Child returns the first child node with the given keyword (if any)
+func (n *wktNode) Child(keyword string) *wktNode {
+	for _, arg := range n.Args {
+		if child, ok := arg.(*wktNode); ok && child.Keyword == keyword {
+			return child
+		}
+	}
+	return nil
+}
+
+// Children returns all child nodes with the given keyword
+func (n *wktNode) Children(keyword string) []*wktNode {
+	var children []*wktNode
+	for _, arg := range n.Args {
+		if child, ok := arg.(*wktNode); ok && child.Keyword == keyword {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// normalizeWKTName normalizes projection and parameter names, which vary in case and
+// separators between the OGC and ESRI flavors of WKT
+func normalizeWKTName(name string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(name))
+}
+
+// ellipsoid is the reference ellipsoid of a geographic coordinate system
+type ellipsoid struct {
+	// a is the semi-major axis, in meters
+	a float64
+	// e2 is the squared eccentricity
+	e2 float64
+}
+
+// e returns the eccentricity of the ellipsoid
+func (el ellipsoid) e() float64 {
+	return math.Sqrt(el.e2)
+}
+
+// geographicCRS describes the properties of a WKT GEOGCS needed for reprojection
+type geographicCRS struct {
+	ellipsoid ellipsoid
+	// unit is the size of the angular unit, in radians
+	unit float64
+	// primeMeridian is the longitude of the prime meridian from Greenwich, in degrees
+	primeMeridian float64
+}
+
+// parseGeographicCRS parses a GEOGCS node. Note that datum shifts are not applied, which is
+// accurate within a few meters for modern datums (e.g. NAD83, ETRS89), but not for older
+// datums (e.g. NAD27).
+func parseGeographicCRS(node *wktNode) (*geographicCRS, error) {
+	crs := &geographicCRS{
+		ellipsoid: ellipsoid{a: 6378137, e2: 0.0066943799901413165},
+		unit:      math.Pi / 180,
+	}
+	if datum := node.Child("DATUM"); datum != nil {
+		if spheroid := datum.Child("SPHEROID"); spheroid != nil {
+			a, okA := spheroid.Number(1)
+			invF, okF := spheroid.Number(2)
+			if !okA || !okF || a <= 0 {
+				return nil, fmt.Errorf("%w: invalid spheroid", InvalidWKTErr)
+			}
+			crs.ellipsoid.a = a
+			crs.ellipsoid.e2 = 0
+			if invF != 0 {
+				f := 1 / invF
+				crs.ellipsoid.e2 = 2*f - f*f
+			}
+		}
+	}
+	if unit := node.Child("UNIT"); unit != nil {
+		if size, ok := unit.Number(1); ok && size > 0 {
+			crs.unit = size
+		}
+	}
+	// Note: although WKT 1 specifies the prime meridian in the angular unit, both GDAL and ESRI
+	// write it in degrees
+	if primem := node.Child("PRIMEM"); primem != nil {
+		if lon, ok := primem.Number(1); ok {
+			crs.primeMeridian = lon
+		}
+	}
+	return crs, nil
+}
+
+// crsTransform converts coordinates between a coordinate reference system and WGS84
+type crsTransform struct {
+	// ToWGS84 projects coordinates to WGS84 longitude/latitude
+	ToWGS84 orb.Projection
+	// FromWGS84 projects WGS84 longitude/latitude to coordinates
+	FromWGS84 orb.Projection
+}
+
+// crsTransformFromWKT parses a WKT coordinate reference system definition, returning the
+// transform between its coordinates and WGS84 longitude/latitude. Note that a nil transform is
+// returned for coordinate systems that do not require any reprojection.
+func crsTransformFromWKT(wkt string) (*crsTransform, error) {
+	root, err := parseWKT(wkt)
+	if err != nil {
+		return nil, err
+	}
+	switch root.Keyword {
+	case "GEOGCS":
+		geog, err := parseGeographicCRS(root)
+		if err != nil {
+			return nil, err
+		}
+		scale := geog.unit * 180 / math.Pi
+		if math.Abs(scale-1) < 1e-9 && geog.primeMeridian == 0 {
+			return nil, nil
+		}
+		return &crsTransform{
+			ToWGS84: func(p orb.Point) orb.Point {
+				return orb.Point{p[0]*scale + geog.primeMeridian, p[1] * scale}
+			},
+			FromWGS84: func(p orb.Point) orb.Point {
+				return orb.Point{(p[0] - geog.primeMeridian) / scale, p[1] / scale}
+			},
+		}, nil
+	case "PROJCS":
+		return parseProjectedCRS(root)
+	default:
+		return nil, fmt.Errorf("%w: %s", UnsupportedProjectionErr, root.Keyword)
+	}
+}
+
+// wgs84ProjectionFromWKT parses a WKT coordinate reference system definition, returning the
+// projection from its coordinates to WGS84 longitude/latitude. Note that a nil projection is
+// returned for coordinate systems that do not require any reprojection.
+func wgs84ProjectionFromWKT(wkt string) (orb.Projection, error) {
+	transform, err := crsTransformFromWKT(wkt)
+	if err != nil || transform == nil {
+		return nil, err
+	}
+	return transform.ToWGS84, nil
+}
+
+// Bound computes the bounds of a WGS84 bound in the coordinate reference system. Since edges
+// are not straight lines once projected, the bound is densified before being projected.
+func (t *crsTransform) Bound(bound orb.Bound) orb.Bound {
+	const steps = 16
+	dx := (bound.Max[0] - bound.Min[0]) / steps
+	dy := (bound.Max[1] - bound.Min[1]) / steps
+	projected := t.FromWGS84(bound.Min).Bound()
+	for i := 0; i <= steps; i++ {
+		x := bound.Min[0] + float64(i)*dx
+		y := bound.Min[1] + float64(i)*dy
+		for _, p := range []orb.Point{{x, bound.Min[1]}, {x, bound.Max[1]}, {bound.Min[0], y}, {bound.Max[0], y}} {
+			projected = projected.Extend(t.FromWGS84(p))
+		}
+	}
+	return projected
+}
+
+// projectionParams holds the (normalized) projection parameters of a PROJCS, with angles in
+// radians and distances in meters
+type projectionParams struct {
+	lon0, lat0    float64
+	lat1, lat2    float64
+	hasLat1       bool
+	k0            float64
+	falseEasting  float64
+	falseNorthing float64
+}
+
+// projectionFunc is either the forward or inverse function of a projection, where longitudes
+// are relative to the central meridian
+type projectionFunc func(float64, float64) (float64, float64)
+
+// parseProjectedCRS parses a PROJCS node into its transform
+func parseProjectedCRS(root *wktNode) (*crsTransform, error) {
+	geogNode := root.Child("GEOGCS")
+	if geogNode == nil {
+		return nil, fmt.Errorf("%w: missing GEOGCS", InvalidWKTErr)
+	}
+	geog, err := parseGeographicCRS(geogNode)
+	if err != nil {
+		return nil, err
+	}
+	projection := root.Child("PROJECTION")
+	if projection == nil {
+		return nil, fmt.Errorf("%w: missing PROJECTION", InvalidWKTErr)
+	}
+	unit := 1.0
+	if unitNode := root.Child("UNIT"); unitNode != nil {
+		if size, ok := unitNode.Number(1); ok && size > 0 {
+			unit = size
+		}
+	}
+
+	// Note: angular parameters are expressed in the units of the geographic CRS, while linear
+	// parameters are expressed in the units of the projected CRS
+	params := projectionParams{k0: 1}
+	for _, param := range root.Children("PARAMETER") {
+		value, ok := param.Number(1)
+		if !ok {
+			continue
+		}
+		// Note: longitudes are relative to the prime meridian of the geographic CRS
+		lon := value*geog.unit + geog.primeMeridian*math.Pi/180
+		switch normalizeWKTName(param.Name()) {
+		case "central_meridian", "longitude_of_center", "longitude_of_origin":
+			params.lon0 = lon
+		case "latitude_of_origin", "latitude_of_center":
+			params.lat0 = value * geog.unit
+		case "standard_parallel_1":
+			params.lat1, params.hasLat1 = value*geog.unit, true
+		case "standard_parallel_2":
+			params.lat2 = value * geog.unit
+		case "scale_factor":
+			params.k0 = value
+		case "false_easting":
+			params.falseEasting = value * unit
+		case "false_northing":
+			params.falseNorthing = value * unit
+		}
+	}
+
+	var forward, inverse projectionFunc
+	el := geog.ellipsoid
+	name := normalizeWKTName(projection.Name())
+	switch name {
+	case "transverse_mercator", "gauss_kruger":
+		forward, inverse = transverseMercator(el, params)
+	case "mercator", "mercator_1sp", "mercator_2sp", "mercator_auxiliary_sphere",
+		"popular_visualisation_pseudo_mercator":
+		crsName := normalizeWKTName(root.Name())
+		if name == "mercator_auxiliary_sphere" || name == "popular_visualisation_pseudo_mercator" ||
+			strings.Contains(crsName, "pseudo_mercator") || strings.Contains(crsName, "web_mercator") {
+			// Web Mercator uses spherical formulas, with the semi-major axis as the radius
+			el.e2 = 0
+		}
+		forward, inverse = mercator(el, params)
+	case "lambert_conformal_conic", "lambert_conformal_conic_1sp", "lambert_conformal_conic_2sp":
+		forward, inverse = lambertConformalConic(el, params)
+	case "albers", "albers_conic_equal_area", "albers_equal_area":
+		forward, inverse = albers(el, params)
+	default:
+		return nil, fmt.Errorf("%w: %s", UnsupportedProjectionErr, projection.Name())
+	}
+	return &crsTransform{
+		ToWGS84: func(p orb.Point) orb.Point {
+			dlon, lat := inverse(p[0]*unit-params.falseEasting, p[1]*unit-params.falseNorthing)
+			return orb.Point{normalizeLongitude(params.lon0+dlon) * 180 / math.Pi, lat * 180 / math.Pi}
+		},
+		FromWGS84: func(p orb.Point) orb.Point {
+			x, y := forward(normalizeLongitude(p[0]*math.Pi/180-params.lon0), p[1]*math.Pi/180)
+			return orb.Point{(x + params.falseEasting) / unit, (y + params.falseNorthing) / unit}
+		},
+	}, nil
+}
+
+// normalizeLongitude wraps a longitude (in radians) to the [-π, π] range
+func normalizeLongitude(lon float64) float64 {
+	for lon > math.Pi {
+		lon -= 2 * math.Pi
+	}
+	for lon < -math.Pi {
+		lon += 2 * math.Pi
+	}
+	return lon
+}
+
+// conformalLatitude iteratively computes the latitude from the isometric parameter t, as used
+// by the inverse Mercator and Lambert Conformal Conic projections
+func conformalLatitude(e, t float64) float64 {
+	lat := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < projectionMaxIterations; i++ {
+		esin := e * math.Sin(lat)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-esin)/(1+esin), e/2))
+		if math.Abs(next-lat) < projectionTolerance {
+			return next
+		}
+		lat = next
+	}
+	return lat
+}
+
+// isometricT computes the t function of the conformal projections at the given latitude
+func isometricT(e, lat float64) float64 {
+	esin := e * math.Sin(lat)
+	return math.Tan(math.Pi/4-lat/2) / math.Pow((1-esin)/(1+esin), e/2)
+}
+
+// parallelRadius computes the m function, i.e. the (normalized) radius of the given parallel
+func parallelRadius(el ellipsoid, lat float64) float64 {
+	sin := math.Sin(lat)
+	return math.Cos(lat) / math.Sqrt(1-el.e2*sin*sin)
+}
+
+// meridianDistance computes the distance along the meridian from the equator to the given
+// latitude
+func meridianDistance(el ellipsoid, lat float64) float64 {
+	e2, e4, e6 := el.e2, el.e2*el.e2, el.e2*el.e2*el.e2
+	return el.a * ((1-e2/4-3*e4/64-5*e6/256)*lat -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*lat) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*lat) -
+		(35*e6/3072)*math.Sin(6*lat))
+}
+
+// transverseMercator computes the Transverse Mercator projection, using the series expansions
+// from Snyder's "Map Projections: A Working Manual"
+func transverseMercator(el ellipsoid, params projectionParams) (projectionFunc, projectionFunc) {
+	e2 := el.e2
+	ep2 := e2 / (1 - e2)
+	m0 := meridianDistance(el, params.lat0)
+	sqrt := math.Sqrt(1 - e2)
+	e1 := (1 - sqrt) / (1 + sqrt)
+	forward := func(dlon, lat float64) (float64, float64) {
+		sin, cos, tan := math.Sin(lat), math.Cos(lat), math.Tan(lat)
+		n := el.a / math.Sqrt(1-e2*sin*sin)
+		t := tan * tan
+		c := ep2 * cos * cos
+		a := dlon * cos
+		x := params.k0 * n * (a +
+			(1-t+c)*math.Pow(a, 3)/6 +
+			(5-18*t+t*t+72*c-58*ep2)*math.Pow(a, 5)/120)
+		y := params.k0 * (meridianDistance(el, lat) - m0 + n*tan*(a*a/2+
+			(5-t+9*c+4*c*c)*math.Pow(a, 4)/24+
+			(61-58*t+t*t+600*c-330*ep2)*math.Pow(a, 6)/720))
+		return x, y
+	}
+	inverse := func(x, y float64) (float64, float64) {
+		m := m0 + y/params.k0
+		mu := m / (el.a * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+		lat1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+			(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+			(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+			(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+		sin, cos, tan := math.Sin(lat1), math.Cos(lat1), math.Tan(lat1)
+		c1 := ep2 * cos * cos
+		t1 := tan * tan
+		n1 := el.a / math.Sqrt(1-e2*sin*sin)
+		r1 := el.a * (1 - e2) / math.Pow(1-e2*sin*sin, 1.5)
+		d := x / (n1 * params.k0)
+		lat := lat1 - (n1*tan/r1)*(d*d/2-
+			(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+			(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+		dlon := (d -
+			(1+2*t1+c1)*math.Pow(d, 3)/6 +
+			(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / cos
+		return dlon, lat
+	}
+	return forward, inverse
+}
+
+// mercator computes the (ellipsoidal or spherical) Mercator projection, where the scale is
+// either given directly or through a standard parallel
+func mercator(el ellipsoid, params projectionParams) (projectionFunc, projectionFunc) {
+	k0 := params.k0
+	if params.hasLat1 {
+		k0 = parallelRadius(el, params.lat1)
+	}
+	e := el.e()
+	forward := func(dlon, lat float64) (float64, float64) {
+		return el.a * k0 * dlon, -el.a * k0 * math.Log(isometricT(e, lat))
+	}
+	inverse := func(x, y float64) (float64, float64) {
+		t := math.Exp(-y / (el.a * k0))
+		return x / (el.a * k0), conformalLatitude(e, t)
+	}
+	return forward, inverse
+}
+
+// lambertConformalConic computes the Lambert Conformal Conic projection, with either one or two
+// standard parallels
+func lambertConformalConic(el ellipsoid, params projectionParams) (projectionFunc, projectionFunc) {
+	e := el.e()
+	lat1, lat2 := params.lat1, params.lat2
+	if !params.hasLat1 {
+		lat1, lat2 = params.lat0, params.lat0
+	}
+	m1, t1 := parallelRadius(el, lat1), isometricT(e, lat1)
+	n := math.Sin(lat1)
+	if math.Abs(lat1-lat2) > projectionTolerance {
+		m2, t2 := parallelRadius(el, lat2), isometricT(e, lat2)
+		n = (math.Log(m1) - math.Log(m2)) / (math.Log(t1) - math.Log(t2))
+	}
+	f := m1 / (n * math.Pow(t1, n))
+	rho0 := el.a * f * math.Pow(isometricT(e, params.lat0), n) * params.k0
+	sign := math.Copysign(1, n)
+	forward := func(dlon, lat float64) (float64, float64) {
+		rho := el.a * f * math.Pow(isometricT(e, lat), n) * params.k0
+		theta := n * dlon
+		return rho * math.Sin(theta), rho0 - rho*math.Cos(theta)
+	}
+	inverse := func(x, y float64) (float64, float64) {
+		rho := sign * math.Hypot(x, rho0-y)
+		theta := math.Atan2(sign*x, sign*(rho0-y))
+		t := math.Pow(rho/(el.a*f*params.k0), 1/n)
+		return theta / n, conformalLatitude(e, t)
+	}
+	return forward, inverse
+}
+
+// albersQ computes the q function of the Albers projection at the given latitude
+func albersQ(el ellipsoid, lat float64) float64 {
+	sin := math.Sin(lat)
+	if el.e2 == 0 {
+		return 2 * sin
+	}
+	e := el.e()
+	return (1 - el.e2) * (sin/(1-el.e2*sin*sin) - math.Log((1-e*sin)/(1+e*sin))/(2*e))
+}
+
+// albers computes the Albers Equal Area Conic projection
+func albers(el ellipsoid, params projectionParams) (projectionFunc, projectionFunc) {
+	lat1, lat2 := params.lat1, params.lat2
+	if !params.hasLat1 {
+		lat1, lat2 = params.lat0, params.lat0
+	}
+	m1, q1 := parallelRadius(el, lat1), albersQ(el, lat1)
+	n := math.Sin(lat1)
+	if math.Abs(lat1-lat2) > projectionTolerance {
+		m2, q2 := parallelRadius(el, lat2), albersQ(el, lat2)
+		n = (m1*m1 - m2*m2) / (q2 - q1)
+	}
+	c := m1*m1 + n*q1
+	rho0 := el.a * math.Sqrt(c-n*albersQ(el, params.lat0)) / n
+	sign := math.Copysign(1, n)
+	e := el.e()
+	forward := func(dlon, lat float64) (float64, float64) {
+		rho := el.a * math.Sqrt(c-n*albersQ(el, lat)) / n
+		theta := n * dlon
+		return rho * math.Sin(theta), rho0 - rho*math.Cos(theta)
+	}
+	inverse := func(x, y float64) (float64, float64) {
+		rho := math.Hypot(x, rho0-y)
+		theta := math.Atan2(sign*x, sign*(rho0-y))
+		q := (c - rho*rho*n*n/(el.a*el.a)) / n
+		lat := math.Asin(math.Max(-1, math.Min(1, q/2)))
+		if e > 0 {
+			for i := 0; i < projectionMaxIterations; i++ {
+				sin, cos := math.Sin(lat), math.Cos(lat)
+				esin := e * sin
+				one := 1 - el.e2*sin*sin
+				next := lat + one*one/(2*cos)*
+					(q/(1-el.e2)-sin/one+math.Log((1-esin)/(1+esin))/(2*e))
+				if math.Abs(next-lat) < projectionTolerance {
+					lat = next
+					break
+				}
+				lat = next
+			}
+		}
+		return theta / n, lat
+	}
+	return forward, inverse
+}
+
+// reprojectGeometry projects a geometry in place to WGS84, given a projection from
+// wgs84ProjectionFromWKT (where nil is a no-op)
+func reprojectGeometry(g orb.Geometry, proj orb.Projection) orb.Geometry {
+	if g == nil || proj == nil {
+		return g
+	}
+	return project.Geometry(g, proj)
+}
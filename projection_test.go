@@ -0,0 +1,148 @@
+package tilenol
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/project"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWKT(t *testing.T) {
+	node, err := parseWKT(`PROJCS["NAD83 / UTM zone 10N",GEOGCS["NAD83",DATUM["North_American_Datum_1983",` +
+		`SPHEROID["GRS 1980",6378137,298.257222101]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],` +
+		`PROJECTION["Transverse_Mercator"],PARAMETER["central_meridian",-123],UNIT["metre",1],` +
+		`AXIS["Easting",EAST],AUTHORITY["EPSG","26910"]]`)
+	assert.Nil(t, err)
+	assert.Equal(t, "PROJCS", node.Keyword)
+	assert.Equal(t, "NAD83 / UTM zone 10N", node.Name())
+	assert.Equal(t, "Transverse_Mercator", node.Child("PROJECTION").Name())
+	value, ok := node.Child("PARAMETER").Number(1)
+	assert.True(t, ok)
+	assert.Equal(t, -123.0, value)
+	assert.Equal(t, []interface{}{"Easting", "EAST"}, node.Child("AXIS").Args)
+
+	for _, wkt := range []string{
+		``,
+		`GEOGCS`,
+		`GEOGCS["WGS 84"`,
+		`GEOGCS["WGS 84] `,
+		`GEOGCS["WGS 84"] trailing`,
+		`GEOGCS["WGS 84";1]`,
+	} {
+		_, err := parseWKT(wkt)
+		assert.ErrorIs(t, err, InvalidWKTErr, wkt)
+	}
+}
+
+func TestWGS84ProjectionFromWKT(t *testing.T) {
+	// Note: the expected values are taken from the examples of the EPSG Guidance Note 7-2 and
+	// Snyder's "Map Projections: A Working Manual"
+	tests := []struct {
+		name     string
+		wkt      string
+		input    orb.Point
+		expected orb.Point
+	}{
+		{
+			name: "Transverse Mercator",
+			wkt: `PROJCS["OSGB 1936 / British National Grid",GEOGCS["OSGB 1936",DATUM["OSGB_1936",` +
+				`SPHEROID["Airy 1830",6377563.396,299.3249646]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],` +
+				`PROJECTION["Transverse_Mercator"],PARAMETER["latitude_of_origin",49],PARAMETER["central_meridian",-2],` +
+				`PARAMETER["scale_factor",0.9996012717],PARAMETER["false_easting",400000],` +
+				`PARAMETER["false_northing",-100000],UNIT["metre",1]]`,
+			input:    orb.Point{577274.99, 69740.50},
+			expected: orb.Point{0.5, 50.5},
+		},
+		{
+			name: "Lambert Conformal Conic (ESRI, US survey feet)",
+			wkt: `PROJCS["NAD_1927_StatePlane_Texas_South_Central_FIPS_4204",GEOGCS["GCS_North_American_1927",` +
+				`DATUM["D_North_American_1927",SPHEROID["Clarke_1866",6378206.4,294.9786982]],PRIMEM["Greenwich",0.0],` +
+				`UNIT["Degree",0.0174532925199433]],PROJECTION["Lambert_Conformal_Conic"],` +
+				`PARAMETER["False_Easting",2000000.0],PARAMETER["False_Northing",0.0],` +
+				`PARAMETER["Central_Meridian",-99.0],PARAMETER["Standard_Parallel_1",28.38333333333333],` +
+				`PARAMETER["Standard_Parallel_2",30.28333333333333],PARAMETER["Latitude_Of_Origin",27.83333333333333],` +
+				`UNIT["Foot_US",0.3048006096012192]]`,
+			input:    orb.Point{2963503.91, 254759.80},
+			expected: orb.Point{-96, 28.5},
+		},
+		{
+			name: "Mercator",
+			wkt: `PROJCS["Makassar / NEIEZ",GEOGCS["Makassar",DATUM["Makassar",` +
+				`SPHEROID["Bessel 1841",6377397.155,299.1528128]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],` +
+				`PROJECTION["Mercator_1SP"],PARAMETER["central_meridian",110],PARAMETER["scale_factor",0.997],` +
+				`PARAMETER["false_easting",3900000],PARAMETER["false_northing",900000],UNIT["metre",1]]`,
+			input:    orb.Point{5009726.58, 569150.82},
+			expected: orb.Point{120, -3},
+		},
+		{
+			name: "Albers Equal Area",
+			wkt: `PROJCS["USA_Contiguous_Albers_Equal_Area_Conic",GEOGCS["GCS_North_American_1927",` +
+				`DATUM["D_North_American_1927",SPHEROID["Clarke_1866",6378206.4,294.9786982]],PRIMEM["Greenwich",0.0],` +
+				`UNIT["Degree",0.0174532925199433]],PROJECTION["Albers"],PARAMETER["False_Easting",0.0],` +
+				`PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",-96.0],` +
+				`PARAMETER["Standard_Parallel_1",29.5],PARAMETER["Standard_Parallel_2",45.5],` +
+				`PARAMETER["Latitude_Of_Origin",23.0],UNIT["Meter",1.0]]`,
+			input:    orb.Point{1885472.7, 1535925.0},
+			expected: orb.Point{-75, 35},
+		},
+		{
+			name: "Web Mercator",
+			wkt: `PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",` +
+				`SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],` +
+				`PROJECTION["Mercator_Auxiliary_Sphere"],PARAMETER["False_Easting",0.0],PARAMETER["False_Northing",0.0],` +
+				`PARAMETER["Central_Meridian",0.0],PARAMETER["Standard_Parallel_1",0.0],` +
+				`PARAMETER["Auxiliary_Sphere_Type",0.0],UNIT["Meter",1.0]]`,
+			input:    orb.Point{-13627665.27, 4547675.35},
+			expected: project.Mercator.ToWGS84(orb.Point{-13627665.27, 4547675.35}),
+		},
+		{
+			name: "Geographic (grads)",
+			wkt: `GEOGCS["NTF (Paris)",DATUM["Nouvelle_Triangulation_Francaise_Paris",` +
+				`SPHEROID["Clarke 1880 (IGN)",6378249.2,293.4660212936269]],PRIMEM["Paris",2.33722917],` +
+				`UNIT["grad",0.01570796326794897]]`,
+			input:    orb.Point{0, 50},
+			expected: orb.Point{2.33722917, 45},
+		},
+	}
+	for _, test := range tests {
+		transform, err := crsTransformFromWKT(test.wkt)
+		if !assert.Nil(t, err, test.name) {
+			continue
+		}
+		actual := transform.ToWGS84(test.input)
+		assert.InDelta(t, test.expected[0], actual[0], 1e-6, test.name)
+		assert.InDelta(t, test.expected[1], actual[1], 1e-6, test.name)
+
+		// Note: the expected values are rounded to the centimeter (or its decimal equivalent)
+		actual = transform.FromWGS84(test.expected)
+		assert.InDelta(t, test.input[0], actual[0], 0.1, test.name)
+		assert.InDelta(t, test.input[1], actual[1], 0.1, test.name)
+	}
+}
+
+func TestCRSTransformBound(t *testing.T) {
+	// Lambert Conformal Conic projections curve parallels, so the projected bound must include
+	// the middle of the southern edge
+	transform, err := crsTransformFromWKT(`PROJCS["Conic",GEOGCS["WGS 84"],PROJECTION["Lambert_Conformal_Conic_1SP"],` +
+		`PARAMETER["latitude_of_origin",45],PARAMETER["central_meridian",0]]`)
+	assert.Nil(t, err)
+	bound := transform.Bound(orb.Bound{Min: orb.Point{-10, 40}, Max: orb.Point{10, 50}})
+	bottom := transform.FromWGS84(orb.Point{0, 40})
+	corner := transform.FromWGS84(orb.Point{10, 40})
+	assert.Less(t, bottom[1], corner[1])
+	assert.Equal(t, bottom[1], bound.Min[1])
+	assert.True(t, bound.Contains(transform.FromWGS84(orb.Point{-10, 50})))
+}
+
+func TestWGS84ProjectionFromWKTIdentity(t *testing.T) {
+	proj, err := wgs84ProjectionFromWKT(`GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",` +
+		`SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`)
+	assert.Nil(t, err)
+	assert.Nil(t, proj)
+
+	_, err = wgs84ProjectionFromWKT(`PROJCS["Unsupported",GEOGCS["WGS 84"],PROJECTION["Polyconic"]]`)
+	assert.ErrorIs(t, err, UnsupportedProjectionErr)
+	_, err = wgs84ProjectionFromWKT(`GEOCCS["WGS 84"]`)
+	assert.ErrorIs(t, err, UnsupportedProjectionErr)
+}
@@ -0,0 +1,186 @@
+package tilenol
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-chi/chi"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// MaxPurgeTiles is the maximum number of tiles that can be purged in a single request
+	MaxPurgeTiles = 1 << 20
+	// PurgeConcurrency is the number of concurrent cache deletions when purging tiles
+	PurgeConcurrency = 16
+)
+
+// PurgeResult is the summary of a cache purge request
+type PurgeResult struct {
+	Layer   string     `json:"layer"`
+	Bounds  [4]float64 `json:"bounds"`
+	Minzoom int        `json:"minzoom"`
+	Maxzoom int        `json:"maxzoom"`
+	Tiles   uint64     `json:"tiles"`
+	Errors  uint64     `json:"errors"`
+}
+
+// requireAdminToken is a router middleware that authenticates admin requests using the
+// configured bearer token. Note that admin endpoints are disabled if no token is configured.
+func (s *Server) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.AdminToken == "" {
+			http.Error(w, "Admin API is disabled", http.StatusForbidden)
+			return
+		}
+		token, hasScheme := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !hasScheme || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			Logger.Warningf("Unauthorized admin request: %s %s", r.Method, r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ParseBound parses a bounding box of the form "minLon,minLat,maxLon,maxLat"
+func ParseBound(s string) (orb.Bound, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return orb.Bound{}, InvalidRequestError{fmt.Sprintf("Invalid bounding box: [%s].", s)}
+	}
+	var coords [4]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return orb.Bound{}, InvalidRequestError{fmt.Sprintf("Invalid bounding box: [%s].", s)}
+		}
+		coords[i] = v
+	}
+	if coords[0] > coords[2] || coords[1] > coords[3] {
+		return orb.Bound{}, InvalidRequestError{fmt.Sprintf("Invalid bounding box: [%s].", s)}
+	}
+	return orb.Bound{Min: orb.Point{coords[0], coords[1]}, Max: orb.Point{coords[2], coords[3]}}, nil
+}
+
+// parseZoomParam parses an optional zoom level query parameter
+func parseZoomParam(r *http.Request, name string, defaultZoom int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return defaultZoom, nil
+	}
+	z, err := strconv.Atoi(v)
+	if err != nil || z < MinZoom || z > MaxZoom {
+		return 0, InvalidRequestError{fmt.Sprintf("Invalid %s: [%s].", name, v)}
+	}
+	return z, nil
+}
+
+// PurgeTiles deletes the cached tiles of a layer within the given bound and zoom range,
+// returning the number of tiles purged along with the number of failed deletions. If the cache
+// implements TilePurger, the cached tiles of the layer are scanned, which also purges the
+// entries of requests with extra query arguments (and counts deleted entries rather than
+// tiles). Otherwise, every tile is deleted individually, which is limited to MaxPurgeTiles and
+// only purges the entries of requests without extra query arguments.
+func (s *Server) PurgeTiles(layer Layer, bound orb.Bound, minZoom, maxZoom int) (uint64, uint64, error) {
+	ranges := TileRangesForBound(bound, minZoom, maxZoom)
+	if purger, ok := s.Cache.(TilePurger); ok {
+		count, err := purger.PurgeTiles(layer.String(), func(t maptile.Tile) bool {
+			for _, tr := range ranges {
+				if tr.Contains(t) {
+					return true
+				}
+			}
+			return false
+		})
+		if err != nil {
+			return count, 0, fmt.Errorf("Failed to purge layer [%s] after %d entries: %w", layer.Name, count, err)
+		}
+		return count, 0, nil
+	}
+
+	count := CountTiles(ranges)
+	if count > MaxPurgeTiles {
+		return 0, 0, InvalidRequestError{fmt.Sprintf(
+			"Purge would affect %d tiles (max %d), please narrow the bounding box or zoom range.",
+			count, MaxPurgeTiles)}
+	}
+
+	var errCount uint64
+	eg := new(errgroup.Group)
+	eg.SetLimit(PurgeConcurrency)
+	layerKey := layer.String()
+	for _, tr := range ranges {
+		tr.Each(func(t maptile.Tile) error {
+			req := &TileRequest{X: int(t.X), Y: int(t.Y), Z: int(t.Z)}
+			cacheKey := fmt.Sprintf("%s/%s", layerKey, req.String())
+			eg.Go(func() error {
+				if err := s.Cache.Delete(cacheKey); err != nil {
+					Logger.Warningf("Failed to purge cache key [%s]: %s", cacheKey, err)
+					atomic.AddUint64(&errCount, 1)
+				}
+				return nil
+			})
+			return nil
+		})
+	}
+	eg.Wait()
+	return count, errCount, nil
+}
+
+// purgeLayer implements the admin endpoint for purging the cached tiles of a layer, optionally
+// limited to a bounding box (bbox) and zoom range (minzoom, maxzoom)
+func (s *Server) purgeLayer(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	layers := filterLayersByNames(s.Layers, []string{name})
+	if len(layers) == 0 {
+		s.handleError(NotFoundError{fmt.Sprintf("No layer found for [%s].", name)}, w, r)
+		return
+	}
+	layer := layers[0]
+
+	bound := WorldBound
+	if bbox := r.URL.Query().Get("bbox"); bbox != "" {
+		var err error
+		if bound, err = ParseBound(bbox); err != nil {
+			s.handleError(err, w, r)
+			return
+		}
+	}
+	minZoom, err := parseZoomParam(r, "minzoom", layer.Minzoom)
+	if err != nil {
+		s.handleError(err, w, r)
+		return
+	}
+	maxZoom, err := parseZoomParam(r, "maxzoom", effectiveMaxzoom(layer))
+	if err != nil {
+		s.handleError(err, w, r)
+		return
+	}
+	if minZoom > maxZoom {
+		s.handleError(InvalidRequestError{fmt.Sprintf("Invalid zoom range: [%d, %d].", minZoom, maxZoom)}, w, r)
+		return
+	}
+
+	tiles, errCount, err := s.PurgeTiles(layer, bound, minZoom, maxZoom)
+	if err != nil {
+		s.handleError(err, w, r)
+		return
+	}
+	Logger.Infof("Purged %d tiles for layer [%s] (%d errors)", tiles, layer.Name, errCount)
+
+	s.writeJSON(w, r, &PurgeResult{
+		Layer:   layer.Name,
+		Bounds:  [4]float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]},
+		Minzoom: minZoom,
+		Maxzoom: maxZoom,
+		Tiles:   tiles,
+		Errors:  errCount,
+	})
+}
@@ -0,0 +1,124 @@
+package tilenol
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+func purgeRequest(server *Server, path, token string) *httptest.ResponseRecorder {
+	_, internal := server.setupRoutes()
+	r := httptest.NewRequest("POST", path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	internal.ServeHTTP(w, r)
+	return w
+}
+
+func TestPurgeRequiresToken(t *testing.T) {
+	layers := []Layer{Layer{Name: "a", Cacheable: true, source: &NilSource{}}}
+
+	server := &Server{Layers: layers, Cache: &NilCache{}}
+	w := purgeRequest(server, "/admin/layers/a/purge", "secret")
+	assert.Equal(t, 403, w.Result().StatusCode, "Admin API is disabled without a token")
+
+	server = &Server{Layers: layers, Cache: &NilCache{}, AdminToken: "secret"}
+	w = purgeRequest(server, "/admin/layers/a/purge?maxzoom=2", "")
+	assert.Equal(t, 401, w.Result().StatusCode)
+	w = purgeRequest(server, "/admin/layers/a/purge?maxzoom=2", "wrong")
+	assert.Equal(t, 401, w.Result().StatusCode)
+
+	// The token must be given with the Bearer scheme
+	_, internal := server.setupRoutes()
+	r := httptest.NewRequest("POST", "/admin/layers/a/purge?maxzoom=2", nil)
+	r.Header.Set("Authorization", "secret")
+	w = httptest.NewRecorder()
+	internal.ServeHTTP(w, r)
+	assert.Equal(t, 401, w.Result().StatusCode)
+
+	w = purgeRequest(server, "/admin/layers/a/purge?maxzoom=2", "secret")
+	assert.Equal(t, 200, w.Result().StatusCode)
+}
+
+func TestPurgeLayer(t *testing.T) {
+	cache := NewInMemoryCache().(*InMemoryCache)
+	layers := []Layer{
+		Layer{Name: "a", Cacheable: true, source: &pointSource{orb.Point{1, 1}}},
+		Layer{Name: "b", Cacheable: true, source: &pointSource{orb.Point{1, 1}}},
+	}
+	server := &Server{Layers: layers, Cache: cache, AdminToken: "secret"}
+	handler, _ := server.setupRoutes()
+
+	requestTile(handler, "/a/1/1/0.mvt")
+	requestTile(handler, "/a/1/1/0.mvt?s=name")
+	requestTile(handler, "/a/1/0/0.mvt")
+	requestTile(handler, "/b/1/1/0.mvt")
+	assert.Equal(t, 4, cache.Len())
+
+	w := purgeRequest(server, "/admin/layers/a/purge?bbox=0,0,10,10&minzoom=1&maxzoom=1", "secret")
+	res := w.Result()
+	assert.Equal(t, 200, res.StatusCode)
+
+	var result PurgeResult
+	err := json.NewDecoder(res.Body).Decode(&result)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", result.Layer)
+	assert.Equal(t, uint64(2), result.Tiles)
+	assert.Equal(t, uint64(0), result.Errors)
+
+	assert.Equal(t, 2, cache.Len(), "Only the tile within the bbox should be purged (including query variants)")
+	assert.False(t, cache.Exists(layers[0].String()+"/1/1/0"))
+	assert.True(t, cache.Exists(layers[0].String()+"/1/0/0"))
+	assert.True(t, cache.Exists(layers[1].String()+"/1/1/0"))
+
+	// Without a bbox or zoom range, the whole layer is purged
+	w = purgeRequest(server, "/admin/layers/a/purge", "secret")
+	assert.Equal(t, 200, w.Result().StatusCode)
+	assert.Equal(t, 1, cache.Len())
+	assert.True(t, cache.Exists(layers[1].String()+"/1/1/0"))
+}
+
+func TestPurgeTilesIndividually(t *testing.T) {
+	// Caches that can't scan their tiles are purged tile by tile
+	cache := &countingCache{Cache: NewInMemoryCache()}
+	layer := Layer{Name: "a", Cacheable: true, source: &NilSource{}}
+	server := &Server{Layers: []Layer{layer}, Cache: cache}
+	cache.Put(layer.String()+"/1/1/0", []byte("tile"))
+	cache.Put(layer.String()+"/1/1/0?s=name", []byte("tile"))
+
+	tiles, errCount, err := server.PurgeTiles(layer, orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{10, 10}}, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), tiles)
+	assert.Equal(t, uint64(0), errCount)
+	assert.False(t, cache.Exists(layer.String()+"/1/1/0"))
+	assert.True(t, cache.Exists(layer.String()+"/1/1/0?s=name"), "Query variants can't be purged tile by tile")
+
+	_, _, err = server.PurgeTiles(layer, WorldBound, 0, MaxZoom)
+	assert.IsType(t, InvalidRequestError{}, err, "Too many tiles can't be purged tile by tile")
+}
+
+func TestPurgeInvalidRequests(t *testing.T) {
+	layers := []Layer{Layer{Name: "a", Cacheable: true, source: &NilSource{}}}
+	server := &Server{Layers: layers, Cache: &NilCache{}, AdminToken: "secret"}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/admin/layers/missing/purge", 404},
+		{"/admin/layers/a/purge?bbox=1,2,3", 400},
+		{"/admin/layers/a/purge?bbox=10,0,0,10", 400},
+		{"/admin/layers/a/purge?minzoom=abc", 400},
+		{"/admin/layers/a/purge?minzoom=5&maxzoom=2", 400},
+		{"/admin/layers/a/purge", 200},
+	}
+	for _, test := range tests {
+		w := purgeRequest(server, test.path, "secret")
+		assert.Equal(t, test.status, w.Result().StatusCode, test.path)
+	}
+}
@@ -1,12 +1,23 @@
 package tilenol
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
+	"github.com/paulmach/orb/maptile"
 )
 
+const (
+	// redisScanCount is the number of keys requested per SCAN iteration when purging tiles
+	redisScanCount = 1000
+)
+
+// redisPatternEscaper escapes the special characters of Redis glob-style patterns
+var redisPatternEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
 // RedisConfig is the YAML configuration for a RedisCache
 type RedisConfig struct {
 	// Host is the Redis cluster host name
@@ -72,3 +83,49 @@ func (r *RedisCache) Put(key string, val []byte) error {
 	}
 	return nil
 }
+
+// Delete removes the value stored in Redis at a given key
+func (r *RedisCache) Delete(key string) error {
+	return r.Client.Del(key).Err()
+}
+
+// PurgeTiles implements the TilePurger interface by scanning the keys of the layer. Note that
+// this iterates over the whole keyspace (in batches), so it should only be used sparingly.
+func (r *RedisCache) PurgeTiles(layerKey string, match func(maptile.Tile) bool) (uint64, error) {
+	prefix := layerKey + "/"
+	pattern := redisPatternEscaper.Replace(prefix) + "*"
+	var count, cursor uint64
+	for {
+		keys, next, err := r.Client.Scan(cursor, pattern, redisScanCount).Result()
+		if err != nil {
+			return count, err
+		}
+		var matched []string
+		for _, key := range keys {
+			if tile, ok := parseTilePath(strings.TrimPrefix(key, prefix)); ok && match(tile) {
+				matched = append(matched, key)
+			}
+		}
+		if len(matched) > 0 {
+			deleted, err := r.Client.Del(matched...).Result()
+			if err != nil {
+				return count, err
+			}
+			count += uint64(deleted)
+		}
+		if next == 0 {
+			return count, nil
+		}
+		cursor = next
+	}
+}
+
+// HealthCheck implements the HealthChecker interface by pinging the Redis server
+func (r *RedisCache) HealthCheck(ctx context.Context) error {
+	return r.Client.WithContext(ctx).Ping().Err()
+}
+
+// Close closes the underlying Redis client
+func (r *RedisCache) Close() error {
+	return r.Client.Close()
+}
@@ -0,0 +1,245 @@
+package tilenol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/paulmach/orb/maptile"
+)
+
+const (
+	// S3Timeout is the maximum time to wait for a single S3 cache operation
+	S3Timeout = 10 * time.Second
+	// S3DefaultRegion is the region used when none is configured, which also avoids an extra
+	// bucket location lookup on each request
+	S3DefaultRegion = "us-east-1"
+	// S3LayerHashMetadata is the object metadata key that stores the layer hash
+	S3LayerHashMetadata = "Layer-Hash"
+	// S3CreatedAtMetadata is the object metadata key that stores the entry creation time
+	S3CreatedAtMetadata = "Created-At"
+)
+
+var (
+	// MissingS3BucketErr occurs when an S3Cache is configured without a bucket
+	MissingS3BucketErr = errors.New("S3Cache requires a \"bucket\" to be configured")
+)
+
+// S3Config is the YAML configuration for an S3Cache
+type S3Config struct {
+	// Endpoint is the host (and optional port) of the S3-compatible API
+	Endpoint string `yaml:"endpoint"`
+	// Region is the bucket region (optional)
+	Region string `yaml:"region"`
+	// Bucket is the name of the bucket to store cached data in
+	Bucket string `yaml:"bucket"`
+	// Prefix is an optional key prefix for all cached objects
+	Prefix string `yaml:"prefix"`
+	// AccessKeyID is the access key used to authenticate (optional, otherwise credentials are
+	// taken from the environment or instance metadata)
+	AccessKeyID string `yaml:"accessKeyId"`
+	// SecretAccessKey is the secret key used to authenticate
+	SecretAccessKey string `yaml:"secretAccessKey"`
+	// SessionToken is the optional session token used to authenticate
+	SessionToken string `yaml:"sessionToken"`
+	// Insecure disables TLS when talking to the endpoint
+	Insecure bool `yaml:"insecure"`
+	// TTL is how long each cache entry should remain before refresh (optional)
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// S3Cache is a Cache implementation that stores values as objects in an S3-compatible bucket
+type S3Cache struct {
+	// Client is the backend S3 client
+	Client *minio.Client
+	// Bucket is the name of the bucket to store cached data in
+	Bucket string
+	// Prefix is the key prefix for all cached objects
+	Prefix string
+	// TTL is how long each cache entry should remain before refresh
+	TTL time.Duration
+}
+
+// NewS3Cache creates a new S3Cache given an S3Config
+func NewS3Cache(config *S3Config) (Cache, error) {
+	if config.Bucket == "" {
+		return nil, MissingS3BucketErr
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	region := config.Region
+	if region == "" {
+		region = S3DefaultRegion
+	}
+	var creds *credentials.Credentials
+	if config.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(config.AccessKeyID, config.SecretAccessKey, config.SessionToken)
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !config.Insecure,
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Cache{
+		Client: client,
+		Bucket: config.Bucket,
+		Prefix: config.Prefix,
+		TTL:    config.TTL,
+	}, nil
+}
+
+// objectName computes the object key for a given cache key
+func (s *S3Cache) objectName(key string) string {
+	return path.Join(append([]string{s.Prefix}, cacheKeySegments(key)...)...)
+}
+
+// layerHash extracts the layer hash from a cache key of the form name@hash/z/x/y
+func layerHash(key string) string {
+	layer, _, _ := strings.Cut(key, "/")
+	if i := strings.LastIndex(layer, "@"); i >= 0 {
+		return layer[i+1:]
+	}
+	return ""
+}
+
+// isNotFound checks whether an S3 error indicates that the object doesn't exist
+func isNotFound(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	return resp.StatusCode == http.StatusNotFound || resp.Code == "NoSuchKey"
+}
+
+// expired checks whether or not a cached object's TTL has elapsed, based on its creation
+// time metadata (or last modified time if missing)
+func (s *S3Cache) expired(info minio.ObjectInfo) bool {
+	if s.TTL <= 0 {
+		return false
+	}
+	createdAt := info.LastModified
+	if v, ok := info.UserMetadata[S3CreatedAtMetadata]; ok {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			createdAt = t
+		}
+	}
+	return time.Since(createdAt) > s.TTL
+}
+
+// Exists checks whether or not there is an unexpired object for the given key
+func (s *S3Cache) Exists(key string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), S3Timeout)
+	defer cancel()
+	info, err := s.Client.StatObject(ctx, s.Bucket, s.objectName(key), minio.StatObjectOptions{})
+	if err != nil {
+		if !isNotFound(err) {
+			// Log an error in case the connection to S3 fails, but recompute the response
+			Logger.Errorf("Could not talk to S3: %v", err)
+		}
+		return false
+	}
+	return !s.expired(info)
+}
+
+// Get retrieves the cached object for a given key
+func (s *S3Cache) Get(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), S3Timeout)
+	defer cancel()
+	obj, err := s.Client.GetObject(ctx, s.Bucket, s.objectName(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNoValue
+		}
+		return nil, err
+	}
+	if s.expired(info) {
+		return nil, ErrNoValue
+	}
+	return io.ReadAll(obj)
+}
+
+// Put stores a new object at a given key, along with metadata for the layer hash and
+// creation time
+func (s *S3Cache) Put(key string, val []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), S3Timeout)
+	defer cancel()
+	opts := minio.PutObjectOptions{
+		ContentType: "application/x-protobuf",
+		UserMetadata: map[string]string{
+			S3LayerHashMetadata: layerHash(key),
+			S3CreatedAtMetadata: time.Now().UTC().Format(time.RFC3339Nano),
+		},
+	}
+	_, err := s.Client.PutObject(ctx, s.Bucket, s.objectName(key), bytes.NewReader(val), int64(len(val)), opts)
+	if err != nil {
+		Logger.Errorf("Could not store key [%s] in S3: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+// Delete removes the cached object for a given key
+func (s *S3Cache) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), S3Timeout)
+	defer cancel()
+	return s.Client.RemoveObject(ctx, s.Bucket, s.objectName(key), minio.RemoveObjectOptions{})
+}
+
+// PurgeTiles implements the TilePurger interface by listing the objects of the layer
+func (s *S3Cache) PurgeTiles(layerKey string, match func(maptile.Tile) bool) (uint64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := path.Join(s.Prefix, escapeKeySegment(layerKey)) + "/"
+	objects := s.Client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	var count uint64
+	for obj := range objects {
+		if obj.Err != nil {
+			return count, obj.Err
+		}
+		tile, ok := parseTilePath(strings.TrimPrefix(obj.Key, prefix))
+		if !ok || !match(tile) {
+			continue
+		}
+		removeCtx, removeCancel := context.WithTimeout(ctx, S3Timeout)
+		err := s.Client.RemoveObject(removeCtx, s.Bucket, obj.Key, minio.RemoveObjectOptions{})
+		removeCancel()
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// HealthCheck implements the HealthChecker interface by checking that the bucket exists
+func (s *S3Cache) HealthCheck(ctx context.Context) error {
+	exists, err := s.Client.BucketExists(ctx, s.Bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("S3 bucket [%s] does not exist", s.Bucket)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package tilenol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeS3Object struct {
+	body   []byte
+	header http.Header
+}
+
+// fakeS3 is a minimal in-memory stand-in for an S3-compatible object store
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			body = decodeAWSChunked(body)
+		}
+		header := make(http.Header)
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Amz-Meta-") || k == "Content-Type" {
+				header[k] = v
+			}
+		}
+		header.Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		header.Set("ETag", "\"etag\"")
+		f.objects[r.URL.Path] = fakeS3Object{body, header}
+		w.Header().Set("ETag", "\"etag\"")
+	case "GET", "HEAD":
+		if r.URL.Query().Get("list-type") == "2" {
+			f.listObjects(w, r)
+			return
+		}
+		obj, exists := f.objects[r.URL.Path]
+		if !exists {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == "GET" {
+				w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>Not found</Message></Error>`))
+			}
+			return
+		}
+		for k, v := range obj.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.body)))
+		if r.Method == "GET" {
+			w.Write(obj.body)
+		}
+	case "DELETE":
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// listObjects responds with every object of the bucket under the requested prefix, in a
+// single (ListObjectsV2) page
+func (f *fakeS3) listObjects(w http.ResponseWriter, r *http.Request) {
+	bucket := strings.TrimSuffix(r.URL.Path, "/") + "/"
+	prefix := r.URL.Query().Get("prefix")
+	var keys []string
+	for name := range f.objects {
+		if key := strings.TrimPrefix(name, bucket); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<ListBucketResult><Prefix>%s</Prefix><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>`, prefix, len(keys))
+	for _, key := range keys {
+		fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, key, len(f.objects[bucket+key].body))
+	}
+	fmt.Fprint(w, `</ListBucketResult>`)
+}
+
+// decodeAWSChunked strips the chunk headers from a streaming signed S3 upload body
+func decodeAWSChunked(body []byte) []byte {
+	var out []byte
+	for len(body) > 0 {
+		line, rest, _ := strings.Cut(string(body), "\r\n")
+		sizeHex, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size == 0 {
+			break
+		}
+		out = append(out, rest[:size]...)
+		body = []byte(rest[size+2:])
+	}
+	return out
+}
+
+func newTestS3Cache(t *testing.T, ttl time.Duration) (*S3Cache, *fakeS3) {
+	fake := &fakeS3{objects: make(map[string]fakeS3Object)}
+	ts := httptest.NewServer(fake)
+	t.Cleanup(ts.Close)
+	u, _ := url.Parse(ts.URL)
+	cache, err := NewS3Cache(&S3Config{
+		Endpoint:        u.Host,
+		Bucket:          "tiles",
+		Prefix:          "cache",
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+		Insecure:        true,
+		TTL:             ttl,
+	})
+	if err != nil {
+		t.Fatalf("Could not create S3Cache: %v", err)
+	}
+	return cache.(*S3Cache), fake
+}
+
+func TestS3CachePutGet(t *testing.T) {
+	cache, fake := newTestS3Cache(t, 0)
+	key := "buildings@abc/14/2620/6332"
+
+	assert.False(t, cache.Exists(key))
+	_, err := cache.Get(key)
+	assert.Equal(t, ErrNoValue, err)
+
+	assert.Nil(t, cache.Put(key, []byte("tile")))
+	assert.True(t, cache.Exists(key))
+	val, err := cache.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("tile"), val)
+
+	obj, exists := fake.objects["/tiles/cache/buildings@abc/14/2620/6332"+TileDataExtension]
+	assert.True(t, exists, "Expected object to be stored under the configured prefix")
+	assert.Equal(t, "abc", obj.header.Get("X-Amz-Meta-"+S3LayerHashMetadata))
+	assert.NotEmpty(t, obj.header.Get("X-Amz-Meta-"+S3CreatedAtMetadata))
+}
+
+func TestS3CacheTTL(t *testing.T) {
+	cache, fake := newTestS3Cache(t, time.Hour)
+	key := "buildings@abc/14/2620/6332"
+	assert.Nil(t, cache.Put(key, []byte("tile")))
+	assert.True(t, cache.Exists(key))
+
+	// Backdate the creation time so that the object is expired
+	for _, obj := range fake.objects {
+		obj.header.Set("X-Amz-Meta-"+S3CreatedAtMetadata, time.Now().Add(-2*time.Hour).Format(time.RFC3339Nano))
+	}
+	assert.False(t, cache.Exists(key))
+	_, err := cache.Get(key)
+	assert.Equal(t, ErrNoValue, err)
+}
+
+func TestS3CachePurgeTiles(t *testing.T) {
+	cache, fake := newTestS3Cache(t, 0)
+	for _, key := range []string{"a@1/1/1/0", "a@1/1/1/0?s=name", "a@1/1/0/0", "b@1/1/1/0"} {
+		assert.Nil(t, cache.Put(key, []byte("tile")))
+	}
+	count, err := cache.PurgeTiles("a@1", func(tile maptile.Tile) bool {
+		return tile.X == 1
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+	assert.Len(t, fake.objects, 2)
+	assert.True(t, cache.Exists("a@1/1/0/0"))
+	assert.True(t, cache.Exists("b@1/1/1/0"))
+}
+
+func TestLayerHashFromKey(t *testing.T) {
+	assert.Equal(t, "abc", layerHash("buildings@abc/14/2620/6332?q=a@b"))
+	assert.Equal(t, "", layerHash("nohash/0/0/0"))
+}
+
+func TestCreateS3Cache(t *testing.T) {
+	cache, err := CreateCache(&CacheConfig{S3: &S3Config{Bucket: "tiles"}})
+	assert.Nil(t, err)
+	assert.IsType(t, &S3Cache{}, cache)
+
+	_, err = CreateCache(&CacheConfig{S3: &S3Config{}})
+	assert.Equal(t, MissingS3BucketErr, err)
+}
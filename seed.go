@@ -0,0 +1,377 @@
+package tilenol
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/paulmach/orb/maptile"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// DefaultSeedConcurrency is the default number of tiles seeded concurrently
+	DefaultSeedConcurrency = 8
+	// DefaultProgressInterval is the default interval between seed and export progress reports
+	DefaultProgressInterval = 10 * time.Second
+	// SeedBatchSize is the number of tiles seeded between each checkpoint of the seed state
+	SeedBatchSize = 1024
+)
+
+var (
+	// NoCacheableLayersErr is the error returned when none of the layers to seed are cacheable
+	NoCacheableLayersErr = errors.New("None of the requested layers are cacheable")
+)
+
+// SeedOptions configures a cache seeding job
+type SeedOptions struct {
+	// Layers is the list of layer names to seed (all layers if empty or AllLayers)
+	Layers []string
+	// Cover is the set of tiles to seed
+	Cover TileCover
+	// Concurrency is the maximum number of tiles seeded concurrently
+	Concurrency int
+	// StateFile is the path of the file used to checkpoint the progress of the job, so that
+	// an interrupted job can be resumed (optional)
+	StateFile string
+	// ProgressInterval is the interval between progress reports
+	ProgressInterval time.Duration
+}
+
+// SeedResult is the summary of a cache seeding job
+type SeedResult struct {
+	// Total is the total number of tiles in the job
+	Total uint64
+	// Skipped is the number of tiles skipped as they were seeded by a previous run
+	Skipped uint64
+	// Seeded is the number of tiles seeded by this run
+	Seeded uint64
+	// Errors is the number of tiles that failed to be seeded
+	Errors uint64
+}
+
+// seedState is the checkpoint of a seeding job, which is persisted to the state file
+type seedState struct {
+	// Job is the hash of the job options, to avoid resuming a different job
+	Job string `json:"job"`
+	// Completed is the number of tiles (in TileCover order) that have been processed
+	Completed uint64 `json:"completed"`
+	// Failed is the list of processed tiles that failed to be seeded, which are retried when
+	// resuming the job
+	Failed []maptile.Tile `json:"failed,omitempty"`
+}
+
+// jobHash computes a hash of the seed options and layers, so that the state of a job can only
+// be resumed by the same job
+func (o *SeedOptions) jobHash(layers []Layer) string {
+	hash := sha256.New()
+	for _, layer := range layers {
+		fmt.Fprintln(hash, layer.String())
+	}
+	fmt.Fprintf(hash, "%v %d %d\n", o.Cover.Bound, o.Cover.MinZoom, o.Cover.MaxZoom)
+	if o.Cover.Geometry != nil {
+		fmt.Fprintf(hash, "%v\n", o.Cover.Geometry)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// loadSeedState reads the seed state file, returning an empty state if it doesn't exist
+func loadSeedState(path string) (*seedState, error) {
+	state := &seedState{}
+	if path == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Invalid seed state file [%s]: %w", path, err)
+	}
+	return state, nil
+}
+
+// save atomically writes the seed state to the given file
+func (s *seedState) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// reportEvery periodically calls report in the background until the returned stop function
+// is called
+func reportEvery(interval time.Duration, report func()) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// layersByNames resolves a list of layer names (all layers if empty or AllLayers) to the
+// configured layers
+func (s *Server) layersByNames(names []string) ([]Layer, error) {
+	if len(names) == 0 || (len(names) == 1 && names[0] == AllLayers) {
+		return s.Layers, nil
+	}
+	layers := filterLayersByNames(s.Layers, names)
+	if len(layers) == 0 {
+		return nil, NotFoundError{fmt.Sprintf("No layers found for %v.", names)}
+	}
+	return layers, nil
+}
+
+// seedLayers resolves the cacheable layers to be seeded from the layer names
+func (s *Server) seedLayers(names []string) ([]Layer, error) {
+	layers, err := s.layersByNames(names)
+	if err != nil {
+		return nil, err
+	}
+	var cacheable []Layer
+	for _, layer := range layers {
+		if !layer.Cacheable {
+			Logger.Warningf("Skipping layer [%s] as it is not cacheable", layer.Name)
+			continue
+		}
+		cacheable = append(cacheable, layer)
+	}
+	if len(cacheable) == 0 {
+		return nil, NoCacheableLayersErr
+	}
+	return cacheable, nil
+}
+
+// seedCovers splits the cover into a cover per zoom level at which any of the layers is
+// visible, since there is nothing to seed at the other zoom levels
+func (o *SeedOptions) seedCovers(layers []Layer) []*TileCover {
+	var covers []*TileCover
+	for z := o.Cover.MinZoom; z <= o.Cover.MaxZoom; z++ {
+		if len(filterLayersByZoom(layers, z)) == 0 {
+			continue
+		}
+		covers = append(covers, &TileCover{Bound: o.Cover.Bound, Geometry: o.Cover.Geometry, MinZoom: z, MaxZoom: z})
+	}
+	return covers
+}
+
+// countCoverTiles returns the total number of tiles in the given covers
+func countCoverTiles(covers []*TileCover) (uint64, error) {
+	var total uint64
+	for _, cover := range covers {
+		count, err := cover.Count()
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// CountSeedTiles returns the number of tiles that a seeding job would process, i.e. the tiles
+// of the cover at the zoom levels where any of the cacheable layers to seed is visible
+func (s *Server) CountSeedTiles(opts SeedOptions) (uint64, error) {
+	layers, err := s.seedLayers(opts.Layers)
+	if err != nil {
+		return 0, err
+	}
+	return countCoverTiles(opts.seedCovers(layers))
+}
+
+// seedTile populates the cache with the data of every layer visible at the tile's zoom level
+func (s *Server) seedTile(ctx context.Context, layers []Layer, t maptile.Tile) error {
+	req := &TileRequest{X: int(t.X), Y: int(t.Y), Z: int(t.Z)}
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, layer := range filterLayersByZoom(layers, req.Z) {
+		layer := layer
+		eg.Go(func() error {
+			_, err := s.getLayerData(ctx, layer, req)
+			return err
+		})
+	}
+	return eg.Wait()
+}
+
+// Seed populates the cache with the data of the given layers for every tile in the cover (at
+// the zoom levels where any of the layers is visible), resuming from the state file if a
+// previous run of the same job was interrupted. Note that individual tile failures are logged
+// and counted rather than aborting the job, and are recorded in the state file so that they
+// are retried when resuming.
+func (s *Server) Seed(ctx context.Context, opts SeedOptions) (*SeedResult, error) {
+	if s.Metrics == nil {
+		s.Metrics = NewMetrics()
+	}
+	layers, err := s.seedLayers(opts.Layers)
+	if err != nil {
+		return nil, err
+	}
+	covers := opts.seedCovers(layers)
+	total, err := countCoverTiles(covers)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = DefaultSeedConcurrency
+	}
+	progressInterval := opts.ProgressInterval
+	if progressInterval <= 0 {
+		progressInterval = DefaultProgressInterval
+	}
+
+	job := opts.jobHash(layers)
+	state, err := loadSeedState(opts.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	if state.Job != job {
+		if state.Job != "" {
+			Logger.Warningf("Seed state file [%s] belongs to a different job, starting over", opts.StateFile)
+		}
+		state = &seedState{Job: job}
+	}
+
+	retries := len(state.Failed)
+	result := &SeedResult{Total: total, Skipped: state.Completed - uint64(retries)}
+	if state.Completed > 0 {
+		Logger.Infof("Resuming seed job after %d of %d tiles (retrying %d failed tiles)", state.Completed, total, retries)
+	}
+
+	var processed, errCount uint64
+	start := time.Now()
+	reportProgress := func() {
+		n := atomic.LoadUint64(&processed)
+		done := result.Skipped + n
+		rate := float64(n) / time.Since(start).Seconds()
+		Logger.Infof("Seeded %d/%d tiles (%.1f%%), %d errors, %.1f tiles/s",
+			done, total, 100*float64(done)/float64(max(total, 1)), atomic.LoadUint64(&errCount), rate)
+	}
+
+	stopProgress := reportEvery(progressInterval, reportProgress)
+
+	// Tiles are seeded in batches so that the state can be checkpointed at a position where
+	// every previous tile has been processed
+	var mu sync.Mutex
+	seedBatch := func(batch []maptile.Tile) error {
+		eg := new(errgroup.Group)
+		eg.SetLimit(concurrency)
+		for _, t := range batch {
+			t := t
+			eg.Go(func() error {
+				if err := s.seedTile(ctx, layers, t); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					Logger.Warningf("Failed to seed tile (%d, %d, %d): %s", t.Z, t.X, t.Y, err)
+					atomic.AddUint64(&errCount, 1)
+					mu.Lock()
+					state.Failed = append(state.Failed, t)
+					mu.Unlock()
+				}
+				atomic.AddUint64(&processed, 1)
+				return nil
+			})
+		}
+		return eg.Wait()
+	}
+	checkpoint := func() {
+		if err := state.save(opts.StateFile); err != nil {
+			Logger.Warningf("Failed to save seed state [%s]: %s", opts.StateFile, err)
+		}
+	}
+
+	// Note: the tiles that failed in a previous run are retried first, and are kept at the front
+	// of the failed tiles (followed by any new failures) until they have been processed
+	for retries > 0 && err == nil {
+		n := min(retries, SeedBatchSize)
+		batch := append([]maptile.Tile{}, state.Failed[:n]...)
+		if err = seedBatch(batch); err == nil {
+			state.Failed = state.Failed[n:]
+			retries -= n
+			checkpoint()
+		}
+	}
+
+	var index uint64
+	batch := make([]maptile.Tile, 0, SeedBatchSize)
+	seedCompleted := func() error {
+		if err := seedBatch(batch); err != nil {
+			return err
+		}
+		state.Completed += uint64(len(batch))
+		batch = batch[:0]
+		checkpoint()
+		return nil
+	}
+	for _, cover := range covers {
+		if err != nil {
+			break
+		}
+		err = cover.Each(func(t maptile.Tile) error {
+			index++
+			if index <= state.Completed {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			batch = append(batch, t)
+			if len(batch) == SeedBatchSize {
+				return seedCompleted()
+			}
+			return nil
+		})
+	}
+	if err == nil && len(batch) > 0 {
+		err = seedCompleted()
+	}
+
+	stopProgress()
+	reportProgress()
+
+	// Wait for any background refreshes triggered by stale cache entries
+	s.refreshes.Wait()
+	result.Errors = atomic.LoadUint64(&errCount)
+	result.Seeded = atomic.LoadUint64(&processed) - result.Errors
+	return result, err
+}
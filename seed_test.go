@@ -0,0 +1,144 @@
+package tilenol
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeed(t *testing.T) {
+	cache := NewInMemoryCache().(*InMemoryCache)
+	layers := []Layer{
+		Layer{Name: "a", Cacheable: true, source: &pointSource{orb.Point{1, 1}}},
+		Layer{Name: "b", Cacheable: true, Minzoom: 2, source: &pointSource{orb.Point{1, 1}}},
+		Layer{Name: "c", source: &pointSource{orb.Point{1, 1}}},
+	}
+	server := &Server{Layers: layers, Cache: cache}
+
+	result, err := server.Seed(context.Background(), SeedOptions{
+		Cover: TileCover{Bound: WorldBound, MinZoom: 0, MaxZoom: 2},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &SeedResult{Total: 21, Seeded: 21}, result)
+	assert.Equal(t, 21+16, cache.Len(), "Layer b should only be seeded from its minzoom")
+	assert.True(t, cache.Exists(layers[0].String()+"/1/1/0"))
+	assert.True(t, cache.Exists(layers[1].String()+"/2/3/3"))
+	assert.False(t, cache.Exists(layers[1].String()+"/1/1/0"))
+}
+
+func TestSeedNoCacheableLayers(t *testing.T) {
+	layers := []Layer{Layer{Name: "a", source: &NilSource{}}}
+	server := &Server{Layers: layers, Cache: NewInMemoryCache()}
+
+	_, err := server.Seed(context.Background(), SeedOptions{Layers: []string{"a"}})
+	assert.Equal(t, NoCacheableLayersErr, err)
+
+	_, err = server.Seed(context.Background(), SeedOptions{Layers: []string{"missing"}})
+	assert.IsType(t, NotFoundError{}, err)
+}
+
+func TestSeedResume(t *testing.T) {
+	cache := NewInMemoryCache().(*InMemoryCache)
+	layers := []Layer{Layer{Name: "a", Cacheable: true, source: &pointSource{orb.Point{1, 1}}}}
+	server := &Server{Layers: layers, Cache: cache}
+	opts := SeedOptions{
+		Cover:     TileCover{Bound: WorldBound, MinZoom: 0, MaxZoom: 2},
+		StateFile: filepath.Join(t.TempDir(), "seed.json"),
+	}
+
+	// Simulate a previous run that was interrupted after the first 5 tiles
+	state := &seedState{Job: opts.jobHash(layers), Completed: 5}
+	assert.Nil(t, state.save(opts.StateFile))
+
+	result, err := server.Seed(context.Background(), opts)
+	assert.Nil(t, err)
+	assert.Equal(t, &SeedResult{Total: 21, Skipped: 5, Seeded: 16}, result)
+	assert.Equal(t, 16, cache.Len())
+	assert.False(t, cache.Exists(layers[0].String()+"/0/0/0"))
+
+	data, err := os.ReadFile(opts.StateFile)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, state))
+	assert.Equal(t, uint64(21), state.Completed)
+
+	// A state file from a different job should be ignored
+	opts.Cover.MaxZoom = 1
+	result, err = server.Seed(context.Background(), opts)
+	assert.Nil(t, err)
+	assert.Equal(t, &SeedResult{Total: 5, Seeded: 5}, result)
+}
+
+func TestSeedSourceErrors(t *testing.T) {
+	source := &flakySource{Source: &NilSource{}, Fail: true}
+	layers := []Layer{Layer{Name: "a", Cacheable: true, source: source}}
+	server := &Server{Layers: layers, Cache: NewInMemoryCache()}
+
+	result, err := server.Seed(context.Background(), SeedOptions{
+		Cover: TileCover{Bound: WorldBound, MinZoom: 0, MaxZoom: 1},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &SeedResult{Total: 5, Errors: 5}, result)
+}
+
+func TestSeedRetriesFailedTiles(t *testing.T) {
+	source := &flakySource{Source: &NilSource{}, Fail: true}
+	layers := []Layer{Layer{Name: "a", Cacheable: true, source: source}}
+	server := &Server{Layers: layers, Cache: NewInMemoryCache()}
+	opts := SeedOptions{
+		Cover:     TileCover{Bound: WorldBound, MinZoom: 0, MaxZoom: 1},
+		StateFile: filepath.Join(t.TempDir(), "seed.json"),
+	}
+
+	result, err := server.Seed(context.Background(), opts)
+	assert.Nil(t, err)
+	assert.Equal(t, &SeedResult{Total: 5, Errors: 5}, result)
+	state, err := loadSeedState(opts.StateFile)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), state.Completed)
+	assert.Len(t, state.Failed, 5)
+
+	// Resuming the job should only retry the failed tiles
+	source.Fail = false
+	result, err = server.Seed(context.Background(), opts)
+	assert.Nil(t, err)
+	assert.Equal(t, &SeedResult{Total: 5, Seeded: 5}, result)
+	state, err = loadSeedState(opts.StateFile)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), state.Completed)
+	assert.Empty(t, state.Failed)
+}
+
+func TestCountSeedTiles(t *testing.T) {
+	layers := []Layer{
+		Layer{Name: "a", Cacheable: true, Minzoom: 1, Maxzoom: 1, source: &NilSource{}},
+		Layer{Name: "b", Cacheable: true, Minzoom: 3, source: &NilSource{}},
+		Layer{Name: "c", source: &NilSource{}},
+	}
+	server := &Server{Layers: layers, Cache: NewInMemoryCache()}
+	opts := SeedOptions{Cover: TileCover{Bound: WorldBound, MinZoom: 0, MaxZoom: 3}}
+
+	count, err := server.CountSeedTiles(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4+64), count, "Only the zoom levels served by cacheable layers should be counted")
+
+	opts.Layers = []string{"c"}
+	_, err = server.CountSeedTiles(opts)
+	assert.Equal(t, NoCacheableLayersErr, err)
+}
+
+func TestSeedCanceled(t *testing.T) {
+	layers := []Layer{Layer{Name: "a", Cacheable: true, source: &NilSource{}}}
+	server := &Server{Layers: layers, Cache: NewInMemoryCache()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := server.Seed(ctx, SeedOptions{
+		Cover: TileCover{Bound: WorldBound, MinZoom: 0, MaxZoom: 1},
+	})
+	assert.Equal(t, context.Canceled, err)
+}
@@ -2,23 +2,28 @@ package tilenol
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
+	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
 	"github.com/paulmach/orb/maptile"
 	"github.com/paulmach/orb/simplify"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -36,6 +41,14 @@ const (
 	MaxSimplify = 10.0
 	// AllLayers is the special request parameter for returning all source layers
 	AllLayers = "_all"
+	// CatalogPath is the path segment of the layer catalog endpoints, which is therefore not
+	// allowed as a layer name
+	CatalogPath = "layers"
+	// DefaultShutdownTimeout is the default grace period for draining in-flight requests
+	// when shutting down the server
+	DefaultShutdownTimeout = 30 * time.Second
+	// RefreshTimeout is the maximum time spent refreshing stale layer data in the background
+	RefreshTimeout = 30 * time.Second
 )
 
 // TileRequest is an object containing the tile request context
@@ -69,6 +82,15 @@ func (f InvalidRequestError) Error() string {
 	return f.s
 }
 
+// Error type for HTTP Status code 404
+type NotFoundError struct {
+	s string
+}
+
+func (f NotFoundError) Error() string {
+	return f.s
+}
+
 // Sanitize TileRequest arguments and return an error if sanity checking fails.
 func MakeTileRequest(req *http.Request, x int, y int, z int) (*TileRequest, error) {
 	if z < MinZoom || z > MaxZoom {
@@ -110,6 +132,25 @@ type Server struct {
 	Layers []Layer
 	// Cache is an optional cache object that the server uses to cache responses
 	Cache Cache
+	// ShutdownTimeout is the grace period for draining in-flight requests upon shutdown
+	ShutdownTimeout time.Duration
+	// Metrics is the collection of Prometheus metrics exposed on the internal port
+	Metrics *Metrics
+	// Staleness configures how aging cache entries are served
+	Staleness StalenessConfig
+	// AdminToken is the bearer token required by the admin endpoints on the internal port
+	// (admin endpoints are disabled if empty)
+	AdminToken string
+
+	mu          sync.Mutex
+	httpServers []*http.Server
+	// flightCallers counts the requests waiting on the coalesced computation of each cache key
+	flightCallers map[string]int
+	flight        singleflight.Group
+	refreshes     sync.WaitGroup
+	// shuttingDown indicates that no new background refreshes may be started
+	shuttingDown bool
+	closeOnce    sync.Once
 }
 
 // Handler is a type alias for a more functional HTTP request handler
@@ -117,7 +158,7 @@ type Handler func(context.Context, io.Writer, *http.Request) error
 
 // NewServer creates a new server instance pre-configured with the given ConfigOption's
 func NewServer(configOpts ...ConfigOption) (*Server, error) {
-	s := &Server{}
+	s := &Server{Metrics: NewMetrics()}
 	for _, opt := range configOpts {
 		err := opt(s)
 		if err != nil {
@@ -128,9 +169,14 @@ func NewServer(configOpts ...ConfigOption) (*Server, error) {
 }
 
 func (s *Server) setupRoutes() (*chi.Mux, *chi.Mux) {
+	if s.Metrics == nil {
+		s.Metrics = NewMetrics()
+	}
+
 	r := chi.NewRouter()
 
 	//-- MIDDLEWARE
+	r.Use(s.Metrics.InFlightMiddleware)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	logFormatter := &middleware.DefaultLogFormatter{Logger: Logger, NoColor: true}
@@ -150,36 +196,111 @@ func (s *Server) setupRoutes() (*chi.Mux, *chi.Mux) {
 
 	//-- ROUTES
 	r.Get("/{layers}/{z}/{x}/{y}.mvt", s.getVectorTile)
-
-	// TODO: Add GeoJSON endpoint?
-	// TODO: Add TileJSON endpoint (see StationA/tilenol#36)
+	r.Get("/{layers}/{z}/{x}/{y}.geojson", s.getGeoJSON)
+	r.Get("/{layers}/tile.json", s.getTileJSON)
+	r.Get("/"+CatalogPath, s.listLayers)
+	r.Get("/"+CatalogPath+"/{name}", s.getLayer)
 
 	i := chi.NewRouter()
 	i.Get("/healthcheck", s.healthCheck)
+	i.Get("/livez", s.liveness)
+	i.Get("/readyz", s.readiness)
+	i.Method("GET", "/metrics", s.Metrics.Handler())
+	i.With(s.requireAdminToken).Post("/admin/layers/{name}/purge", s.purgeLayer)
 
 	return r, i
 }
 
-// Start actually starts the server instance. Note that this blocks until an interrupting signal
-func (s *Server) Start() {
+// Start actually starts the server instance. Note that this blocks until either the given
+// context is canceled or one of the listeners stops (e.g. on failure or Shutdown), after which
+// the server is gracefully shut down
+func (s *Server) Start(ctx context.Context) error {
 	r, i := s.setupRoutes()
 
-	go func() {
-		log.Fatalln(http.ListenAndServe(fmt.Sprintf(":%d", s.Port), r))
-	}()
-
-	go func() {
-		log.Fatalln(http.ListenAndServe(fmt.Sprintf(":%d", s.InternalPort), i))
-	}()
+	s.mu.Lock()
+	s.httpServers = []*http.Server{
+		{Addr: fmt.Sprintf(":%d", s.Port), Handler: r},
+		{Addr: fmt.Sprintf(":%d", s.InternalPort), Handler: i},
+	}
+	httpServers := s.httpServers
+	s.mu.Unlock()
+
+	// Every listener reports when it stops, so that Start also returns if the server is shut
+	// down from elsewhere
+	stopped := make(chan error, len(httpServers))
+	for _, srv := range httpServers {
+		srv := srv
+		go func() {
+			stopped <- srv.ListenAndServe()
+		}()
+	}
 
 	Logger.Infof("Tilenol server up and running @ 0.0.0.0:[%d,%d]", s.Port, s.InternalPort)
 
-	select {}
+	var listenErr error
+	select {
+	case <-ctx.Done():
+		Logger.Infoln("Shutting down Tilenol server")
+	case err := <-stopped:
+		if err != http.ErrServerClosed {
+			listenErr = err
+			Logger.Errorf("Tilenol server failed: %s", listenErr)
+		}
+	}
+
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return errors.Join(listenErr, s.Shutdown(shutdownCtx))
+}
+
+// Shutdown gracefully stops the server instance, waiting for in-flight requests to drain
+// until the given context expires, and then closes every layer source and cache that
+// implements io.Closer. If the requests fail to drain in time, the listeners are closed
+// forcibly, but note that the sources and cache are then closed under any handlers that are
+// still running. Sources and the cache are only closed once, so Shutdown is safe to call
+// several times.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	httpServers := s.httpServers
+	s.httpServers = nil
+	// Note: no new background refreshes are started from now on, so that waiting on the
+	// running ones below is safe
+	s.shuttingDown = true
+	s.mu.Unlock()
+
+	var errs []error
+	for _, srv := range httpServers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+			if err := srv.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	s.closeOnce.Do(func() {
+		// Wait for any background refreshes to finish before closing their sources and cache
+		s.refreshes.Wait()
+		for _, layer := range s.Layers {
+			if err := layer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("Failed to close layer [%s]: %w", layer.Name, err))
+			}
+		}
+		if closer, ok := s.Cache.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("Failed to close cache: %w", err))
+			}
+		}
+	})
+	return errors.Join(errs...)
 }
 
-// healthCheck implements a simple healthcheck endpoint for the internal metrics server
+// healthCheck implements a simple healthcheck endpoint for the internal metrics server. Note
+// that this does not probe any layer sources (see the /readyz endpoint for that)
 func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
-	// TODO: Maybe in the future check that each layer source is reachable?
 	fmt.Fprintf(w, "OK")
 }
 
@@ -217,22 +338,25 @@ func filterLayersByZoom(inLayers []Layer, z int) []Layer {
 	return outLayers
 }
 
-// Filter out Features with an empty geometry in a Layer.
-func filterEmptyGeometries(layer *mvt.Layer) {
+// Filter out Features with an empty geometry, reusing the given slice
+func filterEmptyGeometries(features []*geojson.Feature) []*geojson.Feature {
 	count := 0
-	for _, f := range layer.Features {
+	for _, f := range features {
 		if f.Geometry != nil {
-			layer.Features[count] = f
+			features[count] = f
 			count++
 		}
 	}
-	layer.Features = layer.Features[:count]
+	return features[:count]
 }
 
 // getLayerDataFromSource retrieves layer data from the original backend source
 func (s *Server) getLayerDataFromSource(ctx context.Context, layer Layer, req *TileRequest) (*mvt.Layer, error) {
+	start := time.Now()
 	fc, err := layer.GetFeatures(ctx, req)
+	s.Metrics.SourceLatency.WithLabelValues(layer.Name).Observe(time.Since(start).Seconds())
 	if err != nil {
+		s.Metrics.SourceErrors.WithLabelValues(layer.Name).Inc()
 		return nil, err
 	}
 
@@ -243,41 +367,172 @@ func (s *Server) getLayerDataFromSource(ctx context.Context, layer Layer, req *T
 	return fcLayer, nil
 }
 
-// getLayerDataFromCache retrieves layer data from the configured cache
-func (s *Server) getLayerDataFromCache(ctx context.Context, cacheKey string) (*mvt.Layer, error) {
+// getLayerDataFromCache retrieves layer data from the configured cache, along with the age of
+// the cached data
+func (s *Server) getLayerDataFromCache(ctx context.Context, cacheKey string) (*mvt.Layer, time.Duration, error) {
 	raw, err := s.Cache.Get(cacheKey)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	layers, err := mvt.UnmarshalGzipped(raw)
+	entry := UnmarshalCacheEntry(raw)
+	layers, err := mvt.UnmarshalGzipped(entry.Data)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// Note: since we store an mvt.Layers array with only a single layer, we need to pull out
 	// the first layer to match the return interface
-	return layers[0], nil
+	return layers[0], entry.Age(), nil
+}
+
+// cloneLayer creates a deep copy of the given layer
+func cloneLayer(layer *mvt.Layer) *mvt.Layer {
+	clone := &mvt.Layer{
+		Name:     layer.Name,
+		Version:  layer.Version,
+		Extent:   layer.Extent,
+		Features: make([]*geojson.Feature, len(layer.Features)),
+	}
+	for i, f := range layer.Features {
+		clone.Features[i] = &geojson.Feature{
+			ID:         f.ID,
+			Type:       f.Type,
+			BBox:       f.BBox,
+			Geometry:   orb.Clone(f.Geometry),
+			Properties: f.Properties.Clone(),
+		}
+	}
+	return clone
 }
 
 // getLayerData retrieves layer data either from cache or the original source
 func (s *Server) getLayerData(ctx context.Context, layer Layer, req *TileRequest) (*mvt.Layer, error) {
+	s.Metrics.LayerRequests.WithLabelValues(layer.Name).Inc()
+
 	cacheKey := fmt.Sprintf("%s/%s", layer.String(), req.String())
+
+	// Expired cache data can still be served as a fallback in case the source fails, or the
+	// request gives up on it (e.g. times out)
+	var fallback *mvt.Layer
 	if layer.Cacheable && s.Cache.Exists(cacheKey) {
 		Logger.Debugf("Key [%s] found in cache", cacheKey)
-		if fcLayer, err := s.getLayerDataFromCache(ctx, cacheKey); err == nil {
-			return fcLayer, nil
+		if fcLayer, age, err := s.getLayerDataFromCache(ctx, cacheKey); err == nil {
+			switch s.Staleness.Freshness(age) {
+			case Fresh:
+				s.Metrics.CacheLookups.WithLabelValues(layer.Name, "hit").Inc()
+				return fcLayer, nil
+			case Stale:
+				Logger.Debugf("Key [%s] is stale, refreshing in the background", cacheKey)
+				s.Metrics.CacheLookups.WithLabelValues(layer.Name, "hit").Inc()
+				s.Metrics.StaleResponses.WithLabelValues(layer.Name, "revalidate").Inc()
+				s.refreshLayerData(layer, req, cacheKey)
+				return fcLayer, nil
+			case Expired:
+				fallback = fcLayer
+			}
 		} else {
+			s.Metrics.CacheErrors.WithLabelValues(layer.Name, "get").Inc()
 			Logger.Warningf("Failed to retrieve layer data from cache [%s]: %s", cacheKey, err)
 		}
 	}
+	if layer.Cacheable {
+		s.Metrics.CacheLookups.WithLabelValues(layer.Name, "miss").Inc()
+	}
 
 	Logger.Debugf("Key [%s] is not cached", cacheKey)
 
+	fcLayer, err := s.coalesceLayerData(ctx, layer, req, cacheKey)
+	if err != nil {
+		// Note: this includes requests whose context is done before the source responds, since
+		// the source query is detached from them
+		var invalidErr InvalidRequestError
+		if fallback != nil && !errors.As(err, &invalidErr) {
+			Logger.Warningf("Serving stale layer data for [%s] after source failure: %s", cacheKey, err)
+			s.Metrics.StaleResponses.WithLabelValues(layer.Name, "error").Inc()
+			return fallback, nil
+		}
+		return nil, err
+	}
+	return fcLayer, nil
+}
+
+// coalesceLayerData computes layer data from the original source, coalescing concurrent
+// computations of the same layer data so that only a single source query runs per cache key.
+// Note that the shared computation is detached from the cancellation (but not the deadline) of
+// the request that happened to start it, since other requests may be waiting on its result,
+// while each request still stops waiting once its own context is done.
+func (s *Server) coalesceLayerData(ctx context.Context, layer Layer, req *TileRequest, cacheKey string) (*mvt.Layer, error) {
+	s.mu.Lock()
+	if s.flightCallers == nil {
+		s.flightCallers = make(map[string]int)
+	}
+	coalesced := s.flightCallers[cacheKey] > 0
+	s.flightCallers[cacheKey]++
+	s.mu.Unlock()
+	if coalesced {
+		Logger.Debugf("Coalesced request for key [%s]", cacheKey)
+		s.Metrics.CoalescedRequests.WithLabelValues(layer.Name).Inc()
+	}
+
+	results := s.flight.DoChan(cacheKey, func() (interface{}, error) {
+		defer func() {
+			s.mu.Lock()
+			delete(s.flightCallers, cacheKey)
+			s.mu.Unlock()
+		}()
+		computeCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			computeCtx, cancel = context.WithDeadline(computeCtx, deadline)
+			defer cancel()
+		}
+		return s.computeLayerData(computeCtx, layer, req, cacheKey)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-results:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		fcLayer := res.Val.(*mvt.Layer)
+		if res.Shared {
+			// Since each request may further modify its layer data (e.g. simplification), every
+			// request gets its own copy of the shared result
+			fcLayer = cloneLayer(fcLayer)
+		}
+		return fcLayer, nil
+	}
+}
+
+// refreshLayerData recomputes stale layer data in the background, giving up after the
+// RefreshTimeout. Note that refreshes are skipped once the server is shutting down
+func (s *Server) refreshLayerData(layer Layer, req *TileRequest, cacheKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		Logger.Debugf("Skipping refresh of stale layer data [%s] during shutdown", cacheKey)
+		return
+	}
+	s.refreshes.Add(1)
+	go func() {
+		defer s.refreshes.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), RefreshTimeout)
+		defer cancel()
+		if _, err := s.coalesceLayerData(ctx, layer, req, cacheKey); err != nil {
+			Logger.Warningf("Failed to refresh stale layer data [%s]: %s", cacheKey, err)
+		}
+	}()
+}
+
+// computeLayerData retrieves layer data from the original source, storing it in the cache if
+// the layer is cacheable
+func (s *Server) computeLayerData(ctx context.Context, layer Layer, req *TileRequest, cacheKey string) (*mvt.Layer, error) {
 	fcLayer, err := s.getLayerDataFromSource(ctx, layer, req)
 	if err != nil {
 		return nil, err
 	}
-	filterEmptyGeometries(fcLayer)
+	fcLayer.Features = filterEmptyGeometries(fcLayer.Features)
 
 	if layer.Cacheable {
 		// Note: paulmach/orb only implements marshalling code for an array of layer objects,
@@ -287,7 +542,8 @@ func (s *Server) getLayerData(ctx context.Context, layer Layer, req *TileRequest
 			return nil, err
 		}
 
-		if err := s.Cache.Put(cacheKey, raw); err != nil {
+		if err := s.Cache.Put(cacheKey, NewCacheEntry(raw).Marshal()); err != nil {
+			s.Metrics.CacheErrors.WithLabelValues(layer.Name, "put").Inc()
 			Logger.Warningf("Failed to store layer data in cache [%s]: %s", cacheKey, err)
 		}
 	}
@@ -295,20 +551,9 @@ func (s *Server) getLayerData(ctx context.Context, layer Layer, req *TileRequest
 	return fcLayer, nil
 }
 
-// getVectorTile computes a vector tile response for the incoming request
-func (s *Server) getVectorTile(w http.ResponseWriter, r *http.Request) {
-	rctx := r.Context()
-
-	// Setup deferred error handler for request cancellations
-	defer func() {
-		// TODO: Fix this behavior for certain cancellation scenarios (see StationA/tilenol#42)
-		if rctx.Err() == context.Canceled {
-			Logger.Debugf("Request canceled by client")
-			w.WriteHeader(499)
-			return
-		}
-	}()
-
+// parseTileRequest parses the tile coordinates and requested layers from the incoming
+// request, returning the TileRequest along with the layers that should be computed
+func (s *Server) parseTileRequest(r *http.Request) (*TileRequest, []Layer, error) {
 	z, _ := strconv.Atoi(chi.URLParam(r, "z"))
 	x, _ := strconv.Atoi(chi.URLParam(r, "x"))
 	y, _ := strconv.Atoi(chi.URLParam(r, "y"))
@@ -316,50 +561,91 @@ func (s *Server) getVectorTile(w http.ResponseWriter, r *http.Request) {
 
 	req, err := MakeTileRequest(r, x, y, z)
 	if err != nil {
-		s.handleError(err, w, r)
-		return
+		return nil, nil, err
 	}
 
 	var layersToCompute = filterLayersByZoom(s.Layers, z)
 	if requestedLayers != AllLayers {
 		layersToCompute = filterLayersByNames(layersToCompute, strings.Split(requestedLayers, ","))
 	}
+	return req, layersToCompute, nil
+}
 
-	// Create an errgroup with the request context so that we can get cancellable,
+// eachLayer calls fn concurrently for every given layer (along with its index), returning the
+// first error encountered, in which case the context passed to the other calls is canceled
+func eachLayer(ctx context.Context, layers []Layer, fn func(context.Context, int, Layer) error) error {
+	// Create an errgroup with the parent context so that we can get cancellable,
 	// fork-join parallelism behavior
-	eg, ctx := errgroup.WithContext(rctx)
+	eg, ctx := errgroup.WithContext(ctx)
 
-	fcLayers := make(mvt.Layers, len(layersToCompute))
-	for i, layer := range layersToCompute {
+	for i, layer := range layers {
 		i, layer := i, layer // Fun stuff: https://blog.cloudflare.com/a-go-gotcha-when-closures-and-goroutines-collide/
 
 		// Start a goroutine for each layer
 		eg.Go(func() error {
-			Logger.Debugf("Retrieving layer data for [%s] @ (%d, %d, %d)", layer, z, x, y)
-
-			fcLayer, err := s.getLayerData(ctx, layer, req)
-			if err != nil {
-				return err
-			}
-
-			// TODO: Consider the tradeoffs of cacheing pre-simplified vs. post-simplified layers
-			if s.Simplify {
-				minZoom := layer.Minzoom
-				maxZoom := layer.Maxzoom
-				simplifyThreshold := calculateSimplificationThreshold(minZoom, maxZoom, z)
-				Logger.Debugf("Simplifying @ zoom [%d], epsilon [%f]", z, simplifyThreshold)
-				fcLayer.Simplify(simplify.DouglasPeucker(simplifyThreshold))
-				fcLayer.RemoveEmpty(1.0, 1.0)
-			}
-
-			fcLayers[i] = fcLayer
-			return nil
+			return fn(ctx, i, layer)
 		})
 	}
 
 	// Wait for all of the goroutines spawned in this errgroup to complete or fail
-	if err := eg.Wait(); err != nil {
+	return eg.Wait()
+}
+
+// getTileLayers computes the (optionally simplified) data of every requested layer for a tile
+func (s *Server) getTileLayers(ctx context.Context, req *TileRequest, layersToCompute []Layer) (mvt.Layers, error) {
+	x, y, z := req.X, req.Y, req.Z
+
+	fcLayers := make(mvt.Layers, len(layersToCompute))
+	err := eachLayer(ctx, layersToCompute, func(ctx context.Context, i int, layer Layer) error {
+		Logger.Debugf("Retrieving layer data for [%s] @ (%d, %d, %d)", layer, z, x, y)
+
+		fcLayer, err := s.getLayerData(ctx, layer, req)
+		if err != nil {
+			return err
+		}
+
+		// TODO: Consider the tradeoffs of cacheing pre-simplified vs. post-simplified layers
+		if s.Simplify {
+			minZoom := layer.Minzoom
+			maxZoom := layer.Maxzoom
+			simplifyThreshold := calculateSimplificationThreshold(minZoom, maxZoom, z)
+			Logger.Debugf("Simplifying @ zoom [%d], epsilon [%f]", z, simplifyThreshold)
+			fcLayer.Simplify(simplify.DouglasPeucker(simplifyThreshold))
+			fcLayer.RemoveEmpty(1.0, 1.0)
+		}
+
+		s.Metrics.TileFeatures.WithLabelValues(layer.Name).Observe(float64(len(fcLayer.Features)))
+		fcLayers[i] = fcLayer
+		return nil
+	})
+	if err != nil {
 		// If any of them fail, return the error
+		return nil, err
+	}
+	return fcLayers, nil
+}
+
+// getVectorTile computes a vector tile response for the incoming request
+func (s *Server) getVectorTile(w http.ResponseWriter, r *http.Request) {
+	rctx := r.Context()
+
+	// Setup deferred error handler for request cancellations
+	defer func() {
+		// TODO: Fix this behavior for certain cancellation scenarios (see StationA/tilenol#42)
+		if rctx.Err() == context.Canceled {
+			Logger.Debugf("Request canceled by client")
+			w.WriteHeader(499)
+			return
+		}
+	}()
+
+	req, layersToCompute, err := s.parseTileRequest(r)
+	if err != nil {
+		s.handleError(err, w, r)
+		return
+	}
+	fcLayers, err := s.getTileLayers(rctx, req, layersToCompute)
+	if err != nil {
 		s.handleError(err, w, r)
 		return
 	}
@@ -370,6 +656,7 @@ func (s *Server) getVectorTile(w http.ResponseWriter, r *http.Request) {
 		s.handleError(marshalErr, w, r)
 		return
 	}
+	s.Metrics.TileSize.Observe(float64(len(data)))
 
 	// Set standard response headers
 	// TODO: Figure out a smarter cacheing mechanism (see StationA/tilenol#30)
@@ -383,6 +670,17 @@ func (s *Server) getVectorTile(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// writeJSON is a helper function to encode a JSON response body, using the generic JSON content
+// type unless the handler has already set a more specific one (e.g. GeoJSON)
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.handleError(err, w, r)
+	}
+}
+
 // handleError is a helper function to generate a generic tile server error response
 func (s *Server) handleError(err error, w http.ResponseWriter, r *http.Request) {
 	// Don't attempt to handle broken pipe errors (no one's listening on the other side!)
@@ -394,6 +692,8 @@ func (s *Server) handleError(err error, w http.ResponseWriter, r *http.Request)
 	switch err.(type) {
 	case InvalidRequestError:
 		errCode = http.StatusBadRequest
+	case NotFoundError:
+		errCode = http.StatusNotFound
 	default:
 		errCode = http.StatusInternalServerError
 	}
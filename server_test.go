@@ -3,11 +3,19 @@ package tilenol
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestFilterLayersByName(t *testing.T) {
@@ -92,6 +100,10 @@ func (c *countingCache) Put(key string, val []byte) error {
 	return c.Cache.Put(key, val)
 }
 
+func (c *countingCache) Delete(key string) error {
+	return c.Cache.Delete(key)
+}
+
 func TestCachedHandler(t *testing.T) {
 	source := &countingSource{Source: &NilSource{}}
 	cache := &countingCache{Cache: NewInMemoryCache()}
@@ -199,3 +211,298 @@ func TestAPI(t *testing.T) {
 		t.Error("Non-200 healthcheck response")
 	}
 }
+
+type closingSource struct {
+	NilSource
+	Closed bool
+}
+
+func (c *closingSource) Close() error {
+	c.Closed = true
+	return nil
+}
+
+type closingCache struct {
+	NilCache
+	Closed bool
+}
+
+func (c *closingCache) Close() error {
+	if c.Closed {
+		return errors.New("Cache already closed")
+	}
+	c.Closed = true
+	return nil
+}
+
+func TestStartAndShutdown(t *testing.T) {
+	source := &closingSource{}
+	cache := &closingCache{}
+	server := &Server{
+		Layers:          []Layer{Layer{Name: "a", source: source}},
+		Cache:           cache,
+		ShutdownTimeout: time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- server.Start(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Server did not shut down cleanly: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not shut down in time")
+	}
+	if !source.Closed {
+		t.Error("Layer source should have been closed on shutdown")
+	}
+	if !cache.Closed {
+		t.Error("Cache should have been closed on shutdown")
+	}
+}
+
+func TestShutdownWithoutCancel(t *testing.T) {
+	cache := &closingCache{}
+	server := &Server{Cache: cache, ShutdownTimeout: time.Second}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- server.Start(ctx)
+	}()
+	// Wait for the listeners to be set up before shutting them down
+	assert.Eventually(t, func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return len(server.httpServers) > 0
+	}, time.Second, time.Millisecond)
+	assert.Nil(t, server.Shutdown(context.Background()))
+
+	select {
+	case err := <-done:
+		assert.Nil(t, err, "The cache should only be closed once")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+	assert.True(t, cache.Closed)
+	assert.Nil(t, server.Shutdown(context.Background()))
+}
+
+type blockingSource struct {
+	release chan struct{}
+	calls   int32
+}
+
+func (b *blockingSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	atomic.AddInt32(&b.calls, 1)
+	<-b.release
+	fc := geojson.NewFeatureCollection()
+	fc.Append(geojson.NewFeature(orb.Point{0, 0}))
+	return fc, nil
+}
+
+func TestCoalescedRequests(t *testing.T) {
+	source := &blockingSource{release: make(chan struct{})}
+	layers := []Layer{
+		Layer{Name: "a", Cacheable: true, source: source},
+	}
+	server := &Server{Layers: layers, Cache: NewInMemoryCache(), Simplify: true}
+	handler, _ := server.setupRoutes()
+
+	const numRequests = 10
+	var wg sync.WaitGroup
+	for i := 0; i < numRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/_all/0/0/0.mvt", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Result().StatusCode != 200 {
+				t.Error("Unsuccessful status code")
+			}
+		}()
+	}
+
+	// Wait for every other request to join the first one before letting the source respond
+	coalesced := server.Metrics.CoalescedRequests.WithLabelValues("a")
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(coalesced) == numRequests-1
+	}, 5*time.Second, time.Millisecond)
+	close(source.release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&source.calls); calls != 1 {
+		t.Errorf("Expected a single source query, got: %d", calls)
+	}
+}
+
+func TestCoalescedRequestCancellation(t *testing.T) {
+	source := &blockingSource{release: make(chan struct{})}
+	layer := Layer{Name: "a", Cacheable: true, source: source}
+	cache := NewInMemoryCache()
+	server := &Server{Layers: []Layer{layer}, Cache: cache, Metrics: NewMetrics()}
+	req := &TileRequest{X: 0, Y: 0, Z: 0}
+
+	// The request that started the source query stops waiting once it is canceled, while the
+	// source query keeps running for the other requests
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err := server.coalesceLayerData(ctx, layer, req, "a/0/0/0")
+		leader <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&source.calls) == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-leader)
+
+	follower := make(chan error)
+	go func() {
+		_, err := server.coalesceLayerData(context.Background(), layer, req, "a/0/0/0")
+		follower <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(server.Metrics.CoalescedRequests.WithLabelValues("a")) == 1
+	}, 5*time.Second, time.Millisecond)
+	close(source.release)
+	assert.Nil(t, <-follower)
+	assert.True(t, cache.Exists("a/0/0/0"), "The shared source query should still be cached")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&source.calls))
+}
+
+type flakySource struct {
+	Source Source
+	Fail   bool
+	calls  int32
+}
+
+func (f *flakySource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	atomic.AddInt32(&f.calls, 1)
+	if f.Fail {
+		return nil, errors.New("source unavailable")
+	}
+	return f.Source.GetFeatures(ctx, req)
+}
+
+// ageCacheEntries backdates the creation time of every entry in an InMemoryCache
+func ageCacheEntries(cache *InMemoryCache, age time.Duration) {
+	var keys []string
+	for key := range cache.cache {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		raw, _ := cache.Get(key)
+		entry := UnmarshalCacheEntry(raw)
+		entry.CreatedAt = entry.CreatedAt.Add(-age)
+		cache.Put(key, entry.Marshal())
+	}
+}
+
+func requestTile(handler http.Handler, path string) int {
+	r := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Result().StatusCode
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	source := &flakySource{Source: &pointSource{orb.Point{1, 1}}}
+	cache := NewInMemoryCache().(*InMemoryCache)
+	server := &Server{
+		Layers:    []Layer{Layer{Name: "a", Cacheable: true, source: source}},
+		Cache:     cache,
+		Staleness: StalenessConfig{SoftTTL: time.Minute},
+	}
+	handler, _ := server.setupRoutes()
+
+	assert.Equal(t, 200, requestTile(handler, "/_all/0/0/0.mvt"))
+	assert.Equal(t, 200, requestTile(handler, "/_all/0/0/0.mvt"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&source.calls), "Fresh entries should be served from cache")
+
+	ageCacheEntries(cache, 2*time.Minute)
+	assert.Equal(t, 200, requestTile(handler, "/_all/0/0/0.mvt"))
+	server.refreshes.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&source.calls), "Stale entries should be refreshed in the background")
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.Metrics.StaleResponses.WithLabelValues("a", "revalidate")))
+
+	// The refreshed entry should be fresh again
+	assert.Equal(t, 200, requestTile(handler, "/_all/0/0/0.mvt"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&source.calls))
+}
+
+func TestShutdownSkipsRefreshes(t *testing.T) {
+	source := &flakySource{Source: &pointSource{orb.Point{1, 1}}}
+	cache := NewInMemoryCache().(*InMemoryCache)
+	server := &Server{
+		Layers:    []Layer{Layer{Name: "a", Cacheable: true, source: source}},
+		Cache:     cache,
+		Staleness: StalenessConfig{SoftTTL: time.Minute},
+	}
+	handler, _ := server.setupRoutes()
+
+	assert.Equal(t, 200, requestTile(handler, "/_all/0/0/0.mvt"))
+	ageCacheEntries(cache, 2*time.Minute)
+
+	assert.Nil(t, server.Shutdown(context.Background()))
+	assert.Equal(t, 200, requestTile(handler, "/_all/0/0/0.mvt"), "Stale entries should still be served")
+	server.refreshes.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&source.calls), "Stale entries should not be refreshed after shutdown")
+}
+
+func TestStaleIfError(t *testing.T) {
+	source := &flakySource{Source: &pointSource{orb.Point{1, 1}}}
+	cache := NewInMemoryCache().(*InMemoryCache)
+	server := &Server{
+		Layers:    []Layer{Layer{Name: "a", Cacheable: true, source: source}},
+		Cache:     cache,
+		Staleness: StalenessConfig{HardTTL: time.Minute, StaleIfError: time.Hour},
+	}
+	handler, _ := server.setupRoutes()
+
+	assert.Equal(t, 200, requestTile(handler, "/_all/0/0/0.mvt"))
+
+	source.Fail = true
+	ageCacheEntries(cache, 2*time.Minute)
+	assert.Equal(t, 200, requestTile(handler, "/_all/0/0/0.mvt"), "Expired entries should be served if the source fails")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&source.calls))
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.Metrics.StaleResponses.WithLabelValues("a", "error")))
+
+	ageCacheEntries(cache, 2*time.Hour)
+	assert.Equal(t, 500, requestTile(handler, "/_all/0/0/0.mvt"), "Entries past the stale-if-error window should not be served")
+}
+
+func TestStaleIfTimeout(t *testing.T) {
+	source := &blockingSource{release: make(chan struct{})}
+	close(source.release)
+	layer := Layer{Name: "a", Cacheable: true, source: source}
+	cache := NewInMemoryCache().(*InMemoryCache)
+	server := &Server{
+		Layers:    []Layer{layer},
+		Cache:     cache,
+		Metrics:   NewMetrics(),
+		Staleness: StalenessConfig{HardTTL: time.Minute, StaleIfError: time.Hour},
+	}
+	req := &TileRequest{X: 0, Y: 0, Z: 0}
+	_, err := server.getLayerData(context.Background(), layer, req)
+	assert.Nil(t, err)
+
+	// Expired entries should be served once the request times out waiting on the source
+	source.release = make(chan struct{})
+	defer close(source.release)
+	ageCacheEntries(cache, 2*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fcLayer, err := server.getLayerData(ctx, layer, req)
+	assert.Nil(t, err)
+	assert.Len(t, fcLayer.Features, 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.Metrics.StaleResponses.WithLabelValues("a", "error")))
+}
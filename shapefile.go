@@ -0,0 +1,414 @@
+package tilenol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+)
+
+const (
+	// shapefileHeaderSize is the size of the main file and index file headers
+	shapefileHeaderSize = 100
+	// shapefileFileCode is the magic number at the start of the main file and index file
+	shapefileFileCode = 9994
+	// dbfFieldDescriptorSize is the size of each field descriptor in the dBASE header
+	dbfFieldDescriptorSize = 32
+)
+
+// Shape types, where the Z and M variants add a tenth or twentieth to the base type
+const (
+	shapeTypeNull       = 0
+	shapeTypePoint      = 1
+	shapeTypePolyLine   = 3
+	shapeTypePolygon    = 5
+	shapeTypeMultiPoint = 8
+	shapeTypeMultiPatch = 31
+)
+
+var (
+	// InvalidShapefileErr is the error returned when the files of a shapefile are malformed
+	InvalidShapefileErr = errors.New("Invalid shapefile")
+)
+
+// shapefileSidecar finds the file of a shapefile with the given extension, which may be
+// either in lower case or upper case
+func shapefileSidecar(base, ext string) (string, bool) {
+	for _, candidate := range []string{base + ext, base + strings.ToUpper(ext)} {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// readShapefile reads the features of a shapefile, i.e. the geometries of the main (.shp) file,
+// located through the index (.shx) file, and the attributes of the dBASE (.dbf) file. If a
+// projection (.prj) file exists, the geometries are reprojected to WGS84. Note that features
+// use their (1-based) record number as their ID.
+func readShapefile(path string) ([]*geojson.Feature, error) {
+	base := path
+	if strings.EqualFold(filepath.Ext(path), ".shp") {
+		base = strings.TrimSuffix(path, filepath.Ext(path))
+	}
+	var files [3][]byte
+	for i, ext := range []string{".shp", ".shx", ".dbf"} {
+		sidecar, exists := shapefileSidecar(base, ext)
+		if !exists {
+			return nil, fmt.Errorf("%w: missing %s file for [%s]", InvalidShapefileErr, ext, base)
+		}
+		data, err := os.ReadFile(sidecar)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = data
+	}
+	shp, shx, dbf := files[0], files[1], files[2]
+
+	var proj orb.Projection
+	if prjPath, exists := shapefileSidecar(base, ".prj"); exists {
+		wkt, err := os.ReadFile(prjPath)
+		if err != nil {
+			return nil, err
+		}
+		if proj, err = wgs84ProjectionFromWKT(strings.TrimSpace(string(wkt))); err != nil {
+			return nil, err
+		}
+	}
+	decode := decodeUTF8
+	if cpgPath, exists := shapefileSidecar(base, ".cpg"); exists {
+		codePage, err := os.ReadFile(cpgPath)
+		if err != nil {
+			return nil, err
+		}
+		decode = dbfDecoder(string(codePage))
+	}
+
+	if err := checkShapefileHeader(shp); err != nil {
+		return nil, err
+	}
+	if err := checkShapefileHeader(shx); err != nil {
+		return nil, err
+	}
+	records, err := readDBF(dbf, decode)
+	if err != nil {
+		return nil, err
+	}
+	count := (len(shx) - shapefileHeaderSize) / 8
+	if count != len(records) {
+		return nil, fmt.Errorf("%w: %d shapes but %d attribute records", InvalidShapefileErr, count, len(records))
+	}
+
+	features := make([]*geojson.Feature, 0, count)
+	for i := 0; i < count; i++ {
+		// Note: deleted records are marked in the dBASE file only
+		if records[i] == nil {
+			continue
+		}
+		index := shx[shapefileHeaderSize+8*i:]
+		offset := 2 * int(binary.BigEndian.Uint32(index[0:4]))
+		length := 2 * int(binary.BigEndian.Uint32(index[4:8]))
+		if offset+8+length > len(shp) || length < 4 {
+			return nil, fmt.Errorf("%w: record %d is out of bounds", InvalidShapefileErr, i+1)
+		}
+		geom, err := readShape(shp[offset+8 : offset+8+length])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read shapefile record %d: %w", i+1, err)
+		}
+		feature := geojson.NewFeature(reprojectGeometry(geom, proj))
+		feature.ID = i + 1
+		feature.Properties = records[i]
+		features = append(features, feature)
+	}
+	return features, nil
+}
+
+// checkShapefileHeader validates the header of a main file or index file
+func checkShapefileHeader(data []byte) error {
+	if len(data) < shapefileHeaderSize || binary.BigEndian.Uint32(data[0:4]) != shapefileFileCode {
+		return fmt.Errorf("%w: invalid file header", InvalidShapefileErr)
+	}
+	return nil
+}
+
+// readShapePoints reads a sequence of little-endian X/Y coordinate pairs
+func readShapePoints(b []byte, n int) ([]orb.Point, error) {
+	if n < 0 || len(b) < 16*n {
+		return nil, fmt.Errorf("%w: truncated points", InvalidShapefileErr)
+	}
+	points := make([]orb.Point, n)
+	for i := range points {
+		points[i] = orb.Point{
+			math.Float64frombits(binary.LittleEndian.Uint64(b[16*i:])),
+			math.Float64frombits(binary.LittleEndian.Uint64(b[16*i+8:])),
+		}
+	}
+	return points, nil
+}
+
+// readShapeParts reads the parts of a PolyLine or Polygon shape, ignoring any Z or M values
+func readShapeParts(b []byte) ([][]orb.Point, error) {
+	if len(b) < 44 {
+		return nil, fmt.Errorf("%w: truncated shape", InvalidShapefileErr)
+	}
+	numParts := int(int32(binary.LittleEndian.Uint32(b[36:40])))
+	numPoints := int(int32(binary.LittleEndian.Uint32(b[40:44])))
+	if numParts < 0 || len(b) < 44+4*numParts {
+		return nil, fmt.Errorf("%w: truncated shape", InvalidShapefileErr)
+	}
+	points, err := readShapePoints(b[44+4*numParts:], numPoints)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([][]orb.Point, numParts)
+	for i := range parts {
+		start := int(int32(binary.LittleEndian.Uint32(b[44+4*i:])))
+		end := numPoints
+		if i+1 < numParts {
+			end = int(int32(binary.LittleEndian.Uint32(b[44+4*(i+1):])))
+		}
+		if start < 0 || start > end || end > numPoints {
+			return nil, fmt.Errorf("%w: invalid part indexes", InvalidShapefileErr)
+		}
+		parts[i] = points[start:end]
+	}
+	return parts, nil
+}
+
+// readShape reads the geometry of a single shape record
+func readShape(b []byte) (orb.Geometry, error) {
+	shapeType := int(int32(binary.LittleEndian.Uint32(b[0:4])))
+	if shapeType == shapeTypeNull {
+		return nil, nil
+	}
+	if shapeType == shapeTypeMultiPatch || shapeType > 28 {
+		return nil, fmt.Errorf("Unsupported shape type [%d]", shapeType)
+	}
+	switch shapeType % 10 {
+	case shapeTypePoint:
+		points, err := readShapePoints(b[4:], 1)
+		if err != nil {
+			return nil, err
+		}
+		return points[0], nil
+	case shapeTypeMultiPoint:
+		if len(b) < 40 {
+			return nil, fmt.Errorf("%w: truncated shape", InvalidShapefileErr)
+		}
+		points, err := readShapePoints(b[40:], int(int32(binary.LittleEndian.Uint32(b[36:40]))))
+		if err != nil {
+			return nil, err
+		}
+		return orb.MultiPoint(points), nil
+	case shapeTypePolyLine:
+		parts, err := readShapeParts(b)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) == 1 {
+			return orb.LineString(parts[0]), nil
+		}
+		mls := make(orb.MultiLineString, len(parts))
+		for i, part := range parts {
+			mls[i] = orb.LineString(part)
+		}
+		return mls, nil
+	case shapeTypePolygon:
+		parts, err := readShapeParts(b)
+		if err != nil {
+			return nil, err
+		}
+		return shapePolygons(parts), nil
+	default:
+		return nil, fmt.Errorf("Unsupported shape type [%d]", shapeType)
+	}
+}
+
+// shapePolygons groups the rings of a Polygon shape into polygons. Shapefiles store outer
+// rings clockwise and holes counter-clockwise, without any explicit grouping, so each hole is
+// assigned to the outer ring that contains it. Note that rings are reversed to follow the
+// GeoJSON winding order.
+func shapePolygons(parts [][]orb.Point) orb.Geometry {
+	var polygons orb.MultiPolygon
+	var holes []orb.Ring
+	for _, part := range parts {
+		ring := orb.Ring(part)
+		if len(ring) == 0 {
+			continue
+		}
+		if ring.Orientation() == orb.CCW {
+			holes = append(holes, ring)
+			continue
+		}
+		ring.Reverse()
+		polygons = append(polygons, orb.Polygon{ring})
+	}
+	if len(polygons) == 0 {
+		// Note: the rings were written with the wrong winding order, so treat them as outer
+		// rings instead
+		for _, ring := range holes {
+			polygons = append(polygons, orb.Polygon{ring})
+		}
+		holes = nil
+	}
+	for _, hole := range holes {
+		owner := len(polygons) - 1
+		for i, polygon := range polygons {
+			if planar.RingContains(polygon[0], hole[0]) {
+				owner = i
+				break
+			}
+		}
+		hole.Reverse()
+		polygons[owner] = append(polygons[owner], hole)
+	}
+	if len(polygons) == 0 {
+		return nil
+	}
+	if len(polygons) == 1 {
+		return polygons[0]
+	}
+	return polygons
+}
+
+// decodeUTF8 decodes dBASE text as UTF-8, falling back to Latin-1 for invalid UTF-8
+func decodeUTF8(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return decodeLatin1(b)
+}
+
+// decodeLatin1 decodes dBASE text as Latin-1 (ISO-8859-1)
+func decodeLatin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// dbfDecoder selects the text decoder for the code page of a .cpg file. Note that Windows-1252
+// is approximated as Latin-1.
+func dbfDecoder(codePage string) func([]byte) string {
+	switch strings.ToUpper(strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.TrimSpace(codePage))) {
+	case "ISO88591", "88591", "LATIN1", "1252", "CP1252", "WINDOWS1252", "ANSI1252":
+		return decodeLatin1
+	default:
+		return decodeUTF8
+	}
+}
+
+// dbfField is a field descriptor of a dBASE file
+type dbfField struct {
+	name     string
+	kind     byte
+	offset   int
+	length   int
+	decimals int
+}
+
+// readDBF reads the records of a dBASE file, where deleted records are returned as nil
+func readDBF(data []byte, decode func([]byte) string) ([]geojson.Properties, error) {
+	if len(data) < 32 {
+		return nil, fmt.Errorf("%w: invalid dBASE header", InvalidShapefileErr)
+	}
+	numRecords := int(binary.LittleEndian.Uint32(data[4:8]))
+	headerSize := int(binary.LittleEndian.Uint16(data[8:10]))
+	recordSize := int(binary.LittleEndian.Uint16(data[10:12]))
+	if headerSize > len(data) || recordSize < 1 {
+		return nil, fmt.Errorf("%w: invalid dBASE header", InvalidShapefileErr)
+	}
+
+	var fields []dbfField
+	// Note: the first byte of each record is the deletion flag
+	offset := 1
+	for pos := 32; pos+dbfFieldDescriptorSize <= headerSize && data[pos] != 0x0D; pos += dbfFieldDescriptorSize {
+		descriptor := data[pos : pos+dbfFieldDescriptorSize]
+		name := descriptor[:11]
+		if end := strings.IndexByte(string(name), 0); end >= 0 {
+			name = name[:end]
+		}
+		field := dbfField{
+			name:     strings.TrimSpace(decode(name)),
+			kind:     descriptor[11],
+			offset:   offset,
+			length:   int(descriptor[16]),
+			decimals: int(descriptor[17]),
+		}
+		offset += field.length
+		fields = append(fields, field)
+	}
+	if offset > recordSize || headerSize+numRecords*recordSize > len(data) {
+		return nil, fmt.Errorf("%w: truncated dBASE records", InvalidShapefileErr)
+	}
+
+	records := make([]geojson.Properties, numRecords)
+	for i := range records {
+		record := data[headerSize+i*recordSize : headerSize+(i+1)*recordSize]
+		if record[0] == '*' {
+			continue
+		}
+		props := make(geojson.Properties, len(fields))
+		for _, field := range fields {
+			if val := parseDBFValue(field, record[field.offset:field.offset+field.length], decode); val != nil {
+				props[field.name] = val
+			}
+		}
+		records[i] = props
+	}
+	return records, nil
+}
+
+// parseDBFValue converts a raw dBASE field value to a property value, where empty values are
+// returned as nil
+func parseDBFValue(field dbfField, raw []byte, decode func([]byte) string) interface{} {
+	if field.kind == 'I' && len(raw) == 4 {
+		return int64(int32(binary.LittleEndian.Uint32(raw)))
+	}
+	s := strings.Trim(decode(raw), " \x00")
+	if s == "" {
+		return nil
+	}
+	switch field.kind {
+	case 'N', 'F':
+		if strings.Trim(s, "*") == "" {
+			// Note: numeric overflows are stored as asterisks
+			return nil
+		}
+		if field.decimals == 0 {
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i
+			}
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+		return nil
+	case 'L':
+		switch s {
+		case "T", "t", "Y", "y":
+			return true
+		case "F", "f", "N", "n":
+			return false
+		default:
+			return nil
+		}
+	case 'D':
+		if len(s) == 8 {
+			return s[0:4] + "-" + s[4:6] + "-" + s[6:8]
+		}
+		return s
+	default:
+		return s
+	}
+}
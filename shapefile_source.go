@@ -0,0 +1,63 @@
+package tilenol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+var (
+	// MissingShapefilePathErr is the error returned when a shapefile source has no file path
+	MissingShapefilePathErr = errors.New("Shapefile source requires a \"path\"")
+)
+
+// ShapefileConfig is the YAML configuration structure for configuring a new ShapefileSource
+type ShapefileConfig struct {
+	// Path is the path to the main (.shp) file of the shapefile, next to which the index (.shx),
+	// dBASE (.dbf) and optional projection (.prj) files are located
+	Path string `yaml:"path"`
+	// SourceFields is a mapping from the feature property name to the dBASE field name
+	SourceFields map[string]string `yaml:"sourceFields"`
+}
+
+// ShapefileSource is a Source implementation that retrieves feature data from an ESRI
+// shapefile, which is loaded into an in-memory spatial index
+type ShapefileSource struct {
+	// Path is the path to the main (.shp) file of the shapefile
+	Path string
+	// SourceFields is a mapping from the feature property name to the dBASE field name
+	SourceFields map[string]string
+
+	index *featureIndex
+}
+
+// NewShapefileSource creates a new Source that retrieves feature data from a shapefile
+func NewShapefileSource(config *ShapefileConfig) (Source, error) {
+	if config.Path == "" {
+		return nil, MissingShapefilePathErr
+	}
+	features, err := readShapefile(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read shapefile [%s]: %w", config.Path, err)
+	}
+	index := newFeatureIndex(features)
+	Logger.Debugf("Loaded %d features from shapefile [%s]", index.Len(), config.Path)
+	return &ShapefileSource{
+		Path:         config.Path,
+		SourceFields: config.SourceFields,
+		index:        index,
+	}, nil
+}
+
+// Fields implements the FieldsSource interface
+func (s *ShapefileSource) Fields() []string {
+	return sourceFieldNames(s.SourceFields)
+}
+
+// GetFeatures implements the Source interface, retrieving the features that intersect the
+// requested tile from the spatial index
+func (s *ShapefileSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	return searchFeatures(s.index, s.SourceFields, req)
+}
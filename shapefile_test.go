@@ -0,0 +1,219 @@
+package tilenol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/project"
+	"github.com/stretchr/testify/assert"
+)
+
+// testShape is a shape record of a test shapefile, where a nil parts list is a null shape
+type testShape struct {
+	parts   [][]orb.Point
+	deleted bool
+	values  []string
+}
+
+// encodeTestShape encodes a shape record of the given type
+func encodeTestShape(shapeType int, shape testShape) []byte {
+	var buf bytes.Buffer
+	le := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	if shape.parts == nil {
+		le(int32(shapeTypeNull))
+		return buf.Bytes()
+	}
+	le(int32(shapeType))
+	if shapeType == shapeTypePoint {
+		le(shape.parts[0][0])
+		return buf.Bytes()
+	}
+	var points []orb.Point
+	var indexes []int32
+	for _, part := range shape.parts {
+		indexes = append(indexes, int32(len(points)))
+		points = append(points, part...)
+	}
+	bound := orb.MultiPoint(points).Bound()
+	le([4]float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]})
+	le(int32(len(indexes)))
+	le(int32(len(points)))
+	le(indexes)
+	le(points)
+	return buf.Bytes()
+}
+
+// encodeTestDBF encodes a dBASE file with the given fields and shape values
+func encodeTestDBF(fields []dbfField, shapes []testShape) []byte {
+	var buf bytes.Buffer
+	recordSize := 1
+	for _, field := range fields {
+		recordSize += field.length
+	}
+	buf.Write([]byte{3, 124, 1, 1})
+	binary.Write(&buf, binary.LittleEndian, uint32(len(shapes)))
+	binary.Write(&buf, binary.LittleEndian, uint16(32+32*len(fields)+1))
+	binary.Write(&buf, binary.LittleEndian, uint16(recordSize))
+	buf.Write(make([]byte, 20))
+	for _, field := range fields {
+		descriptor := make([]byte, 32)
+		copy(descriptor, field.name)
+		descriptor[11] = field.kind
+		descriptor[16] = byte(field.length)
+		descriptor[17] = byte(field.decimals)
+		buf.Write(descriptor)
+	}
+	buf.WriteByte(0x0D)
+	for _, shape := range shapes {
+		if shape.deleted {
+			buf.WriteByte('*')
+		} else {
+			buf.WriteByte(' ')
+		}
+		for i, field := range fields {
+			value := []byte(shape.values[i])
+			buf.Write(value)
+			buf.Write(bytes.Repeat([]byte{' '}, field.length-len(value)))
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeTestShapefile writes the main, index and dBASE files of a test shapefile, returning the
+// path of the main file
+func writeTestShapefile(t *testing.T, base string, shapeType int, fields []dbfField, shapes []testShape) string {
+	var shp, shx bytes.Buffer
+	header := make([]byte, shapefileHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], shapefileFileCode)
+	binary.LittleEndian.PutUint32(header[28:32], 1000)
+	binary.LittleEndian.PutUint32(header[32:36], uint32(shapeType))
+	shp.Write(header)
+	shx.Write(header)
+	for i, shape := range shapes {
+		content := encodeTestShape(shapeType, shape)
+		binary.Write(&shx, binary.BigEndian, [2]int32{int32(shp.Len() / 2), int32(len(content) / 2)})
+		binary.Write(&shp, binary.BigEndian, [2]int32{int32(i + 1), int32(len(content) / 2)})
+		shp.Write(content)
+	}
+	for ext, data := range map[string][]byte{
+		".shp": shp.Bytes(),
+		".shx": shx.Bytes(),
+		".dbf": encodeTestDBF(fields, shapes),
+	} {
+		if err := os.WriteFile(base+ext, data, 0644); err != nil {
+			t.Fatalf("Could not write test shapefile: %v", err)
+		}
+	}
+	return base + ".shp"
+}
+
+func TestReadShapefilePolygons(t *testing.T) {
+	fields := []dbfField{
+		{name: "NAME", kind: 'C', length: 16},
+		{name: "POP", kind: 'N', length: 10},
+		{name: "AREA", kind: 'F', length: 12, decimals: 3},
+		{name: "ACTIVE", kind: 'L', length: 1},
+		{name: "UPDATED", kind: 'D', length: 8},
+	}
+	outer := []orb.Point{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}
+	hole := []orb.Point{{2, 2}, {4, 2}, {4, 4}, {2, 4}, {2, 2}}
+	other := []orb.Point{{20, 0}, {20, 5}, {25, 5}, {25, 0}, {20, 0}}
+	path := writeTestShapefile(t, filepath.Join(t.TempDir(), "regions"), shapeTypePolygon, fields, []testShape{
+		{parts: [][]orb.Point{outer, other, hole}, values: []string{"North", "1200", "12.500", "T", "20240131"}},
+		{parts: [][]orb.Point{outer}, deleted: true, values: []string{"Deleted", "0", "0", "F", ""}},
+		{values: []string{"Empty", "", "*****", "?", ""}},
+	})
+
+	features, err := readShapefile(path)
+	assert.Nil(t, err)
+	assert.Len(t, features, 2)
+
+	assert.Equal(t, 1, features[0].ID)
+	assert.Equal(t, geojson.Properties{
+		"NAME":    "North",
+		"POP":     int64(1200),
+		"AREA":    12.5,
+		"ACTIVE":  true,
+		"UPDATED": "2024-01-31",
+	}, features[0].Properties)
+	mp, ok := features[0].Geometry.(orb.MultiPolygon)
+	assert.True(t, ok)
+	assert.Len(t, mp, 2)
+	assert.Len(t, mp[0], 2, "The hole should be assigned to the polygon that contains it")
+	assert.Len(t, mp[1], 1)
+	assert.Equal(t, orb.CCW, mp[0][0].Orientation())
+	assert.Equal(t, orb.CW, mp[0][1].Orientation())
+
+	assert.Equal(t, 3, features[1].ID)
+	assert.Nil(t, features[1].Geometry)
+	assert.Equal(t, geojson.Properties{"NAME": "Empty"}, features[1].Properties)
+}
+
+func TestShapefileSource(t *testing.T) {
+	dir := t.TempDir()
+	fields := []dbfField{{name: "NAME", kind: 'C', length: 16}}
+	sf := project.WGS84.ToMercator(orb.Point{-122.4, 37.8})
+	base := filepath.Join(dir, "CITIES")
+	writeTestShapefile(t, base, shapeTypePoint, fields, []testShape{
+		{parts: [][]orb.Point{{sf}}, values: []string{"San Francisco"}},
+		{parts: [][]orb.Point{{project.WGS84.ToMercator(orb.Point{-73.9, 40.7})}}, values: []string{"New York"}},
+	})
+	// Note: the sidecar files may use upper case extensions
+	for _, ext := range []string{".shp", ".shx", ".dbf"} {
+		assert.Nil(t, os.Rename(base+ext, base+strings.ToUpper(ext)))
+	}
+	assert.Nil(t, os.WriteFile(base+".prj", []byte(`PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",`+
+		`GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],`+
+		`PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],PROJECTION["Mercator_Auxiliary_Sphere"],`+
+		`PARAMETER["False_Easting",0.0],PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",0.0],`+
+		`PARAMETER["Standard_Parallel_1",0.0],PARAMETER["Auxiliary_Sphere_Type",0.0],UNIT["Meter",1.0]]`), 0644))
+
+	source, err := NewShapefileSource(&ShapefileConfig{
+		Path:         base + ".SHP",
+		SourceFields: map[string]string{"name": "NAME"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "shapefile", Layer{source: source}.SourceType())
+	assert.Equal(t, []string{"name"}, source.(*ShapefileSource).Fields())
+
+	fc, err := source.GetFeatures(context.Background(), &TileRequest{X: 1, Y: 3, Z: 3})
+	assert.Nil(t, err)
+	assert.Len(t, fc.Features, 1)
+	assert.Equal(t, geojson.Properties{"name": "San Francisco"}, fc.Features[0].Properties)
+	point := fc.Features[0].Geometry.(orb.Point)
+	assert.InDelta(t, -122.4, point.X(), 1e-9)
+	assert.InDelta(t, 37.8, point.Y(), 1e-9)
+}
+
+func TestShapefileSourceInvalid(t *testing.T) {
+	_, err := NewShapefileSource(&ShapefileConfig{})
+	assert.Equal(t, MissingShapefilePathErr, err)
+
+	dir := t.TempDir()
+	fields := []dbfField{{name: "NAME", kind: 'C', length: 4}}
+	path := writeTestShapefile(t, filepath.Join(dir, "roads"), shapeTypePolyLine, fields, []testShape{
+		{parts: [][]orb.Point{{{0, 0}, {1, 1}}}, values: []string{"A"}},
+	})
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "roads.dbf"), encodeTestDBF(fields, nil), 0644))
+	_, err = NewShapefileSource(&ShapefileConfig{Path: path})
+	assert.ErrorIs(t, err, InvalidShapefileErr)
+
+	assert.Nil(t, os.Remove(filepath.Join(dir, "roads.dbf")))
+	_, err = NewShapefileSource(&ShapefileConfig{Path: path})
+	assert.ErrorIs(t, err, InvalidShapefileErr)
+}
+
+func TestParseDBFValue(t *testing.T) {
+	assert.Equal(t, "Zürich", parseDBFValue(dbfField{kind: 'C'}, []byte("Z\xfcrich  "), dbfDecoder("ISO-8859-1")))
+	assert.Equal(t, "Zürich", parseDBFValue(dbfField{kind: 'C'}, []byte("Zürich  "), dbfDecoder("UTF-8")))
+	assert.Equal(t, 1.5, parseDBFValue(dbfField{kind: 'N'}, []byte("  1.5"), decodeUTF8))
+	assert.Equal(t, int64(-42), parseDBFValue(dbfField{kind: 'I'}, []byte{0xd6, 0xff, 0xff, 0xff}, decodeUTF8))
+	assert.Nil(t, parseDBFValue(dbfField{kind: 'N'}, []byte("abc"), decodeUTF8))
+}
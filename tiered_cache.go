@@ -0,0 +1,217 @@
+package tilenol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/paulmach/orb/maptile"
+)
+
+// MaxPendingTierWrites is the maximum number of asynchronous writes to the slower tiers of a
+// TieredCache that can be pending at once, beyond which these writes are dropped
+const MaxPendingTierWrites = 1024
+
+// TieredCache is a Cache implementation that chains several caches together, ordered from
+// fastest to slowest. Reads fall through the tiers and back-fill the faster tiers, while
+// writes go to every tier.
+type TieredCache struct {
+	// Tiers are the chained caches, ordered from fastest to slowest
+	Tiers []Cache
+	// AsyncWrites configures whether or not writes to all but the first tier happen in the
+	// background. Note that these writes are dropped (i.e. only stored in the first tier) when
+	// too many of them are pending, or while tiles are being purged
+	AsyncWrites bool
+
+	mu sync.Mutex
+	// pending is the number of asynchronous writes in progress
+	pending int
+	// purges is the number of tile purges in progress
+	purges int
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewTieredCache creates a new TieredCache given a list of tier CacheConfig's. Note that the
+// tiers cannot configure AsyncWrites or Staleness, which only apply to the top-level cache.
+func NewTieredCache(configs []CacheConfig, asyncWrites bool) (Cache, error) {
+	for i := range configs {
+		if configs[i].AsyncWrites || configs[i].Staleness != (StalenessConfig{}) {
+			return nil, InvalidCacheTierErr
+		}
+	}
+	var tiers []Cache
+	for i := range configs {
+		cache, err := CreateCache(&configs[i])
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, cache)
+	}
+	return &TieredCache{Tiers: tiers, AsyncWrites: asyncWrites}, nil
+}
+
+// Exists checks whether or not any tier has a value for the given key
+func (t *TieredCache) Exists(key string) bool {
+	for _, tier := range t.Tiers {
+		if tier.Exists(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get retrieves the value from the fastest tier that has it, back-filling all of the faster
+// tiers that didn't. Note that tiers are read directly (without checking Exists first), so
+// that each tier costs at most a single round trip.
+func (t *TieredCache) Get(key string) ([]byte, error) {
+	var lastErr error = ErrNoValue
+	for i, tier := range t.Tiers {
+		val, err := tier.Get(key)
+		if err != nil {
+			if err != ErrNoValue {
+				Logger.Warningf("Failed to retrieve key [%s] from cache tier %d: %s", key, i, err)
+				lastErr = err
+			}
+			continue
+		}
+		for _, faster := range t.Tiers[:i] {
+			if err := faster.Put(key, val); err != nil {
+				Logger.Warningf("Failed to back-fill key [%s] in cache tier: %s", key, err)
+			}
+		}
+		return val, nil
+	}
+	return nil, lastErr
+}
+
+// Put stores a new value in every tier
+func (t *TieredCache) Put(key string, val []byte) error {
+	if len(t.Tiers) == 0 {
+		return nil
+	}
+	if !t.AsyncWrites {
+		var errs []error
+		for i, tier := range t.Tiers {
+			if err := tier.Put(key, val); err != nil {
+				errs = append(errs, fmt.Errorf("cache tier %d: %w", i, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	// Note: the first tier is always written synchronously, so that the value is immediately
+	// available to subsequent reads
+	err := t.Tiers[0].Put(key, val)
+	if len(t.Tiers) > 1 {
+		if !t.startAsyncWrite() {
+			Logger.Debugf("Dropping write of key [%s] to slower cache tiers", key)
+			return err
+		}
+		go func() {
+			defer t.finishAsyncWrite()
+			for i, tier := range t.Tiers[1:] {
+				if err := tier.Put(key, val); err != nil {
+					Logger.Warningf("Failed to store key [%s] in cache tier %d: %s", key, i+1, err)
+				}
+			}
+		}()
+	}
+	return err
+}
+
+// startAsyncWrite reserves a slot for an asynchronous write, returning false if the write
+// should be dropped instead
+func (t *TieredCache) startAsyncWrite() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || t.purges > 0 || t.pending >= MaxPendingTierWrites {
+		return false
+	}
+	t.pending++
+	t.wg.Add(1)
+	return true
+}
+
+// finishAsyncWrite releases the slot of a completed asynchronous write
+func (t *TieredCache) finishAsyncWrite() {
+	t.mu.Lock()
+	t.pending--
+	t.mu.Unlock()
+	t.wg.Done()
+}
+
+// Delete removes the value for a given key from every tier
+func (t *TieredCache) Delete(key string) error {
+	var errs []error
+	for i, tier := range t.Tiers {
+		if err := tier.Delete(key); err != nil {
+			errs = append(errs, fmt.Errorf("cache tier %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PurgeTiles implements the TilePurger interface by purging every tier (after any pending
+// asynchronous writes, while new ones are dropped), returning the largest number of entries
+// deleted from a single tier. Note that every tier must support purging tiles.
+func (t *TieredCache) PurgeTiles(layerKey string, match func(maptile.Tile) bool) (uint64, error) {
+	t.mu.Lock()
+	t.purges++
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.purges--
+		t.mu.Unlock()
+	}()
+	t.wg.Wait()
+
+	var count uint64
+	var errs []error
+	for i, tier := range t.Tiers {
+		purger, ok := tier.(TilePurger)
+		if !ok {
+			errs = append(errs, fmt.Errorf("cache tier %d does not support purging tiles", i))
+			continue
+		}
+		deleted, err := purger.PurgeTiles(layerKey, match)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cache tier %d: %w", i, err))
+		}
+		count = max(count, deleted)
+	}
+	return count, errors.Join(errs...)
+}
+
+// HealthCheck implements the HealthChecker interface by checking every tier that supports it
+func (t *TieredCache) HealthCheck(ctx context.Context) error {
+	var errs []error
+	for i, tier := range t.Tiers {
+		if checker, ok := tier.(HealthChecker); ok {
+			if err := checker.HealthCheck(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("cache tier %d: %w", i, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close waits for any pending asynchronous writes (dropping any new ones), and then closes
+// every tier
+func (t *TieredCache) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.wg.Wait()
+	var errs []error
+	for i, tier := range t.Tiers {
+		if closer, ok := tier.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("cache tier %d: %w", i, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
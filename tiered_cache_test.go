@@ -0,0 +1,136 @@
+package tilenol
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingCache struct {
+	NilCache
+}
+
+func (f *failingCache) Put(key string, val []byte) error {
+	return errors.New("cache unavailable")
+}
+
+func TestTieredCacheBackfill(t *testing.T) {
+	l1 := &countingCache{Cache: NewInMemoryCache()}
+	l2 := &countingCache{Cache: NewInMemoryCache()}
+	cache := &TieredCache{Tiers: []Cache{l1, l2}}
+
+	l2.Cache.Put("a", []byte("a"))
+	assert.True(t, cache.Exists("a"))
+
+	val, err := cache.Get("a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	assert.True(t, l1.Exists("a"), "Faster tier should have been back-filled")
+
+	// Subsequent reads should be served by the first tier
+	cache.Get("a")
+	assert.Equal(t, 1, l2.GetCounter)
+	assert.Equal(t, 2, l1.GetCounter)
+}
+
+func TestTieredCacheMiss(t *testing.T) {
+	cache := &TieredCache{Tiers: []Cache{NewInMemoryCache(), NewInMemoryCache()}}
+	assert.False(t, cache.Exists("a"))
+	_, err := cache.Get("a")
+	assert.Equal(t, ErrNoValue, err)
+}
+
+func TestTieredCachePut(t *testing.T) {
+	l1 := &countingCache{Cache: NewInMemoryCache()}
+	l2 := &countingCache{Cache: NewInMemoryCache()}
+	cache := &TieredCache{Tiers: []Cache{l1, l2}}
+	assert.Nil(t, cache.Put("a", []byte("a")))
+	assert.Equal(t, 1, l1.PutCounter)
+	assert.Equal(t, 1, l2.PutCounter)
+
+	failing := &TieredCache{Tiers: []Cache{NewInMemoryCache(), &failingCache{}}}
+	assert.NotNil(t, failing.Put("a", []byte("a")))
+}
+
+func TestTieredCacheAsyncPut(t *testing.T) {
+	l1 := NewInMemoryCache()
+	l2 := NewInMemoryCache()
+	cache := &TieredCache{Tiers: []Cache{l1, &failingCache{}, l2}, AsyncWrites: true}
+	assert.Nil(t, cache.Put("a", []byte("a")))
+	assert.True(t, l1.Exists("a"), "First tier should be written synchronously")
+
+	assert.Nil(t, cache.Close())
+	assert.True(t, l2.Exists("a"), "Slower tiers should be written once pending writes are flushed")
+}
+
+// blockingCache is a Cache whose writes block until it is released
+type blockingCache struct {
+	Cache
+	release chan struct{}
+}
+
+func (b *blockingCache) Put(key string, val []byte) error {
+	<-b.release
+	return b.Cache.Put(key, val)
+}
+
+func TestTieredCacheAsyncPutDropped(t *testing.T) {
+	l1 := NewInMemoryCache()
+	l2 := &blockingCache{Cache: NewInMemoryCache(), release: make(chan struct{})}
+	cache := &TieredCache{Tiers: []Cache{l1, l2}, AsyncWrites: true}
+	for i := 0; i < MaxPendingTierWrites; i++ {
+		assert.Nil(t, cache.Put(fmt.Sprintf("a/%d", i), []byte("a")))
+	}
+	assert.Nil(t, cache.Put("b", []byte("b")))
+	assert.True(t, l1.Exists("b"), "First tier should be written even if too many writes are pending")
+
+	close(l2.release)
+	assert.Nil(t, cache.Close())
+	assert.True(t, l2.Exists("a/0"))
+	assert.False(t, l2.Exists("b"), "Writes beyond the pending limit should be dropped")
+
+	assert.Nil(t, cache.Put("c", []byte("c")))
+	assert.False(t, l2.Exists("c"), "Writes after closing should be dropped")
+}
+
+func TestTieredCachePurgeTiles(t *testing.T) {
+	l1, l2 := NewInMemoryCache(), NewInMemoryCache()
+	cache := &TieredCache{Tiers: []Cache{l1, l2}}
+	cache.Put("a@1/0/0/0", []byte("a"))
+	l2.Put("a@1/1/0/0", []byte("a"))
+	count, err := cache.PurgeTiles("a@1", func(maptile.Tile) bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+	assert.False(t, cache.Exists("a@1/0/0/0"))
+	assert.False(t, cache.Exists("a@1/1/0/0"))
+
+	cache = &TieredCache{Tiers: []Cache{l1, &countingCache{Cache: l2}}}
+	_, err = cache.PurgeTiles("a@1", func(maptile.Tile) bool { return true })
+	assert.ErrorContains(t, err, "cache tier 1 does not support purging tiles")
+}
+
+func TestCreateTieredCache(t *testing.T) {
+	cacheConfig := &CacheConfig{Tiers: []CacheConfig{
+		{InMemory: &InMemoryConfig{}},
+		{Redis: &RedisConfig{}},
+	}}
+	cache, err := CreateCache(cacheConfig)
+	assert.Nil(t, err)
+	tiered, canCast := cache.(*TieredCache)
+	assert.True(t, canCast, "Did not create a TieredCache")
+	assert.IsType(t, &InMemoryCache{}, tiered.Tiers[0])
+	assert.IsType(t, &RedisCache{}, tiered.Tiers[1])
+
+	// Options of the top-level cache are rejected on tiers
+	for _, tier := range []CacheConfig{
+		{InMemory: &InMemoryConfig{}, AsyncWrites: true},
+		{InMemory: &InMemoryConfig{}, Staleness: StalenessConfig{SoftTTL: time.Minute}},
+	} {
+		_, err := CreateCache(&CacheConfig{Tiers: []CacheConfig{tier}})
+		assert.Equal(t, InvalidCacheTierErr, err)
+	}
+}
@@ -0,0 +1,239 @@
+package tilenol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/maptile/tilecover"
+)
+
+var (
+	// EmptyGeometryErr is the error returned when a GeoJSON document contains no geometries
+	EmptyGeometryErr = errors.New("GeoJSON document contains no geometries")
+)
+
+// WorldBound is the bound covering every Web Mercator tile
+var WorldBound = orb.Bound{Min: orb.Point{-180, -MaxLatitude}, Max: orb.Point{180, MaxLatitude}}
+
+// TileRange is a rectangular range of tiles at a single zoom level
+type TileRange struct {
+	Z    maptile.Zoom
+	MinX uint32
+	MinY uint32
+	MaxX uint32
+	MaxY uint32
+}
+
+// clampTileIndex clamps a tile index to the valid range for a zoom level
+func clampTileIndex(f float64, z maptile.Zoom) uint32 {
+	maxIdx := float64(uint32(1)<<uint32(z) - 1)
+	return uint32(math.Max(0, math.Min(maxIdx, f)))
+}
+
+// maxTileIndex computes the last tile index covering a fractional max edge, so that a bound
+// ending exactly on a tile edge does not include the neighbouring tile
+func maxTileIndex(min, max float64) float64 {
+	return math.Max(math.Floor(min), math.Ceil(max)-1)
+}
+
+// TileRangesForBound computes the ranges of tiles covering the given bound for every zoom
+// level between minZoom and maxZoom (inclusive)
+func TileRangesForBound(bound orb.Bound, minZoom, maxZoom int) []TileRange {
+	bound.Min[1] = math.Max(bound.Min[1], -MaxLatitude)
+	bound.Max[1] = math.Min(bound.Max[1], MaxLatitude)
+
+	var ranges []TileRange
+	for z := minZoom; z <= maxZoom; z++ {
+		zoom := maptile.Zoom(z)
+		// Note: tile Y indexes increase southwards, so the top-left tile holds the max latitude
+		topLeft := maptile.Fraction(orb.Point{bound.Min[0], bound.Max[1]}, zoom)
+		bottomRight := maptile.Fraction(orb.Point{bound.Max[0], bound.Min[1]}, zoom)
+		// Note: maptile clamps latitudes near the south pole to the last tile index rather than
+		// its fractional edge, which would otherwise exclude the bottom row of tiles
+		if bound.Min[1] < -85.0511 {
+			bottomRight[1] = float64(uint32(1) << uint32(z))
+		}
+		ranges = append(ranges, TileRange{
+			Z:    zoom,
+			MinX: clampTileIndex(math.Floor(topLeft[0]), zoom),
+			MinY: clampTileIndex(math.Floor(topLeft[1]), zoom),
+			MaxX: clampTileIndex(maxTileIndex(topLeft[0], bottomRight[0]), zoom),
+			MaxY: clampTileIndex(maxTileIndex(topLeft[1], bottomRight[1]), zoom),
+		})
+	}
+	return ranges
+}
+
+// Count returns the number of tiles in the range
+func (r TileRange) Count() uint64 {
+	return uint64(r.MaxX-r.MinX+1) * uint64(r.MaxY-r.MinY+1)
+}
+
+// Contains checks whether or not a tile is within the range
+func (r TileRange) Contains(t maptile.Tile) bool {
+	return t.Z == r.Z && t.X >= r.MinX && t.X <= r.MaxX && t.Y >= r.MinY && t.Y <= r.MaxY
+}
+
+// Each calls fn for every tile in the range, stopping at the first error
+func (r TileRange) Each(fn func(maptile.Tile) error) error {
+	for x := r.MinX; x <= r.MaxX; x++ {
+		for y := r.MinY; y <= r.MaxY; y++ {
+			if err := fn(maptile.New(x, y, r.Z)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CountTiles returns the total number of tiles in the given ranges
+func CountTiles(ranges []TileRange) uint64 {
+	var count uint64
+	for _, r := range ranges {
+		count += r.Count()
+	}
+	return count
+}
+
+// TileCover is the set of tiles covering an area over a range of zoom levels, where the area
+// is either a bound or an arbitrary geometry (e.g. a service area polygon). Note that the tiles
+// covering a geometry are computed once per zoom level, so a TileCover is not safe for
+// concurrent use.
+type TileCover struct {
+	// Bound is the area covered when no Geometry is set
+	Bound orb.Bound
+	// Geometry is the area covered, in WGS84 (optional)
+	Geometry orb.Geometry
+	// MinZoom is the minimum zoom level covered
+	MinZoom int
+	// MaxZoom is the maximum zoom level covered
+	MaxZoom int
+
+	// geometryCover holds the tiles covering the Geometry that were already computed, by zoom
+	// level
+	geometryCover map[maptile.Zoom][]maptile.Tile
+}
+
+// geometryTiles computes the tiles covering the geometry at a given zoom level, sorted in the
+// same column-major order as TileRange.Each
+func (c *TileCover) geometryTiles(z maptile.Zoom) ([]maptile.Tile, error) {
+	if tiles, ok := c.geometryCover[z]; ok {
+		return tiles, nil
+	}
+	set, err := tilecover.Geometry(c.Geometry, z)
+	if err != nil {
+		return nil, err
+	}
+	tiles := make([]maptile.Tile, 0, len(set))
+	for t := range set {
+		tiles = append(tiles, t)
+	}
+	sort.Slice(tiles, func(i, j int) bool {
+		if tiles[i].X != tiles[j].X {
+			return tiles[i].X < tiles[j].X
+		}
+		return tiles[i].Y < tiles[j].Y
+	})
+	if c.geometryCover == nil {
+		c.geometryCover = make(map[maptile.Zoom][]maptile.Tile)
+	}
+	c.geometryCover[z] = tiles
+	return tiles, nil
+}
+
+// Count returns the number of tiles in the cover
+func (c *TileCover) Count() (uint64, error) {
+	if c.Geometry == nil {
+		return CountTiles(TileRangesForBound(c.Bound, c.MinZoom, c.MaxZoom)), nil
+	}
+	var count uint64
+	for z := c.MinZoom; z <= c.MaxZoom; z++ {
+		tiles, err := c.geometryTiles(maptile.Zoom(z))
+		if err != nil {
+			return 0, err
+		}
+		count += uint64(len(tiles))
+	}
+	return count, nil
+}
+
+// Each calls fn for every tile in the cover in a deterministic order (by zoom level, then by
+// column and row), stopping at the first error
+func (c *TileCover) Each(fn func(maptile.Tile) error) error {
+	if c.Geometry == nil {
+		for _, r := range TileRangesForBound(c.Bound, c.MinZoom, c.MaxZoom) {
+			if err := r.Each(fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for z := c.MinZoom; z <= c.MaxZoom; z++ {
+		tiles, err := c.geometryTiles(maptile.Zoom(z))
+		if err != nil {
+			return err
+		}
+		for _, t := range tiles {
+			if err := fn(t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ParseCoverGeometry parses the geometry of a GeoJSON document to be used as a TileCover area,
+// which can either be a FeatureCollection, a Feature or a bare geometry
+func ParseCoverGeometry(data []byte) (orb.Geometry, error) {
+	var doc struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var geoms orb.Collection
+	switch doc.Type {
+	case "FeatureCollection":
+		fc, err := geojson.UnmarshalFeatureCollection(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fc.Features {
+			if f.Geometry != nil {
+				geoms = append(geoms, f.Geometry)
+			}
+		}
+	case "Feature":
+		f, err := geojson.UnmarshalFeature(data)
+		if err != nil {
+			return nil, err
+		}
+		if f.Geometry != nil {
+			geoms = append(geoms, f.Geometry)
+		}
+	default:
+		g, err := geojson.UnmarshalGeometry(data)
+		if err != nil {
+			return nil, fmt.Errorf("Unsupported GeoJSON document: %w", err)
+		}
+		if g.Coordinates != nil {
+			geoms = append(geoms, g.Coordinates)
+		}
+	}
+
+	switch len(geoms) {
+	case 0:
+		return nil, EmptyGeometryErr
+	case 1:
+		return geoms[0], nil
+	default:
+		return geoms, nil
+	}
+}
@@ -0,0 +1,75 @@
+package tilenol
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTileRangesForWorld(t *testing.T) {
+	ranges := TileRangesForBound(WorldBound, 0, 3)
+	assert.Len(t, ranges, 4)
+	for _, r := range ranges {
+		n := uint64(1) << uint64(r.Z)
+		assert.Equal(t, n*n, r.Count())
+	}
+	assert.Equal(t, uint64(1+4+16+64), CountTiles(ranges))
+}
+
+func TestTileRangesForBound(t *testing.T) {
+	bound := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{10, 10}}
+	ranges := TileRangesForBound(bound, 1, 1)
+	assert.Equal(t, []TileRange{{Z: 1, MinX: 1, MinY: 0, MaxX: 1, MaxY: 0}}, ranges)
+
+	var tiles []maptile.Tile
+	ranges[0].Each(func(tile maptile.Tile) error {
+		tiles = append(tiles, tile)
+		return nil
+	})
+	assert.Equal(t, []maptile.Tile{maptile.New(1, 0, 1)}, tiles)
+}
+
+func TestTileRangesClampsBound(t *testing.T) {
+	bound := orb.Bound{Min: orb.Point{-200, -90}, Max: orb.Point{200, 90}}
+	assert.Equal(t, TileRangesForBound(WorldBound, 2, 2), TileRangesForBound(bound, 2, 2))
+}
+
+func TestTileCoverGeometry(t *testing.T) {
+	// A polygon covering the north-east quadrant (excluding the edges)
+	poly := orb.Polygon{{{1, 1}, {170, 1}, {170, 80}, {1, 80}, {1, 1}}}
+	cover := TileCover{Geometry: poly, MinZoom: 0, MaxZoom: 1}
+	count, err := cover.Count()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+	assert.Len(t, cover.geometryCover, 2, "The tiles of each zoom level should only be computed once")
+
+	var tiles []maptile.Tile
+	err = cover.Each(func(tile maptile.Tile) error {
+		tiles = append(tiles, tile)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []maptile.Tile{maptile.New(0, 0, 0), maptile.New(1, 0, 1)}, tiles)
+}
+
+func TestParseCoverGeometry(t *testing.T) {
+	geom, err := ParseCoverGeometry([]byte(`{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}`))
+	assert.Nil(t, err)
+	assert.IsType(t, orb.Polygon{}, geom)
+
+	geom, err = ParseCoverGeometry([]byte(`{"type": "Feature", "properties": {}, "geometry": {"type": "Point", "coordinates": [1, 1]}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, orb.Point{1, 1}, geom)
+
+	geom, err = ParseCoverGeometry([]byte(`{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {}, "geometry": {"type": "Point", "coordinates": [1, 1]}},
+		{"type": "Feature", "properties": {}, "geometry": {"type": "Point", "coordinates": [2, 2]}}
+	]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, orb.Collection{orb.Point{1, 1}, orb.Point{2, 2}}, geom)
+
+	_, err = ParseCoverGeometry([]byte(`{"type": "FeatureCollection", "features": []}`))
+	assert.Equal(t, EmptyGeometryErr, err)
+}
@@ -0,0 +1,150 @@
+package tilenol
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/paulmach/orb"
+)
+
+const (
+	// TileJSONVersion is the version of the TileJSON spec implemented by the server
+	TileJSONVersion = "3.0.0"
+	// MaxLatitude is the maximum latitude that can be represented in Web Mercator tiles
+	MaxLatitude = 85.0511287798066
+)
+
+// VectorLayer describes a single vector layer in a TileJSON document
+type VectorLayer struct {
+	ID          string            `json:"id"`
+	Description string            `json:"description"`
+	Minzoom     int               `json:"minzoom"`
+	Maxzoom     int               `json:"maxzoom"`
+	Fields      map[string]string `json:"fields"`
+}
+
+// TileJSON is a TileJSON 3.0 document describing a combination of tile server layers
+type TileJSON struct {
+	TileJSON     string        `json:"tilejson"`
+	Name         string        `json:"name"`
+	Description  string        `json:"description,omitempty"`
+	Scheme       string        `json:"scheme"`
+	Tiles        []string      `json:"tiles"`
+	Minzoom      int           `json:"minzoom"`
+	Maxzoom      int           `json:"maxzoom"`
+	Bounds       [4]float64    `json:"bounds"`
+	VectorLayers []VectorLayer `json:"vector_layers"`
+}
+
+// effectiveMaxzoom returns the max zoom for a layer, taking into account that a Maxzoom of
+// 0 means that the layer has no upper bound
+func effectiveMaxzoom(layer Layer) int {
+	if layer.Maxzoom == 0 {
+		return MaxZoom
+	}
+	return layer.Maxzoom
+}
+
+// NewTileJSON creates a TileJSON document for the given layers, using tileURL as the tile
+// URL template. Note that the bounds are the union of the bounds of the layers that configure
+// them, or the whole world if none of them do
+func NewTileJSON(name string, layers []Layer, tileURL string) *TileJSON {
+	tj := &TileJSON{
+		TileJSON:     TileJSONVersion,
+		Name:         name,
+		Scheme:       "xyz",
+		Tiles:        []string{tileURL},
+		Minzoom:      MaxZoom,
+		Maxzoom:      MinZoom,
+		Bounds:       [4]float64{-180, -MaxLatitude, 180, MaxLatitude},
+		VectorLayers: []VectorLayer{},
+	}
+	if len(layers) == 0 {
+		tj.Minzoom, tj.Maxzoom = MinZoom, MaxZoom
+	}
+	if len(layers) == 1 {
+		tj.Description = layers[0].Description
+	}
+	var bounds *orb.Bound
+	for _, layer := range layers {
+		if layer.Bounds != nil {
+			if bounds == nil {
+				bounds = &orb.Bound{Min: layer.Bounds.Min, Max: layer.Bounds.Max}
+			} else {
+				*bounds = bounds.Union(*layer.Bounds)
+			}
+		}
+		fields := make(map[string]string)
+		for _, field := range layer.Fields() {
+			fields[field] = ""
+		}
+		maxzoom := effectiveMaxzoom(layer)
+		tj.VectorLayers = append(tj.VectorLayers, VectorLayer{
+			ID:          layer.Name,
+			Description: layer.Description,
+			Minzoom:     layer.Minzoom,
+			Maxzoom:     maxzoom,
+			Fields:      fields,
+		})
+		if layer.Minzoom < tj.Minzoom {
+			tj.Minzoom = layer.Minzoom
+		}
+		if maxzoom > tj.Maxzoom {
+			tj.Maxzoom = maxzoom
+		}
+	}
+	if bounds != nil {
+		tj.Bounds = [4]float64{bounds.Min[0], bounds.Min[1], bounds.Max[0], bounds.Max[1]}
+	}
+	return tj
+}
+
+// uniqueNames removes repeated names from the given list, preserving their order
+func uniqueNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	var out []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// requestBaseURL reconstructs the externally-visible base URL for the incoming request
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// getTileJSON computes a TileJSON document for the requested layers
+func (s *Server) getTileJSON(w http.ResponseWriter, r *http.Request) {
+	requestedLayers := chi.URLParam(r, "layers")
+
+	var layers = s.Layers
+	if requestedLayers != AllLayers {
+		layers = filterLayersByNames(layers, uniqueNames(strings.Split(requestedLayers, ",")))
+		if len(layers) == 0 {
+			s.handleError(NotFoundError{fmt.Sprintf("No layers found for [%s].", requestedLayers)}, w, r)
+			return
+		}
+	}
+
+	tileURL := fmt.Sprintf("%s/%s/{z}/{x}/{y}.mvt", requestBaseURL(r), requestedLayers)
+	if r.URL.RawQuery != "" {
+		tileURL = strings.Join([]string{tileURL, r.URL.RawQuery}, "?")
+	}
+
+	tj := NewTileJSON(requestedLayers, layers, tileURL)
+
+	s.writeJSON(w, r, tj)
+}
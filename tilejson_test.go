@@ -0,0 +1,98 @@
+package tilenol
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/assert"
+)
+
+type fieldsSource struct {
+	fields []string
+}
+
+func (f *fieldsSource) GetFeatures(ctx context.Context, req *TileRequest) (*geojson.FeatureCollection, error) {
+	return geojson.NewFeatureCollection(), nil
+}
+
+func (f *fieldsSource) Fields() []string {
+	return f.fields
+}
+
+func TestTileJSONZoomRange(t *testing.T) {
+	layers := []Layer{
+		Layer{Name: "a", Minzoom: 4, Maxzoom: 10, source: &NilSource{}},
+		Layer{Name: "b", Minzoom: 8, source: &NilSource{}},
+	}
+	tj := NewTileJSON("a,b", layers, "http://localhost/a,b/{z}/{x}/{y}.mvt")
+	assert.Equal(t, 4, tj.Minzoom)
+	assert.Equal(t, MaxZoom, tj.Maxzoom)
+	assert.Len(t, tj.VectorLayers, 2)
+	assert.Equal(t, 10, tj.VectorLayers[0].Maxzoom)
+	assert.Equal(t, MaxZoom, tj.VectorLayers[1].Maxzoom)
+}
+
+func TestTileJSONEndpoint(t *testing.T) {
+	layers := []Layer{
+		Layer{Name: "buildings", Description: "Building footprints", Minzoom: 14,
+			source: &fieldsSource{fields: []string{"height", "area"}}},
+		Layer{Name: "parcels", source: &NilSource{}},
+	}
+	server := &Server{Layers: layers, Cache: &NilCache{}}
+	api, _ := server.setupRoutes()
+
+	r := httptest.NewRequest("GET", "http://tiles.example.com/buildings/tile.json?q=height:>10", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	res := w.Result()
+	assert.Equal(t, 200, res.StatusCode)
+
+	var tj TileJSON
+	err := json.NewDecoder(res.Body).Decode(&tj)
+	assert.Nil(t, err)
+	assert.Equal(t, TileJSONVersion, tj.TileJSON)
+	assert.Equal(t, []string{"http://tiles.example.com/buildings/{z}/{x}/{y}.mvt?q=height:>10"}, tj.Tiles)
+	assert.Equal(t, 14, tj.Minzoom)
+	assert.Equal(t, "Building footprints", tj.Description)
+	assert.Len(t, tj.VectorLayers, 1)
+	assert.Contains(t, tj.VectorLayers[0].Fields, "height")
+	assert.Contains(t, tj.VectorLayers[0].Fields, "area")
+
+	r = httptest.NewRequest("GET", "/doesntexist/tile.json", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	assert.Equal(t, 404, w.Result().StatusCode)
+}
+
+func TestTileJSONBounds(t *testing.T) {
+	layers := []Layer{
+		Layer{Name: "a", source: &NilSource{}},
+		Layer{Name: "b", Bounds: &orb.Bound{Min: orb.Point{-10, -5}, Max: orb.Point{0, 5}}, source: &NilSource{}},
+		Layer{Name: "c", Bounds: &orb.Bound{Min: orb.Point{-5, 0}, Max: orb.Point{10, 20}}, source: &NilSource{}},
+	}
+	tj := NewTileJSON("a", layers[:1], "")
+	assert.Equal(t, [4]float64{-180, -MaxLatitude, 180, MaxLatitude}, tj.Bounds)
+
+	tj = NewTileJSON("a,b,c", layers, "")
+	assert.Equal(t, [4]float64{-10, -5, 10, 20}, tj.Bounds)
+	assert.Equal(t, orb.Bound{Min: orb.Point{-10, -5}, Max: orb.Point{0, 5}}, *layers[1].Bounds, "Layer bounds should not be modified")
+}
+
+func TestTileJSONDuplicateLayers(t *testing.T) {
+	server := &Server{Layers: []Layer{Layer{Name: "a", source: &NilSource{}}}, Cache: &NilCache{}}
+	api, _ := server.setupRoutes()
+
+	r := httptest.NewRequest("GET", "/a,a/tile.json", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	res := w.Result()
+	assert.Equal(t, 200, res.StatusCode)
+
+	var tj TileJSON
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&tj))
+	assert.Len(t, tj.VectorLayers, 1)
+}